@@ -0,0 +1,159 @@
+// +build grpc
+
+// gRPC API, served from the same process as the JSON one. Needs building
+// with -tags grpc
+
+package main
+
+import (
+	"net"
+	"flag"
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"github.com/matheusb-comp/go/pool/events"
+	"github.com/matheusb-comp/go/pool/protocols/voters"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+// Events buffered for each WatchInflation stream
+const GRPC_WATCH_BUFFER = 16
+
+var grpcListen string
+
+func init() {
+	flag.StringVar(&grpcListen, "grpc-listen", "",
+		"Address (host:port) to listen for gRPC requests (disabled if empty)")
+
+	srv := grpc.NewServer()
+	voters.RegisterVotersServer(srv, votersServer{})
+	services = append(services, service{
+		name: "gRPC",
+		serve: func() error {
+			if grpcListen == "" {
+				return nil
+			}
+			lis, err := net.Listen("tcp", grpcListen)
+			if err != nil {
+				return err
+			}
+			logger.WithField("listen", grpcListen).Info("Serving gRPC requests")
+			return srv.Serve(lis)
+		},
+		shutdown: func(ctx context.Context) error {
+			// Wait for the in-flight calls, unless the context expires first
+			done := make(chan struct{})
+			go func() {
+				srv.GracefulStop()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-ctx.Done():
+				srv.Stop()
+			}
+			return nil
+		},
+	})
+}
+
+// Implements voters.VotersServer with the same queries as the JSON API
+type votersServer struct{}
+
+func (votersServer) GetTotals(ctx context.Context, req *voters.PoolRequest) (*voters.Digest, error) {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	digest, err := getTotalsDB(poolOrDefault(req.Pool))
+	if err != nil {
+		logger.Error(err)
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+	return toDigestPB(digest), nil
+}
+
+func (votersServer) ListVoters(req *voters.PoolRequest, stream voters.Voters_ListVotersServer) error {
+	// Only the query holds the config, a slow client must not block a reload
+	configMutex.RLock()
+	v, err := getVotersDB(poolOrDefault(req.Pool))
+	configMutex.RUnlock()
+	if err != nil {
+		logger.Error(err)
+		return status.Error(codes.Internal, "internal server error")
+	}
+	for _, e := range toEntries(v) {
+		if err = stream.Send(toEntryPB(e)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (votersServer) GetVoter(ctx context.Context, req *voters.VoterRequest) (*voters.Entry, error) {
+	if len(req.Account) != 56 || req.Account[0] != 'G' {
+		return nil, status.Error(codes.InvalidArgument, "invalid account")
+	}
+
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	v, err := getVoterDB(poolOrDefault(req.Pool), req.Account)
+	if err != nil {
+		logger.Error(err)
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+	entries := toEntries(v)
+	if len(entries) == 0 {
+		return nil, status.Error(codes.NotFound, "account not found")
+	}
+	return toEntryPB(entries[0]), nil
+}
+
+func (votersServer) WatchInflation(req *voters.PoolRequest, stream voters.Voters_WatchInflationServer) error {
+	if horizonURL == "" {
+		return status.Error(codes.Unavailable, "ledger stream disabled")
+	}
+	pool := poolOrDefault(req.Pool)
+
+	ch := hub.Subscribe(GRPC_WATCH_BUFFER)
+	defer hub.Unsubscribe(ch)
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e := <-ch:
+			if e.Type != events.INFLATION {
+				continue
+			}
+			configMutex.RLock()
+			digest, err := getTotalsDB(pool)
+			configMutex.RUnlock()
+			if err != nil {
+				logger.Error(err)
+				return status.Error(codes.Internal, "internal server error")
+			}
+			err = stream.Send(&voters.InflationEvent{
+				Ledger: e.Ledger,
+				ClosedAt: e.ClosedAt.Unix(),
+				TotalCoins: e.TotalCoins,
+				Totals: toDigestPB(digest),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func toDigestPB(d *snapshot.Digest) *voters.Digest {
+	return &voters.Digest{Pool: d.Pool, Voters: d.Voters, Votes: d.Votes}
+}
+
+func toEntryPB(e snapshot.Entry) *voters.Entry {
+	pb := &voters.Entry{Account: e.ID, Balance: e.Bal}
+	for _, d := range e.Data {
+		pb.Data = append(pb.Data, &voters.Data{Name: d.Name, Value: d.Value})
+	}
+	return pb
+}
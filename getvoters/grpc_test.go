@@ -0,0 +1,132 @@
+// +build grpc
+
+package main
+
+import (
+	"io"
+	"net"
+	"time"
+	"context"
+	"testing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"github.com/matheusb-comp/go/pool/events"
+	"github.com/matheusb-comp/go/pool/protocols/voters"
+)
+
+// Client of a gRPC server with the voters API, stopped with the returned func
+func dialVoters(t *testing.T) (voters.VotersClient, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	voters.RegisterVotersServer(srv, votersServer{})
+	go srv.Serve(lis)
+
+	cc, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return voters.NewVotersClient(cc), func() {
+		cc.Close()
+		srv.Stop()
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	core := setup()
+	client, stop := dialVoters(t)
+	defer stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+	defer cancel()
+
+	digest, err := client.GetTotals(ctx, &voters.PoolRequest{Pool: otherPool})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest.Pool != otherPool || digest.Voters != 1 || digest.Votes != 50 {
+		t.Errorf("totals %v", digest)
+	}
+
+	stream, err := client.ListVoters(ctx, &voters.PoolRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []*voters.Entry
+	for {
+		e, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		list = append(list, e)
+	}
+	if len(list) != 2 {
+		t.Fatalf("%d voters, want 2", len(list))
+	}
+
+	tests := []struct {
+		name string
+		account string
+		code codes.Code
+	}{
+		{"voter", voterA, codes.OK},
+		{"not a voter", charity, codes.NotFound},
+		{"invalid account", "GABC", codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		e, err := client.GetVoter(ctx, &voters.VoterRequest{Account: tt.account})
+		if status.Code(err) != tt.code {
+			t.Errorf("%s: code %v, want %v", tt.name, status.Code(err), tt.code)
+		}
+		if tt.code == codes.OK && (e.Account != voterA || e.Balance != 1000 || len(e.Data) != 1) {
+			t.Errorf("%s: entry %v", tt.name, e)
+		}
+	}
+
+	core.Err = io.ErrUnexpectedEOF
+	if _, err = client.GetTotals(ctx, &voters.PoolRequest{}); status.Code(err) != codes.Internal {
+		t.Errorf("code %v with the DB down, want Internal", status.Code(err))
+	}
+}
+
+func TestGRPCWatchInflation(t *testing.T) {
+	setup()
+	client, stop := dialVoters(t)
+	defer stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+	defer cancel()
+
+	horizonURL = ""
+	w, err := client.WatchInflation(ctx, &voters.PoolRequest{})
+	if err == nil {
+		_, err = w.Recv()
+	}
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("code %v without the ledger stream, want Unavailable", status.Code(err))
+	}
+
+	horizonURL = "http://horizon"
+	if w, err = client.WatchInflation(ctx, &voters.PoolRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	// Only the inflation is sent, published until the stream is subscribed
+	go func() {
+		for ctx.Err() == nil {
+			hub.Publish(events.Event{Type: events.LEDGER, Ledger: 11})
+			hub.Publish(events.Event{Type: events.INFLATION, Ledger: 12, TotalCoins: "100.0000000"})
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	e, err := w.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Ledger != 12 || e.TotalCoins != "100.0000000" || e.Totals.Voters != 2 {
+		t.Errorf("event %v", e)
+	}
+}
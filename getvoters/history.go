@@ -0,0 +1,189 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"net/http"
+	"encoding/csv"
+	"github.com/matheusb-comp/go/pool/history"
+	"github.com/matheusb-comp/go/pool/getvoters"
+	"github.com/matheusb-comp/go/pool/logging"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+// Number of runs listed when the request doesn't set a limit, and the maximum
+const HISTORY_DEFAULT_LIMIT = 20
+const HISTORY_MAX_LIMIT = 100
+
+// Open the history store, if one is configured (nil otherwise)
+func openStore() (*history.Store, error) {
+	if historyConn == "" {
+		return nil, nil
+	}
+	return history.Open(&getvoters.ConnConfig{Conn: historyConn})
+}
+
+func closeStore() {
+	if store == nil {
+		return
+	}
+	if err := store.Close(); err != nil {
+		logger.Error(err)
+	}
+}
+
+// Latest inflation runs of the pool (<urlHistory>?pool=<ADDR>&limit=<N>)
+func listRuns(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		http.Error(w, "503 history not available", 503)
+		return
+	}
+	pool := poolFromParam(r)
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit < 1 {
+		limit = HISTORY_DEFAULT_LIMIT
+	}
+	if limit > HISTORY_MAX_LIMIT {
+		limit = HISTORY_MAX_LIMIT
+	}
+
+	runs, err := store.ListRuns(pool, limit)
+	if err != nil {
+		logging.FromRequest(r, logger).WithField(logging.FIELD_POOL, pool).Error(err)
+		http.Error(w, "500 internal server error", 500)
+		return
+	}
+	writeJSON(w, r, runs)
+}
+
+// Totals donated to each destination in the latest runs of the pool
+// (<urlDonations>?pool=<ADDR>&limit=<N>)
+func listDonations(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		http.Error(w, "503 history not available", 503)
+		return
+	}
+	pool := poolFromParam(r)
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit < 1 {
+		limit = HISTORY_DEFAULT_LIMIT
+	}
+	if limit > HISTORY_MAX_LIMIT {
+		limit = HISTORY_MAX_LIMIT
+	}
+
+	donations, err := store.ListDonations(pool, limit)
+	if err != nil {
+		logging.FromRequest(r, logger).WithField(logging.FIELD_POOL, pool).Error(err)
+		http.Error(w, "500 internal server error", 500)
+		return
+	}
+	writeJSON(w, r, donations)
+}
+
+// One past run (<urlHistory>/<LEDGER>) or its snapshot (<urlHistory>/<LEDGER>/snapshot)
+func getRun(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		http.Error(w, "503 history not available", 503)
+		return
+	}
+	pool := poolFromParam(r)
+	l := logging.FromRequest(r, logger).WithField(logging.FIELD_POOL, pool)
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, urlHistory + "/"), "/")
+	ledger, err := strconv.ParseInt(parts[0], 10, 32)
+	if err != nil || len(parts) > 2 || (len(parts) == 2 && parts[1] != "snapshot") {
+		http.NotFound(w, r)
+		return
+	}
+	l = l.WithField(logging.FIELD_LEDGER, ledger)
+
+	var data interface{}
+	if len(parts) == 2 {
+		data, err = store.GetSnapshot(pool, int32(ledger))
+	} else {
+		data, err = store.GetRun(pool, int32(ledger))
+	}
+	if err == history.ErrNotFound {
+		http.Error(w, "404 inflation run not found", 404)
+		return
+	}
+	if err != nil {
+		l.Error(err)
+		http.Error(w, "500 internal server error", 500)
+		return
+	}
+	writeJSON(w, r, data)
+}
+
+// Payouts of one voter in every run (<urlVoters>/<ACCOUNT>/payouts), as JSON
+// or as CSV for tax purposes (?format=csv)
+func getVoterPayouts(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, urlVoters + "/"), "/")
+	if len(parts) != 2 || parts[1] != "payouts" {
+		http.NotFound(w, r)
+		return
+	}
+	account := parts[0]
+	if len(account) != 56 || account[0] != 'G' {
+		http.Error(w, "400 invalid account", 400)
+		return
+	}
+	if store == nil {
+		http.Error(w, "503 history not available", 503)
+		return
+	}
+	pool := poolFromParam(r)
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit < 1 {
+		limit = HISTORY_DEFAULT_LIMIT
+	}
+	if limit > HISTORY_MAX_LIMIT {
+		limit = HISTORY_MAX_LIMIT
+	}
+
+	payouts, err := store.VoterPayouts(pool, account, limit)
+	if err != nil {
+		logging.FromRequest(r, logger).WithField(logging.FIELD_POOL, pool).Error(err)
+		http.Error(w, "500 internal server error", 500)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		writePayoutsCSV(w, r, account, payouts)
+		return
+	}
+	writeJSON(w, r, &snapshot.PayoutList{ID: account, Des: pool, Entries: payouts})
+}
+
+// One row per run, amounts in XLM (as Horizon shows them)
+func writePayoutsCSV(w http.ResponseWriter, r *http.Request, account string, payouts []snapshot.Payout) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"payouts-" + account + ".csv\"")
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"date", "ledger", "account", "balance", "gross", "fee",
+		"donations", "net", "owed", "carried", "transaction"})
+	for _, p := range payouts {
+		cw.Write([]string{
+			p.Date.UTC().Format("2006-01-02T15:04:05Z"),
+			strconv.Itoa(int(p.Ledger)),
+			p.Account,
+			snapshot.FormatAmount(p.Bal),
+			snapshot.FormatAmount(p.Gross),
+			snapshot.FormatAmount(p.Fee),
+			snapshot.FormatAmount(p.Donations),
+			snapshot.FormatAmount(p.Net),
+			snapshot.FormatAmount(p.Owed),
+			snapshot.FormatAmount(p.Carried),
+			p.Transaction,
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		logging.FromRequest(r, logger).WithError(err).Error("ERROR writing CSV response")
+	}
+}
@@ -1,15 +1,26 @@
 package main
 
 import (
-	"log"
+	"os"
 	"flag"
+	"sync"
+	"time"
 	"errors"
+	"syscall"
 	"strconv"
+	"context"
 	"net/http"
+	"os/signal"
 	"database/sql"
 	"encoding/json"
 	"encoding/base64"
 	_ "github.com/lib/pq"
+	"github.com/matheusb-comp/go/pool/config"
+	"github.com/matheusb-comp/go/pool/history"
+	"github.com/matheusb-comp/go/pool/getvoters"
+	"github.com/matheusb-comp/go/pool/logging"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+	"github.com/sirupsen/logrus"
 )
 
 const VOTERS_QUERY = `SELECT accounts.accountid, balance, dataname, datavalue
@@ -17,37 +28,31 @@ FROM accounts LEFT JOIN accountdata
 ON accountdata.accountid = accounts.accountid
 AND dataname LIKE $1 WHERE inflationdest = $2`
 
+const VOTER_QUERY = VOTERS_QUERY + ` AND accounts.accountid = $3`
+
 const VOTERS_NUMBER_QUERY = `SELECT COUNT(accountid)
 FROM accounts WHERE inflationdest = $1`
 
 const TOTAL_VOTES_QUERY = `SELECT SUM(balance)
 FROM accounts WHERE inflationdest = $1`
 
+// Maximum time to wait for in-flight requests when shutting down
+const SHUTDOWN_TIMEOUT = 15 * time.Second
+
+// Process exit status codes
+const EXIT_OK = 0
+const EXIT_ERROR = 1
+const EXIT_SHUTDOWN_ERROR = 2
+
+// Prefix of the environment variables overriding the options
+const ENV_PREFIX = "GETVOTERS"
+
 // JSON indent strings (https://golang.org/pkg/encoding/json/#Encoder.SetIndent)
 const JSON_INDENT_PREFIX = ""
 const JSON_INDENT_INDENT = ""
 
-// Data structure for the main page JSON
-type Digest struct {
-	Pool string 	`json:"address"`
-	Voters uint64 `json:"voters"`
-	Votes uint64	`json:"votes"`
-}
-
-// Data structures for mapping the voters JSON
-type Data struct {
-	Name string		`json:"dataname"`
-	Value string	`json:"datavalue"`
-}
-type Entry struct {
-	ID	string		`json:"account"`
-	Bal	uint64		`json:"balance"`
-	Data []Data		`json:"data"`
-}
-type VoterList struct {
-	Des string			`json:"inflationdest"`
-	Entries	[]Entry	`json:"entries"`
-}
+// The JSON data structures (Digest, VoterList, Entry and Data) are the ones
+// in pool/protocols/snapshot, shared with the watcher
 
 // Data structures to work internally (quicker access)
 type VoterData struct {
@@ -57,32 +62,42 @@ type VoterData struct {
 type Voters map[string]*VoterData
 
 // User-defined variables
-var dbUser, dbPass, dbName, dbHost, dbPort, dbConn string
+var dbConfig getvoters.ConnConfig
 var listenAddr, urlTotals, urlVoters, urlParam string
+var urlProof, snapshotFile string
+var urlHistory, historyConn, urlDonations string
+var horizonURL, urlEvents string
 var defaultPool, donationKey string
+var configFile string
+var logConfig logging.Config
+var networkConfig config.Network
+// Leveled logger, configured after the options are loaded
+var logger = logrus.New()
+// Applies the config file and environment over the flags
+var loader *config.Loader
+
+// Database connection, replaced when the configuration is reloaded.
+// Requests hold a read lock, so a reload waits for the in-flight ones
 var db *sql.DB
+var configMutex sync.RWMutex
+// History of the inflation runs (nil if not configured)
+var store *history.Store
+
+// Servers started along with the HTTP one (like the gRPC API, see grpc.go),
+// and stopped with it
+type service struct {
+	name string
+	serve func() error
+	shutdown func(ctx context.Context) error
+}
+var services []service
 
 func init() {
 	// Database flags
-	flag.StringVar(&dbUser, "user", "stellar",
-		"PostgreSQL user name to connect as")
-
-	flag.StringVar(&dbPass, "pass", "",
-		"Password to be used if the server demands password authentication")
-
-	flag.StringVar(&dbName, "db", "core", "The database name")
-
-	flag.StringVar(&dbHost, "host", "localhost",
-		"Name of host to connect to. If a host name begins with a slash, " +
-		"it specifies Unix-domain communication rather than TCP/IP communication")
-
-	flag.StringVar(&dbPort, "port", "5432",
-		"Port number to connect to at the server host, " +
-		"or socket file name extension for Unix-domain connections")
+	dbConfig.RegisterFlags(flag.CommandLine)
 
-	flag.StringVar(&dbConn, "conn", "",
-		"Optional custom PostgreSQL connection string. If provided, " +
-		"it's used instead of the other flags")
+	// Logging flags
+	logConfig.RegisterFlags(flag.CommandLine)
 
 	// Server flags
 	flag.StringVar(&listenAddr, "listen", "0.0.0.0:8080",
@@ -92,57 +107,275 @@ func init() {
 		"URL pattern in the default HTTP request multiplexer to get the totals")
 
 	flag.StringVar(&urlVoters, "voters", "/voters",
-		"URL pattern in the default HTTP request multiplexer to get the voters list")
+		"URL pattern in the default HTTP request multiplexer to get the voters list " +
+		"(<URL>/<ACCOUNT>/payouts gets the payouts of one voter)")
+
+	flag.StringVar(&urlProof, "proof", "/proof/",
+		"URL prefix in the default HTTP request multiplexer to get the " +
+		"inclusion proof of a voter in the snapshot (example: <URL><ACCOUNT>)")
+
+	flag.StringVar(&snapshotFile, "snapshot", "",
+		"Published snapshot file (written by the watcher) to build the proofs from")
+
+	flag.StringVar(&urlHistory, "history-url", "/history",
+		"URL pattern in the default HTTP request multiplexer to list the past " +
+		"inflation runs (<URL>/<LEDGER> and <URL>/<LEDGER>/snapshot get one of them)")
+
+	flag.StringVar(&urlDonations, "donations-url", "/donations",
+		"URL pattern in the default HTTP request multiplexer to list the totals " +
+		"donated to each destination in the past inflation runs")
+
+	flag.StringVar(&historyConn, "history", "",
+		"Optional PostgreSQL connection string (keyword/value or URL) of the " +
+		"history DB written by the watcher. Prefer history_file in the config " +
+		"file or " + ENV_PREFIX + "_HISTORY_FILE if it has a password")
+
+	flag.StringVar(&horizonURL, "horizon", "",
+		"Horizon server to stream the ledgers from, to push the totals and " +
+		"inflation events (disabled if empty)")
+
+	flag.StringVar(&urlEvents, "events", "/events",
+		"URL pattern in the default HTTP request multiplexer to stream the " +
+		"totals and inflation events, as Server-Sent Events (needs -horizon)")
 
 	flag.StringVar(&urlParam, "param", "pool",
 		"Parameter to expect in the HTTP GET request URL (example: <URL>?pool=<ADDR>)")
 
 	// Stellar flags
+	networkConfig.RegisterFlags(flag.CommandLine)
+
 	flag.StringVar(&defaultPool, "pool",
 		"GCCD6AJOYZCUAQLX32ZJF2MKFFAUJ53PVCFQI3RHWKL3V47QYE2BNAUT",
 		"Default inflationdest address to use")
 
 	flag.StringVar(&donationKey, "key", "lumenaut.net donation%",
 		"Format of key for a voter data pair to mark a donation")
+
+	// Configuration file (options can also be set as GETVOTERS_<OPTION>)
+	flag.StringVar(&configFile, "config", "",
+		"YAML file with the options (keyed by flag name). Environment variables " +
+		ENV_PREFIX + "_<OPTION> override it, and flags override both. " +
+		"Run with the arguments 'config validate' to only check the configuration")
 }
 
 func main() {
 	flag.Parse()
+	os.Exit(run())
+}
 
-	// Create a connection string only if one was not supplied
-	conn := dbConn
-	if len(conn) < 1 {
-		conn = "dbname=" + dbName +
-		" user=" + dbUser +
-		" password=" + dbPass +
-		" host=" + dbHost +
-		" port=" + dbPort
+// Serve requests until a signal is received, returning the exit status
+func run() int {
+	// Apply the config file and the environment (flags take priority)
+	var err error
+	loader, err = config.NewLoader(flag.CommandLine, configFile, ENV_PREFIX)
+	if err == nil {
+		err = loader.Load()
+	}
+	if err == nil {
+		err = logConfig.Apply(logger)
+	}
+	if err != nil {
+		logger.Error(err)
+		return EXIT_ERROR
+	}
+
+	// Only check the configuration
+	if flag.Arg(0) == "config" && flag.Arg(1) == "validate" {
+		return validateConfig()
 	}
 
 	// Try opening the connection with the DB
-	var err error
-	db, err = sql.Open("postgres", conn)
+	db, err = openDB()
 	if err != nil {
-		log.Fatal("ERROR opening DB connection: " + err.Error())
+		logger.Error(err)
+		return EXIT_ERROR
+	}
+	defer closeDB()
+
+	// Open the history DB, if configured
+	store, err = openStore()
+	if err != nil {
+		logger.Error(err)
+		return EXIT_ERROR
+	}
+	defer closeStore()
+
+	// Set the function to handle requests (logged by the middleware)
+	mux := http.NewServeMux()
+	mux.HandleFunc(urlTotals, withConfig(getTotals))
+	mux.HandleFunc(urlVoters, withConfig(getVoters))
+	mux.HandleFunc(urlVoters + "/", withConfig(getVoterPayouts))
+	mux.HandleFunc(urlProof, withConfig(getProof))
+	mux.HandleFunc(urlHistory, withConfig(listRuns))
+	mux.HandleFunc(urlHistory + "/", withConfig(getRun))
+	mux.HandleFunc(urlDonations, withConfig(listDonations))
+	// The event streams are long lived, so they don't hold the config lock
+	mux.HandleFunc(urlEvents, pushEvents)
+	srv := &http.Server{Addr: listenAddr, Handler: logging.AccessLog(logger, mux)}
+	srv.RegisterOnShutdown(func() { close(stopStreams) })
+
+	// Listen for the signals before starting the server
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+
+	// Stream the ledgers in the background, if a Horizon server is set
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if horizonURL != "" {
+		client, err := networkConfig.Client(horizonURL)
+		if err != nil {
+			logger.Error(err)
+			return EXIT_ERROR
+		}
+		go watchLedgers(ctx, client)
 	}
-	defer db.Close()
 
-	// Set the function to handle requests and start server
-	http.HandleFunc(urlTotals, getTotals)
-	http.HandleFunc(urlVoters, getVoters)
-	log.Fatal(http.ListenAndServe(listenAddr, nil))
+	// Start the server in the background (never returns nil)
+	srvErr := make(chan error, 1 + len(services))
+	go func() {
+		srvErr <- srv.ListenAndServe()
+	}()
+	logger.WithField("listen", listenAddr).Info("Serving HTTP requests")
+
+	// And the other services, that stop with the same error channel
+	for _, s := range services {
+		go func(s service) {
+			if err := s.serve(); err != nil {
+				srvErr <- errors.New("ERROR serving " + s.name + ": " + err.Error())
+			}
+		}(s)
+	}
+
+	for {
+		select {
+		case err = <-srvErr:
+			logger.WithError(err).Error("ERROR serving requests")
+			return EXIT_ERROR
+		case sig := <-sigs:
+			l := logger.WithField("signal", sig.String())
+			if sig == syscall.SIGHUP {
+				l.Info("Reloading configuration")
+				if err = reload(); err != nil {
+					l.Error(err)
+				}
+				continue
+			}
+			l.Info("Shutting down")
+			return shutdown(srv)
+		}
+	}
+}
+
+// Stop accepting connections and wait for the in-flight requests to finish
+func shutdown(srv *http.Server) int {
+	ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+
+	code := EXIT_OK
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.WithError(err).Error("ERROR draining HTTP requests")
+		code = EXIT_SHUTDOWN_ERROR
+	}
+	for _, s := range services {
+		if err := s.shutdown(ctx); err != nil {
+			logger.WithError(err).Error("ERROR stopping " + s.name)
+			code = EXIT_SHUTDOWN_ERROR
+		}
+	}
+	return code
+}
+
+// Open a new connection with the DB using the current configuration
+func openDB() (*sql.DB, error) {
+	return dbConfig.Open()
+}
+
+// Check the options that can be validated before starting the server
+func validateConfig() int {
+	checks := map[string]error{
+		"listen": config.ValidateListen(listenAddr),
+		"pool": config.ValidateAccount(defaultPool),
+	}
+	if len(horizonURL) > 0 {
+		checks["network"] = config.ValidateNetwork(&networkConfig, horizonURL)
+	}
+	if len(dbConfig.Conn) > 0 {
+		checks["conn"] = config.ValidateConn(dbConfig.Conn)
+	}
+	if len(dbConfig.SSLMode) > 0 {
+		checks["sslmode"] = config.ValidateSSLMode(dbConfig.SSLMode)
+	}
+
+	errs := config.Validate(checks)
+	for _, err := range errs {
+		logger.Error("ERROR - " + err.Error())
+	}
+	if len(errs) > 0 {
+		return EXIT_ERROR
+	}
+	logger.Info("Configuration OK")
+	return EXIT_OK
+}
+
+// Re-read the configuration and replace the DB connection. The URL patterns
+// and the listen address only change after a restart
+func reload() error {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	if err := loader.Load(); err != nil {
+		return err
+	}
+	if err := logConfig.Apply(logger); err != nil {
+		return err
+	}
+	d, err := openDB()
+	if err != nil {
+		return err
+	}
+	st, err := openStore()
+	if err != nil {
+		d.Close()
+		return err
+	}
+
+	// No request is using the old connections (the lock is held)
+	closeStore()
+	store = st
+	old := db
+	db = d
+	return old.Close()
+}
+
+// Keep the configuration from being reloaded while handling a request
+func withConfig(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		configMutex.RLock()
+		defer configMutex.RUnlock()
+		h(w, r)
+	}
+}
+
+func closeDB() {
+	if err := db.Close(); err != nil {
+		logger.WithError(err).Error("ERROR closing DB connection")
+	}
 }
 
 func poolFromParam(r *http.Request) string {
+	return poolOrDefault(r.URL.Query().Get(urlParam))
+}
+
+func poolOrDefault(pool string) string {
 	// Ignore the address if it doesn't have 56 characters and starts wiht a G
-	pool := r.URL.Query().Get(urlParam)
 	if len(pool) != len(defaultPool) || pool[0] != 'G' {
 		pool = defaultPool
 	}
 	return pool
 }
 
-func writeJSON(w http.ResponseWriter, data interface{}) {
+func writeJSON(w http.ResponseWriter, r *http.Request, data interface{}) {
 	// Inform the user of the content-type in the header
 	w.Header().Set("Content-Type", "application/json")
 
@@ -155,73 +388,92 @@ func writeJSON(w http.ResponseWriter, data interface{}) {
 	// Marshal data as JSON and send to w
 	err := js.Encode(data)
 	if err != nil {
-		log.Println("ERROR writing JSON/gzip-encoded response: " + err.Error())
+		logging.FromRequest(r, logger).WithError(err).Error(
+			"ERROR writing JSON/gzip-encoded response")
 	}
 }
 
 func getTotals(w http.ResponseWriter, r *http.Request) {
-	log.Println(
-		"Request: " + r.Method + " " + r.URL.String() +
-		" - From: " + r.RemoteAddr)
-
-	// Values for the total number of voters and sum of votes
-	var voters, votes uint64
 	pool := poolFromParam(r)
 
-	// QueryRow executes a query that is expected to return at most one row
-	err := db.QueryRow(VOTERS_NUMBER_QUERY, pool).Scan(&voters)
-	if err != nil {
-		log.Println("ERROR getting the number of voters: " + err.Error())
-		http.Error(w, "500 internal server error", 500)
-		return
-	}
-	err = db.QueryRow(TOTAL_VOTES_QUERY, pool).Scan(&votes)
+	digest, err := getTotalsDB(pool)
 	if err != nil {
-		log.Println("ERROR getting the total of votes: " + err.Error())
+		logging.FromRequest(r, logger).WithField(logging.FIELD_POOL, pool).Error(err)
 		http.Error(w, "500 internal server error", 500)
 		return
 	}
 
-	writeJSON(w, &Digest{Pool: pool, Voters: voters, Votes: votes})
+	writeJSON(w, r, digest)
 }
 
 func getVoters(w http.ResponseWriter, r *http.Request) {
-	log.Println(
-		"Request: " + r.Method + " " + r.URL.String() +
-		" - From: " + r.RemoteAddr)
-
 	pool := poolFromParam(r)
 
 	// Get the voters map from the DB
 	voters, err := getVotersDB(pool)
 	if err != nil {
-		log.Println(err)
+		logging.FromRequest(r, logger).WithField(logging.FIELD_POOL, pool).Error(err)
 		http.Error(w, "500 internal server error", 500)
 		return
 	}
 
 	// Create the structure that can be mapped to JSON
-	var vl VoterList
+	var vl snapshot.VoterList
 	vl.Des = pool
+	vl.Entries = toEntries(voters)
+	writeJSON(w, r, &vl)
+}
 
-	// Loop all voters and fill up the VoterList
+// Total number of voters and sum of votes of a pool
+func getTotalsDB(pool string) (*snapshot.Digest, error) {
+	// Values for the total number of voters and sum of votes
+	var voters, votes uint64
+
+	// QueryRow executes a query that is expected to return at most one row
+	err := db.QueryRow(VOTERS_NUMBER_QUERY, pool).Scan(&voters)
+	if err != nil {
+		return nil, errors.New("ERROR getting the number of voters: " + err.Error())
+	}
+	err = db.QueryRow(TOTAL_VOTES_QUERY, pool).Scan(&votes)
+	if err != nil {
+		return nil, errors.New("ERROR getting the total of votes: " + err.Error())
+	}
+
+	return &snapshot.Digest{Pool: pool, Voters: voters, Votes: votes}, nil
+}
+
+// Convert the voters map to entries, in the same order as the snapshot files
+func toEntries(voters Voters) []snapshot.Entry {
+	var entries []snapshot.Entry
+
+	// Loop all voters and fill up the entries
 	for key, value := range voters {
-		entry := Entry{ID: key, Bal: value.Balance}
+		entry := snapshot.Entry{ID: key, Bal: value.Balance}
 		// Loop all the data for this voter (can be nil)
 		for k, v := range value.Data {
-			data := Data{Name: k, Value: v}
+			data := snapshot.Data{Name: k, Value: v}
 			entry.Data = append(entry.Data, data)
 		}
 		// Append this voter (and data) to the list
-		vl.Entries = append(vl.Entries, entry)
+		entries = append(entries, entry)
 	}
 
-	writeJSON(w, &vl)
+	snapshot.SortEntries(entries)
+	return entries
 }
 
 func getVotersDB(pool string) (Voters, error) {
+	return queryVoters(VOTERS_QUERY, donationKey, pool)
+}
+
+// Get a single voter of the pool (the map is empty if it's not a voter)
+func getVoterDB(pool, account string) (Voters, error) {
+	return queryVoters(VOTER_QUERY, donationKey, pool, account)
+}
+
+func queryVoters(query string, args ...interface{}) (Voters, error) {
 	// Try executing the query
-	rows, err := db.Query(VOTERS_QUERY, donationKey, pool)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, errors.New("ERROR executing query: " + err.Error())
 	}
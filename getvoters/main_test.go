@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"net/http"
+	"io/ioutil"
+	"encoding/json"
+	"net/http/httptest"
+	"github.com/matheusb-comp/go/pool/fixtures"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+var (
+	pool = fixtures.Address(0)
+	otherPool = fixtures.Address(1)
+	voterA = fixtures.Address(2)
+	voterB = fixtures.Address(3)
+	charity = fixtures.Address(10)
+)
+
+// Core DB with two voters of the pool (one donating) and one of another pool
+func setup() *fixtures.Core {
+	core := &fixtures.Core{}
+	core.AddAccount(fixtures.Account{ID: voterA, Balance: 1000, InflationDest: pool,
+		Data: map[string]string{"lumenaut.net donation": "10%" + charity, "other": "ignored"}})
+	core.AddAccount(fixtures.Account{ID: voterB, Balance: 300, InflationDest: pool})
+	core.AddAccount(fixtures.Account{ID: charity, Balance: 50, InflationDest: otherPool})
+
+	db = core.DB()
+	defaultPool, donationKey = pool, "lumenaut.net donation%"
+	logger.Out = ioutil.Discard
+	return core
+}
+
+// Response of a handler to a GET of the URL, decoded in data if it's OK
+func get(t *testing.T, h http.HandlerFunc, url string, data interface{}) int {
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", url, nil))
+	if w.Code == http.StatusOK && data != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code
+}
+
+func TestGetTotals(t *testing.T) {
+	tests := []struct {
+		name string
+		url string
+		broken bool
+		code int
+		want snapshot.Digest
+	}{
+		{name: "default pool", url: "/totals", code: 200,
+			want: snapshot.Digest{Pool: pool, Voters: 2, Votes: 1300}},
+		{name: "other pool", url: "/totals?pool=" + otherPool, code: 200,
+			want: snapshot.Digest{Pool: otherPool, Voters: 1, Votes: 50}},
+		{name: "invalid pool", url: "/totals?pool=GABC", code: 200,
+			want: snapshot.Digest{Pool: pool, Voters: 2, Votes: 1300}},
+		{name: "DB down", url: "/totals", broken: true, code: 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core := setup()
+			if tt.broken {
+				core.Err = errors.New("connection refused")
+			}
+			var got snapshot.Digest
+			if code := get(t, withConfig(getTotals), tt.url, &got); code != tt.code {
+				t.Fatalf("status %d, want %d", code, tt.code)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetVoters(t *testing.T) {
+	core := setup()
+
+	var got snapshot.VoterList
+	if code := get(t, withConfig(getVoters), "/voters", &got); code != 200 {
+		t.Fatalf("status %d", code)
+	}
+	if got.Des != pool || len(got.Entries) != 2 {
+		t.Fatalf("voters %+v", got)
+	}
+	// Same order as the snapshot files, only the donation data
+	entries := []snapshot.Entry{
+		{ID: voterA, Bal: 1000, Data: []snapshot.Data{{Name: "lumenaut.net donation", Value: "10%" + charity}}},
+		{ID: voterB, Bal: 300},
+	}
+	snapshot.SortEntries(entries)
+	for i, e := range entries {
+		g := got.Entries[i]
+		if g.ID != e.ID || g.Bal != e.Bal || len(g.Data) != len(e.Data) ||
+			(len(e.Data) > 0 && g.Data[0] != e.Data[0]) {
+			t.Errorf("entry %d: %+v, want %+v", i, g, e)
+		}
+	}
+
+	core.Err = errors.New("connection refused")
+	if code := get(t, withConfig(getVoters), "/voters", nil); code != 500 {
+		t.Errorf("status %d with the DB down, want 500", code)
+	}
+}
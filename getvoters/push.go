@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"time"
+	"net/http"
+	"encoding/json"
+	"github.com/matheusb-comp/go/pool/events"
+	"github.com/matheusb-comp/go/pool/logging"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+// Events buffered for each client, and how often to keep the connection alive
+const PUSH_BUFFER = 16
+const PUSH_KEEPALIVE = 30 * time.Second
+
+// Closed when the server shuts down, ending the open streams
+var stopStreams = make(chan struct{})
+
+// Server-Sent Events stream of the totals of a pool (a Digest each time they
+// change) and the inflation events (<urlEvents>?pool=<ADDR>)
+func pushEvents(w http.ResponseWriter, r *http.Request) {
+	if horizonURL == "" {
+		http.Error(w, "503 ledger stream disabled", 503)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "500 streaming not supported", 500)
+		return
+	}
+	pool := poolFromParam(r)
+	l := logging.FromRequest(r, logger).WithField(logging.FIELD_POOL, pool)
+
+	// Subscribe before sending the headers, to not miss any event
+	ch := hub.Subscribe(PUSH_BUFFER)
+	defer hub.Unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// Totals of other pools are queried on every ledger, and only sent if
+	// they changed (the default pool ones come ready in the TOTALS events)
+	var last snapshot.Digest
+	keepalive := time.NewTicker(PUSH_KEEPALIVE)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-stopStreams:
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case e := <-ch:
+			var data interface{}
+			switch {
+			case e.Type == events.INFLATION:
+				data = &e
+			case e.Type == events.TOTALS && pool == defaultPool:
+				last = *e.Totals
+				data = e.Totals
+			case e.Type == events.LEDGER && pool != defaultPool:
+				configMutex.RLock()
+				digest, err := getTotalsDB(pool)
+				configMutex.RUnlock()
+				if err != nil {
+					l.Error(err)
+					continue
+				}
+				if *digest == last {
+					continue
+				}
+				last = *digest
+				e.Type = events.TOTALS
+				data = digest
+			default:
+				continue
+			}
+			if err := writeEvent(w, e.Type, e.Ledger, data); err != nil {
+				l.WithError(err).Debug("Event stream closed")
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// One event in the text/event-stream format, with the ledger as its ID
+func writeEvent(w http.ResponseWriter, event string, ledger int32, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ledger, event, b)
+	return err
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"time"
+	"bufio"
+	"context"
+	"strings"
+	"testing"
+	"net/http"
+	"encoding/json"
+	"net/http/httptest"
+	"github.com/matheusb-comp/go/pool/fixtures"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+// Events read from a text/event-stream until the inflation one, calling
+// open once subscribed (the headers are sent after subscribing)
+func readEvents(t *testing.T, url string, open func()) map[string]string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+	defer cancel()
+	req, _ := http.NewRequest("GET", url, nil)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	open()
+
+	got := make(map[string]string)
+	var event string
+	s := bufio.NewScanner(resp.Body)
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			got[event] = strings.TrimPrefix(line, "data: ")
+			if event == "inflation" {
+				return got
+			}
+		}
+	}
+	t.Fatalf("stream ended without the inflation: %v", s.Err())
+	return nil
+}
+
+func TestPushEvents(t *testing.T) {
+	tests := []struct {
+		name string
+		pool string
+		want snapshot.Digest
+	}{
+		{name: "default pool", want: snapshot.Digest{Pool: pool, Voters: 2, Votes: 1300}},
+		{name: "other pool", pool: otherPool,
+			want: snapshot.Digest{Pool: otherPool, Voters: 1, Votes: 50}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup()
+			h := fixtures.NewHorizon()
+			defer h.Close()
+			h.AddLedger(10, "100000000000.0000000")
+			h.AddLedger(11, "100000000000.0000000")
+			h.AddLedger(12, "100000019000.0000000")
+			horizonURL = h.URL
+
+			srv := httptest.NewServer(http.HandlerFunc(pushEvents))
+			defer srv.Close()
+			// The stream only sees the cancel once it reads again, after
+			// reconnecting (the fake server sends nothing after the last ledger)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer func() {
+				cancel()
+				h.CloseClientConnections()
+			}()
+			got := readEvents(t, srv.URL + "/events?pool=" + tt.pool, func() {
+				go watchLedgers(ctx, h.Client())
+			})
+			var totals snapshot.Digest
+			if err := json.Unmarshal([]byte(got["totals"]), &totals); err != nil {
+				t.Fatal(err)
+			}
+			if totals != tt.want {
+				t.Errorf("totals %+v, want %+v", totals, tt.want)
+			}
+			var inflation struct {
+				Ledger int32 `json:"ledger"`
+				TotalCoins string `json:"total_coins"`
+			}
+			if err := json.Unmarshal([]byte(got["inflation"]), &inflation); err != nil {
+				t.Fatal(err)
+			}
+			if inflation.Ledger != 12 || inflation.TotalCoins != "100000019000.0000000" {
+				t.Errorf("inflation %+v", inflation)
+			}
+		})
+	}
+}
+
+func TestPushEventsDisabled(t *testing.T) {
+	setup()
+	horizonURL = ""
+	if code := get(t, pushEvents, "/events", nil); code != 503 {
+		t.Errorf("status %d without the ledger stream, want 503", code)
+	}
+}
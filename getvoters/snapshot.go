@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+	"strings"
+	"net/http"
+	"github.com/matheusb-comp/go/pool/logging"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+// Merkle tree of the last published snapshot, built again when the file
+// changes. It's only read after that, by any number of requests
+var snapCache struct {
+	sync.Mutex
+	name string
+	modTime time.Time
+	tree *snapshot.Tree
+}
+
+// Get the Merkle tree of the snapshot in snapshotFile, reading the file and
+// building the tree only if it changed
+func loadSnapshot() (*snapshot.Tree, error) {
+	snapCache.Lock()
+	defer snapCache.Unlock()
+
+	info, err := os.Stat(snapshotFile)
+	if err != nil {
+		return nil, err
+	}
+	if snapCache.tree != nil && snapCache.name == snapshotFile &&
+		snapCache.modTime.Equal(info.ModTime()) {
+		return snapCache.tree, nil
+	}
+
+	// The file is read in canonical order, so the tree can be built as is
+	s, err := snapshot.ReadFile(snapshotFile)
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.Tree()
+	if err != nil {
+		return nil, err
+	}
+	snapCache.name = snapshotFile
+	snapCache.modTime = info.ModTime()
+	snapCache.tree = t
+	return t, nil
+}
+
+// Inclusion proof of one voter in the published snapshot (<urlProof><account>)
+func getProof(w http.ResponseWriter, r *http.Request) {
+	l := logging.FromRequest(r, logger)
+	if snapshotFile == "" {
+		http.Error(w, "503 no snapshot published", 503)
+		return
+	}
+
+	account := strings.TrimPrefix(r.URL.Path, urlProof)
+	if len(account) != 56 || account[0] != 'G' {
+		http.Error(w, "400 invalid account", 400)
+		return
+	}
+
+	t, err := loadSnapshot()
+	if err != nil {
+		l.WithError(err).Error("ERROR reading snapshot " + snapshotFile)
+		http.Error(w, "500 internal server error", 500)
+		return
+	}
+
+	p, err := t.Proof(account)
+	if err == snapshot.ErrNotFound {
+		http.Error(w, "404 account not in snapshot", 404)
+		return
+	}
+	if err != nil {
+		l.WithError(err).Error("ERROR building proof")
+		http.Error(w, "500 internal server error", 500)
+		return
+	}
+	writeJSON(w, r, p)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"io/ioutil"
+	"encoding/hex"
+	"github.com/stellar/go/network"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+func TestGetProof(t *testing.T) {
+	core := setup()
+	s, err := core.Snapshot(network.TestNetworkPassphrase, pool, donationKey, 100, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "voters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	if err = snapshot.WriteFile(f.Name(), s); err != nil {
+		t.Fatal(err)
+	}
+	root, err := s.MerkleRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		file string
+		account string
+		code int
+	}{
+		{name: "voter", file: f.Name(), account: voterA, code: 200},
+		{name: "other voter", file: f.Name(), account: voterB, code: 200},
+		{name: "not a voter", file: f.Name(), account: charity, code: 404},
+		{name: "invalid account", file: f.Name(), account: "GABC", code: 400},
+		{name: "no snapshot published", account: voterA, code: 503},
+		{name: "snapshot missing", file: f.Name() + ".missing", account: voterA, code: 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snapshotFile = tt.file
+			var p snapshot.Proof
+			if code := get(t, getProof, urlProof + tt.account, &p); code != tt.code {
+				t.Fatalf("status %d, want %d", code, tt.code)
+			}
+			if tt.code != 200 {
+				return
+			}
+			if p.Entry.ID != tt.account || p.Ledger != 100 || p.Root != hex.EncodeToString(root[:]) {
+				t.Errorf("proof of %s in ledger %d, root %s", p.Entry.ID, p.Ledger, p.Root)
+			}
+			if err := snapshot.VerifyProof(&p); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
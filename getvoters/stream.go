@@ -0,0 +1,69 @@
+package main
+
+import (
+	"time"
+	"context"
+	"github.com/matheusb-comp/go/pool/events"
+	"github.com/matheusb-comp/go/pool/logging"
+	"github.com/stellar/go/clients/horizon"
+)
+
+// Time to wait before reconnecting when the ledger stream fails
+const STREAM_RETRY = 5 * time.Second
+
+// Ledger, totals and inflation events of the default pool, filled by
+// watchLedgers (only when a Horizon URL is set)
+var hub = events.NewHub()
+
+// Stream the ledgers from Horizon until the context is done, publishing the
+// same inflation detection the watcher does, plus the pool totals when they
+// change
+func watchLedgers(ctx context.Context, client *horizon.Client) {
+	var totalCoins string
+	var last *events.Event
+	cursor := horizon.Cursor("now")
+
+	for {
+		err := client.StreamLedgers(ctx, &cursor, func(l horizon.Ledger) {
+			cursor = horizon.Cursor(l.PT)
+			e := events.Event{Type: events.LEDGER, Ledger: l.Sequence,
+				ClosedAt: l.ClosedAt, TotalCoins: l.TotalCoins}
+			hub.Publish(e)
+
+			// When inflation happens, the Ledger.TotalCoins changes
+			if events.IsInflation(totalCoins, l.TotalCoins) {
+				e.Type = events.INFLATION
+				hub.Publish(e)
+			}
+			totalCoins = l.TotalCoins
+
+			// Only push the totals when they changed
+			configMutex.RLock()
+			digest, err := getTotalsDB(defaultPool)
+			configMutex.RUnlock()
+			if err != nil {
+				logger.WithField(logging.FIELD_LEDGER, l.Sequence).Error(err)
+				return
+			}
+			if last == nil || *last.Totals != *digest {
+				e.Type = events.TOTALS
+				e.Totals = digest
+				hub.Publish(e)
+				last = &e
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logger.WithError(err).Error("ERROR streaming ledgers")
+		}
+
+		// Wait a bit before reconnecting (from the last ledger seen)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(STREAM_RETRY):
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package config
+
+import (
+	"os"
+	"flag"
+	"sort"
+	"errors"
+	"strings"
+	"net/url"
+	"io/ioutil"
+	"gopkg.in/yaml.v2"
+)
+
+// Suffix of an option whose value is the path of a file holding the secret
+const FILE_SUFFIX = "_file"
+
+// Loader maps a YAML file and environment variables onto the options of a
+// flag set. Priority (highest first): command-line flags, environment
+// variables, secret files, the config file, and the flag defaults.
+//
+// The config file is a flat YAML mapping keyed by the flag names:
+//
+//   user: stellar
+//   pass_file: /run/secrets/db_pass
+//   pool: GCCD6AJOYZCUAQLX32ZJF2MKFFAUJ53PVCFQI3RHWKL3V47QYE2BNAUT
+//
+// Environment variables are the flag names in upper case, with dashes
+// replaced by underscores and Prefix prepended (PREFIX_USER, PREFIX_PASS_FILE).
+type Loader struct {
+	// Flag set to receive the values (must be already parsed)
+	Flags *flag.FlagSet
+	// Path of the YAML file (can be empty)
+	Path string
+	// Prefix of the environment variables
+	Prefix string
+
+	// Flags explicitly set on the command line (internal)
+	explicit map[string]bool
+}
+
+func NewLoader(fs *flag.FlagSet, path, prefix string) (*Loader, error) {
+	if !fs.Parsed() {
+		return nil, errors.New("ERROR: Flags must be parsed before loading the config")
+	}
+
+	// Remember the command-line flags, since Set also marks flags as visited
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	// The environment can also point to the config file
+	if path == "" {
+		path = os.Getenv(envName(prefix, "config"))
+	}
+
+	return &Loader{fs, path, prefix, explicit}, nil
+}
+
+// Load (or reload) the options, resetting the ones not set on the command line
+func (l *Loader) Load() error {
+	values := make(map[string]string)
+
+	// Read the file first, so the environment can override it
+	if l.Path != "" {
+		b, err := ioutil.ReadFile(l.Path)
+		if err != nil {
+			return errors.New("ERROR reading config file: " + err.Error())
+		}
+		if err = yaml.Unmarshal(b, &values); err != nil {
+			return errors.New("ERROR parsing config file " + l.Path + ": " + err.Error())
+		}
+	}
+
+	// Make sure every key in the file is an option we know about
+	for key := range values {
+		if l.Flags.Lookup(strings.TrimSuffix(key, FILE_SUFFIX)) == nil {
+			return errors.New("ERROR: Unknown option in config file: " + key)
+		}
+	}
+
+	// Compute every value before changing any flag, so a failure changes nothing
+	final := make(map[string]string)
+	var err error
+	l.Flags.VisitAll(func(f *flag.Flag) {
+		if err != nil || l.explicit[f.Name] {
+			return
+		}
+
+		value, ok := values[f.Name]
+		if path, found := values[f.Name + FILE_SUFFIX]; found {
+			if value, err = readSecret(path); err != nil {
+				return
+			}
+			ok = true
+		}
+		if env, found := os.LookupEnv(envName(l.Prefix, f.Name)); found {
+			value, ok = env, true
+		}
+		if path, found := os.LookupEnv(envName(l.Prefix, f.Name + FILE_SUFFIX)); found {
+			if value, err = readSecret(path); err != nil {
+				return
+			}
+			ok = true
+		}
+
+		// Options not configured anywhere go back to the default value
+		if !ok {
+			value = f.DefValue
+		}
+		final[f.Name] = value
+	})
+	if err != nil {
+		return err
+	}
+
+	for name, value := range final {
+		if err = l.Flags.Set(name, value); err != nil {
+			return errors.New("ERROR setting option " + name + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Name of the environment variable for an option (-db-host -> PREFIX_DB_HOST)
+func envName(prefix, name string) string {
+	name = strings.ToUpper(strings.Replace(name, "-", "_", -1))
+	if prefix == "" {
+		return name
+	}
+	return prefix + "_" + name
+}
+
+// Read a secret from a file, ignoring the trailing line break
+func readSecret(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.New("ERROR reading secret file: " + err.Error())
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// Check a listen address in the host:port form (host can be empty)
+func ValidateListen(addr string) error {
+	i := strings.LastIndex(addr, ":")
+	if i < 0 || i == len(addr) - 1 {
+		return errors.New("invalid listen address (expected host:port): " + addr)
+	}
+	return nil
+}
+
+// Check an absolute HTTP(S) URL
+func ValidateURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return errors.New("invalid URL: " + err.Error())
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("invalid URL (expected http[s]://host): " + s)
+	}
+	return nil
+}
+
+// Check a Stellar public key (56 characters starting with a G)
+func ValidateAccount(addr string) error {
+	if len(addr) != 56 || addr[0] != 'G' {
+		return errors.New("invalid Stellar address: " + addr)
+	}
+	return nil
+}
+
+// Check a PostgreSQL connection string (URL or keyword/value form)
+func ValidateConn(conn string) error {
+	if strings.HasPrefix(conn, "postgres://") || strings.HasPrefix(conn, "postgresql://") {
+		if _, err := url.Parse(conn); err != nil {
+			return errors.New("invalid connection URL: " + err.Error())
+		}
+		return nil
+	}
+
+	// Keyword/value pairs, with optional single-quoted values
+	s := strings.TrimSpace(conn)
+	for len(s) > 0 {
+		eq := strings.Index(s, "=")
+		if eq < 1 {
+			return errors.New("invalid connection string (expected key=value): " + s)
+		}
+		s = strings.TrimLeft(s[eq+1:], " ")
+		if strings.HasPrefix(s, "'") {
+			// Find the closing quote, skipping the escaped characters
+			i := 1
+			for ; i < len(s) && s[i] != '\''; i++ {
+				if s[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(s) {
+				return errors.New("invalid connection string: unterminated quoted value")
+			}
+			s = s[i+1:]
+		} else if i := strings.Index(s, " "); i >= 0 {
+			s = s[i:]
+		} else {
+			s = ""
+		}
+		s = strings.TrimLeft(s, " ")
+	}
+	return nil
+}
+
+// Check a libpq sslmode value
+func ValidateSSLMode(mode string) error {
+	switch mode {
+	case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+		return nil
+	}
+	return errors.New("invalid sslmode: " + mode)
+}
+
+// Collect the failed checks (keyed by option name), sorted by name
+func Validate(checks map[string]error) []error {
+	var names []string
+	for name, err := range checks {
+		if err != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		errs = append(errs, errors.New(name + ": " + checks[name].Error()))
+	}
+	return errs
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"flag"
+	"errors"
+	"net/http"
+	"github.com/stellar/go/network"
+	"github.com/stellar/go/clients/horizon"
+)
+
+// Networks known by name
+const (
+	NETWORK_PUBLIC = "public"
+	NETWORK_TESTNET = "testnet"
+	NETWORK_STANDALONE = "standalone"
+	NETWORK_CUSTOM = "custom"
+)
+
+// Passphrase of the network of a stellar-core started with --standalone
+const STANDALONE_NETWORK_PASSPHRASE = "Standalone Network ; February 2017"
+
+// Passphrase and default Horizon server of the named networks
+var NETWORKS = map[string]struct{ Passphrase, Horizon string }{
+	NETWORK_PUBLIC: {network.PublicNetworkPassphrase, "https://horizon.stellar.org"},
+	NETWORK_TESTNET: {network.TestNetworkPassphrase, "https://horizon-testnet.stellar.org"},
+	NETWORK_STANDALONE: {STANDALONE_NETWORK_PASSPHRASE, "http://localhost:8000"},
+}
+
+// Stellar network the commands work on. Everything that depends on it (the
+// Horizon client, transactions, signatures and snapshots) takes the
+// passphrase from here
+type Network struct {
+	// public, testnet, standalone or custom
+	Name string
+	// Passphrase of a custom network (or to override the named one)
+	CustomPassphrase string
+}
+
+// Define the network flags in the flag set
+func (n *Network) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&n.Name, "network", NETWORK_PUBLIC,
+		"Stellar network (public, testnet, standalone or custom). " +
+		"A custom network needs -network-passphrase and the Horizon URL")
+
+	fs.StringVar(&n.CustomPassphrase, "network-passphrase", "",
+		"Passphrase of a custom network (overrides the one of a named network)")
+}
+
+// Passphrase of the network, used to sign and identify the transactions
+func (n *Network) Passphrase() (string, error) {
+	if n.CustomPassphrase != "" {
+		return n.CustomPassphrase, nil
+	}
+	if n.Name == NETWORK_CUSTOM {
+		return "", errors.New("custom network without passphrase (set network-passphrase)")
+	}
+	known, ok := NETWORKS[n.Name]
+	if !ok {
+		return "", errors.New("unknown network (expected public, testnet, standalone or custom): " + n.Name)
+	}
+	return known.Passphrase, nil
+}
+
+// Horizon server to use when none is configured (empty for a custom network)
+func (n *Network) DefaultHorizon() string {
+	return NETWORKS[n.Name].Horizon
+}
+
+// New Horizon client for the URL, or for the default server of the network
+// if it's empty
+func (n *Network) Client(horizonURL string) (*horizon.Client, error) {
+	if horizonURL == "" {
+		horizonURL = n.DefaultHorizon()
+	}
+	if err := ValidateURL(horizonURL); err != nil {
+		return nil, errors.New("ERROR in Horizon URL of " + n.Name + " network: " + err.Error())
+	}
+	return &horizon.Client{URL: horizonURL, HTTP: http.DefaultClient}, nil
+}
+
+// Check the network options (a custom one also needs a Horizon URL)
+func ValidateNetwork(n *Network, horizonURL string) error {
+	if _, err := n.Passphrase(); err != nil {
+		return err
+	}
+	if horizonURL == "" && n.DefaultHorizon() == "" {
+		return errors.New("custom network without Horizon URL (set horizon)")
+	}
+	if horizonURL != "" {
+		return ValidateURL(horizonURL)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"testing"
+	"github.com/stellar/go/network"
+)
+
+func TestNetwork(t *testing.T) {
+	tests := []struct {
+		name string
+		n Network
+		horizon string
+		passphrase string
+		client string
+		// ValidateNetwork fails
+		invalid bool
+	}{
+		{
+			name: "public",
+			n: Network{Name: NETWORK_PUBLIC},
+			passphrase: network.PublicNetworkPassphrase,
+			client: "https://horizon.stellar.org",
+		},
+		{
+			name: "testnet with its own horizon",
+			n: Network{Name: NETWORK_TESTNET},
+			horizon: "http://horizon.local:8000",
+			passphrase: network.TestNetworkPassphrase,
+			client: "http://horizon.local:8000",
+		},
+		{
+			name: "standalone",
+			n: Network{Name: NETWORK_STANDALONE},
+			passphrase: STANDALONE_NETWORK_PASSPHRASE,
+			client: "http://localhost:8000",
+		},
+		{
+			name: "custom",
+			n: Network{Name: NETWORK_CUSTOM, CustomPassphrase: "Private ; 2019"},
+			horizon: "https://horizon.private",
+			passphrase: "Private ; 2019",
+			client: "https://horizon.private",
+		},
+		{
+			name: "passphrase over a named network",
+			n: Network{Name: NETWORK_PUBLIC, CustomPassphrase: "Fork ; 2019"},
+			passphrase: "Fork ; 2019",
+			client: "https://horizon.stellar.org",
+		},
+		{
+			name: "custom without horizon",
+			n: Network{Name: NETWORK_CUSTOM, CustomPassphrase: "Private ; 2019"},
+			passphrase: "Private ; 2019",
+			invalid: true,
+		},
+		{
+			name: "custom without passphrase",
+			n: Network{Name: NETWORK_CUSTOM},
+			horizon: "https://horizon.private",
+			client: "https://horizon.private",
+			invalid: true,
+		},
+		{
+			name: "unknown",
+			n: Network{Name: "mainnet"},
+			invalid: true,
+		},
+		{
+			name: "invalid horizon",
+			n: Network{Name: NETWORK_TESTNET},
+			horizon: "horizon.local",
+			passphrase: network.TestNetworkPassphrase,
+			invalid: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			passphrase, err := tt.n.Passphrase()
+			if passphrase != tt.passphrase || (err == nil) != (tt.passphrase != "") {
+				t.Errorf("passphrase %q (%v), want %q", passphrase, err, tt.passphrase)
+			}
+			client, err := tt.n.Client(tt.horizon)
+			if tt.client == "" {
+				if err == nil {
+					t.Errorf("client for %s", client.URL)
+				}
+			} else if err != nil || client.URL != tt.client {
+				t.Errorf("client %v (%v), want %s", client, err, tt.client)
+			}
+			if err = ValidateNetwork(&tt.n, tt.horizon); (err != nil) != tt.invalid {
+				t.Errorf("validation error %v, want invalid %t", err, tt.invalid)
+			}
+		})
+	}
+}
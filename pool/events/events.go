@@ -0,0 +1,97 @@
+package events
+
+import (
+	"sync"
+	"time"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+// Event types
+const (
+	// A ledger closed
+	LEDGER = "ledger"
+	// The totals of the pool changed
+	TOTALS = "totals"
+	// The total coins changed, inflation happened
+	INFLATION = "inflation"
+)
+
+// Something that happened in the network, sent to the subscribers of a Hub
+type Event struct {
+	Type string `json:"type"`
+	Ledger int32 `json:"ledger"`
+	ClosedAt time.Time `json:"closed_at"`
+	TotalCoins string `json:"total_coins,omitempty"`
+	// Pool totals (TOTALS events only)
+	Totals *snapshot.Digest `json:"totals,omitempty"`
+}
+
+// When inflation happens, the Ledger.TotalCoins changes (an empty previous
+// value means the first ledger seen, which can't be compared)
+func IsInflation(previous, current string) bool {
+	return previous != "" && previous != current
+}
+
+// Fan-out of events to any number of subscribers. A slow subscriber misses
+// events instead of blocking the publisher
+type Hub struct {
+	mutex sync.Mutex
+	subs map[chan Event]struct{}
+	// Last event of each type, sent to new subscribers
+	last map[string]Event
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[chan Event]struct{}), last: make(map[string]Event)}
+}
+
+// Get a channel receiving the events, starting with the last one of each type
+func (h *Hub) Subscribe(buffer int) chan Event {
+	ch := make(chan Event, buffer)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.subs[ch] = struct{}{}
+	for _, t := range []string{LEDGER, TOTALS} {
+		if e, ok := h.last[t]; ok {
+			select {
+			case ch <- e:
+			default:
+			}
+		}
+	}
+	return ch
+}
+
+// Stop receiving events (the channel is closed)
+func (h *Hub) Unsubscribe(ch chan Event) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// Send the event to every subscriber with room for it
+func (h *Hub) Publish(e Event) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.last[e.Type] = e
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Last event published of a type
+func (h *Hub) Last(t string) (Event, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	e, ok := h.last[t]
+	return e, ok
+}
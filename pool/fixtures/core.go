@@ -0,0 +1,147 @@
+package fixtures
+
+import (
+	"sort"
+	"errors"
+	"strconv"
+	"database/sql"
+	"encoding/base64"
+	"github.com/matheusb-comp/go/pool/getvoters"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+// One row of the stellar-core accounts table (only the columns we query)
+type Account struct {
+	ID string
+	Balance int64
+	InflationDest string
+	// accountdata rows of this account (values are base64 encoded when queried)
+	Data map[string]string
+}
+
+// In-memory stand-in for the stellar-core accounts and accountdata tables.
+// It answers with the same rows as VOTERS_QUERY and TOTALS_QUERY, so the
+// snapshot goes through the same code as the PostgreSQL one
+type Core struct {
+	Accounts []Account
+	// Returned by every query, to simulate a broken database
+	Err error
+}
+
+// Add (or replace) an account
+func (c *Core) AddAccount(a Account) {
+	for i := range c.Accounts {
+		if c.Accounts[i].ID == a.ID {
+			c.Accounts[i] = a
+			return
+		}
+	}
+	c.Accounts = append(c.Accounts, a)
+}
+
+// Source of voters for a pool, with the data names matching the LIKE pattern
+func (c *Core) Source(pool, pattern string) getvoters.Source {
+	return &coreSource{c, pool, pattern}
+}
+
+// Snapshot of the voters of a pool, taken like the watcher does at an
+// inflation (the data pairs matching the LIKE pattern)
+func (c *Core) Snapshot(network, pool, pattern string, ledger int32, credit uint64) (*snapshot.Snapshot, error) {
+	data, err := c.Source(pool, pattern).GetVoters()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := data.Entries()
+	if err != nil {
+		return nil, err
+	}
+	return snapshot.New(network, pool, ledger, credit, entries), nil
+}
+
+type coreSource struct {
+	core *Core
+	pool string
+	pattern string
+}
+
+func (s *coreSource) Close() error {
+	return nil
+}
+
+func (s *coreSource) GetTotals() (*getvoters.Data, error) {
+	if s.core.Err != nil {
+		return nil, errors.New("ERROR getting the sum of votes: " + s.core.Err.Error())
+	}
+
+	var voters, votes int64
+	for _, a := range s.core.Accounts {
+		if a.InflationDest == s.pool {
+			voters++
+			votes += a.Balance
+		}
+	}
+	return &getvoters.Data{
+		NumVoters: strconv.FormatInt(voters, 10),
+		NumVotes: strconv.FormatInt(votes, 10),
+	}, nil
+}
+
+func (s *coreSource) GetVoters() (*getvoters.Data, error) {
+	data, err := s.GetTotals()
+	if err != nil {
+		return nil, err
+	}
+	data.Voters = make(map[string]*getvoters.Voter)
+
+	// Same rows as the LEFT JOIN: one per matching data entry, or one with NULLs
+	for _, a := range s.core.Accounts {
+		if a.InflationDest != s.pool {
+			continue
+		}
+		balance := strconv.FormatInt(a.Balance, 10)
+
+		var names []string
+		for name := range a.Data {
+			if Like(s.pattern, name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		if len(names) == 0 {
+			data.AddRow(a.ID, balance, sql.NullString{}, sql.NullString{})
+			continue
+		}
+		for _, name := range names {
+			value := base64.StdEncoding.EncodeToString([]byte(a.Data[name]))
+			data.AddRow(a.ID, balance,
+				sql.NullString{String: name, Valid: true},
+				sql.NullString{String: value, Valid: true})
+		}
+	}
+	return data, nil
+}
+
+// SQL LIKE matching (% is any sequence, _ is any character, \ escapes)
+func Like(pattern, s string) bool {
+	p, str := []rune(pattern), []rune(s)
+	if len(p) == 0 {
+		return len(str) == 0
+	}
+	switch p[0] {
+	case '%':
+		for i := 0; i <= len(str); i++ {
+			if Like(string(p[1:]), string(str[i:])) {
+				return true
+			}
+		}
+		return false
+	case '_':
+		return len(str) > 0 && Like(string(p[1:]), string(str[1:]))
+	case '\\':
+		if len(p) > 1 {
+			p = p[1:]
+		}
+	}
+	return len(str) > 0 && str[0] == p[0] && Like(string(p[1:]), string(str[1:]))
+}
@@ -0,0 +1,214 @@
+package fixtures
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"strconv"
+	"strings"
+	"net/http"
+	"encoding/json"
+	"net/http/httptest"
+	"github.com/stellar/go/clients/horizon"
+)
+
+// Effect type_i of account_credited
+const EFFECT_ACCOUNT_CREDITED = 2
+
+// Minimal effect record, as served in the effects pages
+type Effect struct {
+	ID string `json:"id"`
+	PT string `json:"paging_token"`
+	Account string `json:"account"`
+	Type string `json:"type"`
+	TypeI int32 `json:"type_i"`
+	AssetType string `json:"asset_type"`
+	AssetCode string `json:"asset_code,omitempty"`
+	Issuer string `json:"asset_issuer,omitempty"`
+	Amount string `json:"amount"`
+}
+
+// Fake Horizon server, serving scripted ledgers (streamed with SSE) and the
+// effects of each ledger (paginated like the real server)
+type Horizon struct {
+	*httptest.Server
+	// Number of effects per page (the limit in the URL is ignored)
+	PageSize int
+
+	mutex sync.Mutex
+	ledgers []horizon.Ledger
+	effects map[int32][]Effect
+	// Extra JSON documents served as-is, keyed by path
+	pages map[string]string
+}
+
+// Start a new fake Horizon server (Close it when done)
+func NewHorizon() *Horizon {
+	h := &Horizon{PageSize: 10, effects: make(map[int32][]Effect),
+		pages: make(map[string]string)}
+	h.Server = httptest.NewServer(http.HandlerFunc(h.serve))
+	return h
+}
+
+// Client pointing to the fake server
+func (h *Horizon) Client() *horizon.Client {
+	return &horizon.Client{URL: h.URL, HTTP: h.Server.Client()}
+}
+
+// Append a ledger to the stream (the paging token is derived from the sequence)
+func (h *Horizon) AddLedger(seq int32, totalCoins string) horizon.Ledger {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	var l horizon.Ledger
+	l.ID = fmt.Sprintf("%064x", seq)
+	l.PT = strconv.FormatInt(int64(seq) << 32, 10)
+	l.Hash = l.ID
+	l.Sequence = seq
+	l.TotalCoins = totalCoins
+	l.FeePool = "0.0000000"
+	l.ClosedAt = time.Unix(int64(seq) * 5, 0).UTC()
+	l.Links.Self.Href = h.URL + "/ledgers/" + strconv.Itoa(int(seq))
+	l.Links.Effects.Href = l.Links.Self.Href + "/effects{?cursor,limit,order}"
+	l.Links.Effects.Templated = true
+
+	h.ledgers = append(h.ledgers, l)
+	return l
+}
+
+// Add effects to a ledger (IDs and paging tokens are filled if empty)
+func (h *Horizon) AddEffects(seq int32, effects ...Effect) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, e := range effects {
+		n := len(h.effects[seq]) + 1
+		if e.PT == "" {
+			e.PT = strconv.FormatInt(int64(seq) << 32 + int64(n), 10)
+		}
+		if e.ID == "" {
+			e.ID = e.PT + "-1"
+		}
+		h.effects[seq] = append(h.effects[seq], e)
+	}
+}
+
+// Add the account_credited effect that inflation creates for the pool
+func (h *Horizon) Credit(seq int32, account, amount string) {
+	h.AddEffects(seq, Effect{
+		Account: account,
+		Type: "account_credited",
+		TypeI: EFFECT_ACCOUNT_CREDITED,
+		AssetType: "native",
+		Amount: amount,
+	})
+}
+
+// Serve any other document (for example /accounts/<ID>) as-is
+func (h *Horizon) AddPage(path string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	h.mutex.Lock()
+	h.pages[path] = string(b)
+	h.mutex.Unlock()
+	return nil
+}
+
+func (h *Horizon) serve(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	switch {
+	case path == "/ledgers" && r.Header.Get("Accept") == "text/event-stream":
+		h.streamLedgers(w, r)
+	case strings.HasPrefix(path, "/ledgers/") && strings.HasSuffix(path, "/effects"):
+		seq, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path, "/ledgers/"), "/effects"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		h.effectsPage(w, r, int32(seq))
+	default:
+		h.mutex.Lock()
+		page, ok := h.pages[path]
+		h.mutex.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, page)
+	}
+}
+
+// Send every ledger after the cursor ("now" sends all of them), and keep the
+// connection open until the client leaves, so it doesn't reconnect in a loop
+func (h *Horizon) streamLedgers(w http.ResponseWriter, r *http.Request) {
+	var after int64
+	if c := r.URL.Query().Get("cursor"); c != "" && c != "now" {
+		after, _ = strconv.ParseInt(c, 10, 64)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 1000\nevent: open\ndata: \"hello\"\n\n")
+
+	h.mutex.Lock()
+	ledgers := append([]horizon.Ledger(nil), h.ledgers...)
+	h.mutex.Unlock()
+
+	for _, l := range ledgers {
+		if pt, _ := strconv.ParseInt(l.PT, 10, 64); pt <= after {
+			continue
+		}
+		b, err := json.Marshal(l)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "id: %s\ndata: %s\n\n", l.PT, b)
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	<-r.Context().Done()
+}
+
+// One page of effects, starting after the cursor (a position in the ledger)
+func (h *Horizon) effectsPage(w http.ResponseWriter, r *http.Request, seq int32) {
+	start, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+
+	h.mutex.Lock()
+	all := h.effects[seq]
+	h.mutex.Unlock()
+
+	end := start + h.PageSize
+	if start > len(all) {
+		start = len(all)
+	}
+	if end > len(all) {
+		end = len(all)
+	}
+
+	self := fmt.Sprintf("%s/ledgers/%d/effects?cursor=%d&limit=%d&order=asc",
+		h.URL, seq, start, h.PageSize)
+	next := fmt.Sprintf("%s/ledgers/%d/effects?cursor=%d&limit=%d&order=asc",
+		h.URL, seq, end, h.PageSize)
+
+	var page struct {
+		Links struct {
+			Self horizon.Link `json:"self"`
+			Next horizon.Link `json:"next"`
+			Prev horizon.Link `json:"prev"`
+		} `json:"_links"`
+		Embedded struct {
+			Records []Effect `json:"records"`
+		} `json:"_embedded"`
+	}
+	page.Links.Self.Href = self
+	page.Links.Next.Href = next
+	page.Links.Prev.Href = self
+	page.Embedded.Records = append([]Effect{}, all[start:end]...)
+
+	w.Header().Set("Content-Type", "application/hal+json")
+	json.NewEncoder(w).Encode(&page)
+}
@@ -0,0 +1,22 @@
+package fixtures
+
+import (
+	"github.com/stellar/go/keypair"
+)
+
+// Deterministic keypair for the tests (the same n always gives the same
+// account, unlike keypair.Random)
+func Keypair(n int) *keypair.Full {
+	var seed [32]byte
+	seed[0], seed[1] = byte(n >> 8), byte(n)
+	kp, err := keypair.FromRawSeed(seed)
+	if err != nil {
+		panic(err)
+	}
+	return kp
+}
+
+// Address of the deterministic keypair n
+func Address(n int) string {
+	return Keypair(n).Address()
+}
@@ -0,0 +1,161 @@
+package fixtures
+
+import (
+	"io"
+	"sort"
+	"errors"
+	"context"
+	"strings"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+)
+
+// Database handle answering the stellar-core queries of the getvoters server
+// (totals and voters, with an optional account) from the accounts. Args that
+// are addresses are the pool and then the account, the other one is the
+// LIKE pattern of the data names
+func (c *Core) DB() *sql.DB {
+	return sql.OpenDB(coreConnector{c})
+}
+
+type coreConnector struct {
+	core *Core
+}
+
+func (c coreConnector) Connect(context.Context) (driver.Conn, error) {
+	return &coreConn{c.core}, nil
+}
+
+func (c coreConnector) Driver() driver.Driver {
+	return coreDriver{}
+}
+
+type coreDriver struct{}
+
+func (coreDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("ERROR: Open the fixtures DB with Core.DB")
+}
+
+type coreConn struct {
+	core *Core
+}
+
+func (c *coreConn) Prepare(query string) (driver.Stmt, error) {
+	return &coreStmt{c.core, query}, nil
+}
+
+func (c *coreConn) Close() error {
+	return nil
+}
+
+func (c *coreConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("ERROR: Transactions not supported by the fixtures DB")
+}
+
+type coreStmt struct {
+	core *Core
+	query string
+}
+
+func (s *coreStmt) Close() error {
+	return nil
+}
+
+// Any number of args
+func (s *coreStmt) NumInput() int {
+	return -1
+}
+
+func (s *coreStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("ERROR: The fixtures DB is read only")
+}
+
+func (s *coreStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if s.core.Err != nil {
+		return nil, s.core.Err
+	}
+	var pool, account, pattern string
+	for _, arg := range args {
+		v, _ := arg.(string)
+		switch {
+		case !isAddress(v):
+			pattern = v
+		case pool == "":
+			pool = v
+		default:
+			account = v
+		}
+	}
+
+	var voters, votes int64
+	rows := &coreRows{}
+	for _, a := range s.core.Accounts {
+		if a.InflationDest != pool || (account != "" && a.ID != account) {
+			continue
+		}
+		voters++
+		votes += a.Balance
+
+		// Same rows as the LEFT JOIN: one per matching data entry, or one with NULLs
+		var names []string
+		for name := range a.Data {
+			if Like(pattern, name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			rows.values = append(rows.values, []driver.Value{a.ID, a.Balance, nil, nil})
+		}
+		for _, name := range names {
+			value := base64.StdEncoding.EncodeToString([]byte(a.Data[name]))
+			rows.values = append(rows.values, []driver.Value{a.ID, a.Balance, name, value})
+		}
+	}
+	// SUM of no rows is NULL
+	var sum driver.Value
+	if voters > 0 {
+		sum = votes
+	}
+
+	switch {
+	case strings.Contains(s.query, "accountdata"):
+		rows.columns = []string{"accountid", "balance", "dataname", "datavalue"}
+		return rows, nil
+	case strings.Contains(s.query, "COUNT(accountid), SUM(balance)"):
+		return &coreRows{columns: []string{"count", "sum"},
+			values: [][]driver.Value{{voters, sum}}}, nil
+	case strings.Contains(s.query, "COUNT(accountid)"):
+		return &coreRows{columns: []string{"count"}, values: [][]driver.Value{{voters}}}, nil
+	case strings.Contains(s.query, "SUM(balance)"):
+		return &coreRows{columns: []string{"sum"}, values: [][]driver.Value{{sum}}}, nil
+	}
+	return nil, errors.New("ERROR: Query not supported by the fixtures DB: " + s.query)
+}
+
+type coreRows struct {
+	columns []string
+	values [][]driver.Value
+}
+
+func (r *coreRows) Columns() []string {
+	return r.columns
+}
+
+func (r *coreRows) Close() error {
+	return nil
+}
+
+func (r *coreRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func isAddress(s string) bool {
+	return len(s) == 56 && s[0] == 'G'
+}
@@ -0,0 +1,187 @@
+package getvoters
+
+import (
+	"flag"
+	"time"
+	"errors"
+	"strconv"
+	"strings"
+	"net/url"
+	"database/sql"
+)
+
+// PostgreSQL connection settings shared by every command using the core DB
+type ConnConfig struct {
+	// Optional custom connection string (keyword/value or postgres:// URL).
+	// If provided, it's used instead of the Name, User, Password, Host and Port
+	Conn string
+
+	Name string
+	User string
+	Password string
+	Host string
+	Port string
+
+	// SSL settings (https://www.postgresql.org/docs/current/libpq-ssl.html)
+	SSLMode string
+	SSLRootCert string
+	// Maximum wait for a connection, in seconds (0 means wait indefinitely)
+	ConnectTimeout int
+
+	// Connection pool sizing (0 keeps the database/sql defaults)
+	MaxOpenConns int
+	MaxIdleConns int
+	ConnMaxLifetime time.Duration
+}
+
+// Define the flags for every setting in the flag set
+func (c *ConnConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.User, "user", "stellar",
+		"PostgreSQL user name to connect as")
+
+	fs.StringVar(&c.Password, "pass", "",
+		"Password to be used if the server demands password authentication. " +
+		"Prefer pass_file in the config file or the <PREFIX>_PASS_FILE " +
+		"environment variable, so the password doesn't show up in the process list")
+
+	fs.StringVar(&c.Name, "db", "core", "The database name")
+
+	fs.StringVar(&c.Host, "host", "localhost",
+		"Name of host to connect to. If a host name begins with a slash, " +
+		"it specifies Unix-domain communication rather than TCP/IP communication")
+
+	fs.StringVar(&c.Port, "port", "5432",
+		"Port number to connect to at the server host, " +
+		"or socket file name extension for Unix-domain connections")
+
+	fs.StringVar(&c.Conn, "conn", "",
+		"Optional custom PostgreSQL connection string (keyword/value or " +
+		"postgres:// URL). If provided, it's used instead of the other flags")
+
+	fs.StringVar(&c.SSLMode, "sslmode", "",
+		"SSL mode (disable, allow, prefer, require, verify-ca or verify-full)")
+
+	fs.StringVar(&c.SSLRootCert, "sslrootcert", "",
+		"File with the SSL certificate authorities, to verify the server")
+
+	fs.IntVar(&c.ConnectTimeout, "connect-timeout", 10,
+		"Maximum wait for a DB connection, in seconds (0 waits indefinitely)")
+
+	fs.IntVar(&c.MaxOpenConns, "max-open", 0,
+		"Maximum number of open DB connections (0 is unlimited)")
+
+	fs.IntVar(&c.MaxIdleConns, "max-idle", 0,
+		"Maximum number of idle DB connections (0 keeps the default)")
+
+	fs.DurationVar(&c.ConnMaxLifetime, "max-lifetime", 0,
+		"Maximum time a DB connection may be reused (0 is forever)")
+}
+
+// Build the libpq connection string, quoting and escaping every value
+func (c *ConnConfig) String() string {
+	// Settings added on top of the custom connection string
+	var extra [][2]string
+	if c.SSLMode != "" {
+		extra = append(extra, [2]string{"sslmode", c.SSLMode})
+	}
+	if c.SSLRootCert != "" {
+		extra = append(extra, [2]string{"sslrootcert", c.SSLRootCert})
+	}
+	if c.ConnectTimeout > 0 {
+		extra = append(extra, [2]string{"connect_timeout", strconv.Itoa(c.ConnectTimeout)})
+	}
+
+	// URL-form DSN, the settings go in the query string
+	if isURL(c.Conn) {
+		u, err := url.Parse(c.Conn)
+		if err != nil {
+			// Let the driver report the error when opening
+			return c.Conn
+		}
+		q := u.Query()
+		for _, kv := range extra {
+			q.Set(kv[0], kv[1])
+		}
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+
+	var pairs []string
+	if len(c.Conn) > 0 {
+		pairs = append(pairs, c.Conn)
+	} else {
+		base := [][2]string{
+			{"dbname", c.Name},
+			{"user", c.User},
+			{"password", c.Password},
+			{"host", c.Host},
+			{"port", c.Port},
+		}
+		for _, kv := range base {
+			// Empty values are left for libpq to default
+			if kv[1] != "" {
+				pairs = append(pairs, kv[0] + "=" + QuoteValue(kv[1]))
+			}
+		}
+	}
+	for _, kv := range extra {
+		pairs = append(pairs, kv[0] + "=" + QuoteValue(kv[1]))
+	}
+	return strings.Join(pairs, " ")
+}
+
+// Describe the target of the connection, without the password
+func (c *ConnConfig) Target() string {
+	if isURL(c.Conn) {
+		if u, err := url.Parse(c.Conn); err == nil {
+			return u.Host + u.Path
+		}
+		return "connection URL"
+	}
+	if len(c.Conn) > 0 {
+		return "custom connection string"
+	}
+	return c.User + "@" + c.Host + ":" + c.Port + "/" + c.Name
+}
+
+// Open the connection pool and ping the database, so bad settings fail early
+func (c *ConnConfig) Open() (*sql.DB, error) {
+	db, err := sql.Open(DB_DRIVER, c.String())
+	if err != nil {
+		return nil, errors.New("ERROR opening DB connection: " + err.Error())
+	}
+
+	// Set the connection pool sizing
+	if c.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(c.MaxOpenConns)
+	}
+	if c.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(c.MaxIdleConns)
+	}
+	if c.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(c.ConnMaxLifetime)
+	}
+
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, errors.New("ERROR connecting to the database " +
+			c.Target() + ": " + err.Error())
+	}
+	return db, nil
+}
+
+// Quote a libpq keyword value when needed, escaping quotes and backslashes
+// (https://www.postgresql.org/docs/current/libpq-connect.html#LIBPQ-CONNSTRING)
+func QuoteValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, " \t\n\r\v\f'\\") {
+		return v
+	}
+	v = strings.Replace(v, `\`, `\\`, -1)
+	v = strings.Replace(v, `'`, `\'`, -1)
+	return "'" + v + "'"
+}
+
+func isURL(conn string) bool {
+	return strings.HasPrefix(conn, "postgres://") ||
+		strings.HasPrefix(conn, "postgresql://")
+}
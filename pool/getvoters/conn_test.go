@@ -0,0 +1,87 @@
+package getvoters
+
+import (
+	"testing"
+)
+
+func TestConnString(t *testing.T) {
+	tests := []struct {
+		name string
+		c ConnConfig
+		want string
+	}{
+		{
+			name: "flags",
+			c: ConnConfig{Name: "core", User: "stellar", Password: "secret", Host: "localhost", Port: "5432"},
+			want: "dbname=core user=stellar password=secret host=localhost port=5432",
+		},
+		{
+			name: "quoted and escaped",
+			c: ConnConfig{Name: "core", User: "stellar", Password: `it's a \secret`, Host: "localhost"},
+			want: `dbname=core user=stellar password='it\'s a \\secret' host=localhost`,
+		},
+		{
+			name: "empty values left to libpq",
+			c: ConnConfig{Name: "core", Host: "/var/run/postgresql"},
+			want: "dbname=core host=/var/run/postgresql",
+		},
+		{
+			name: "custom string with SSL",
+			c: ConnConfig{Conn: "host=db dbname=core", SSLMode: "verify-full",
+				SSLRootCert: "/etc/ssl/root ca.pem", ConnectTimeout: 5},
+			want: "host=db dbname=core sslmode=verify-full sslrootcert='/etc/ssl/root ca.pem' connect_timeout=5",
+		},
+		{
+			name: "URL with SSL",
+			c: ConnConfig{Conn: "postgres://stellar:p%40ss@db:5432/core?application_name=pool",
+				SSLMode: "require", ConnectTimeout: 5},
+			want: "postgres://stellar:p%40ss@db:5432/core?application_name=pool&connect_timeout=5&sslmode=require",
+		},
+		{
+			name: "URL setting replaced",
+			c: ConnConfig{Conn: "postgresql://db/core?sslmode=disable", SSLMode: "require"},
+			want: "postgresql://db/core?sslmode=require",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.c.String(); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// The target is logged, so it never has the password
+func TestConnTarget(t *testing.T) {
+	tests := []struct {
+		c ConnConfig
+		want string
+	}{
+		{ConnConfig{Name: "core", User: "stellar", Password: "secret", Host: "db", Port: "5432"},
+			"stellar@db:5432/core"},
+		{ConnConfig{Conn: "postgres://stellar:secret@db:5432/core"}, "db:5432/core"},
+		{ConnConfig{Conn: "host=db password=secret"}, "custom connection string"},
+	}
+	for _, tt := range tests {
+		if got := tt.c.Target(); got != tt.want {
+			t.Errorf("target %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestQuoteValue(t *testing.T) {
+	tests := map[string]string{
+		"core": "core",
+		"": "''",
+		"two words": "'two words'",
+		"it's": `'it\'s'`,
+		`back\slash`: `'back\\slash'`,
+		"tab\there": "'tab\there'",
+	}
+	for v, want := range tests {
+		if got := QuoteValue(v); got != want {
+			t.Errorf("QuoteValue(%q) = %s, want %s", v, got, want)
+		}
+	}
+}
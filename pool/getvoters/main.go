@@ -2,9 +2,13 @@ package getvoters
 
 import (
 	"errors"
+	"strconv"
+	"strings"
 	"database/sql"
 	"encoding/base64"
 	_ "github.com/lib/pq"
+	"github.com/stellar/go/xdr"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
 )
 
 const DB_DRIVER = "postgres"
@@ -18,6 +22,20 @@ FROM accounts LEFT JOIN accountdata
 ON accountdata.accountid = accounts.accountid
 AND dataname LIKE $2 WHERE inflationdest = $1`
 
+const EXISTING_QUERY = `SELECT accountid FROM accounts WHERE accountid IN `
+
+// Accounts checked in each EXISTING_QUERY
+const EXISTING_BATCH = 500
+
+// Authorized trustlines of an asset (the accounts are added as in
+// EXISTING_QUERY, after the issuer and code)
+const TRUSTLINES_QUERY = `SELECT accountid, tlimit - balance FROM trustlines
+WHERE issuer = $1 AND assetcode = $2 AND flags & 1 = 1 AND accountid IN `
+
+// Envelopes of the transactions in the last $1 ledgers
+const RECENT_TXS_QUERY = `SELECT txbody FROM txhistory
+WHERE ledgerseq > (SELECT MAX(ledgerseq) FROM ledgerheaders) - $1`
+
 // A single voter with its relevant data
 type Voter struct {
 	Balance string
@@ -30,6 +48,14 @@ type Data struct {
   Voters map[string]*Voter
 }
 
+// Anything that can take a snapshot of the voters (the core DB, or a
+// stand-in with the same accounts and accountdata rows)
+type Source interface {
+  GetTotals() (*Data, error)
+  GetVoters() (*Data, error)
+  Close() error
+}
+
 type DBconn struct {
   // PostgreSQL connection string
   Conn string
@@ -43,19 +69,24 @@ type DBconn struct {
 }
 
 func NewDBconn(conn, pool, pattern string) (*DBconn, error) {
+  return NewDBconnConfig(&ConnConfig{Conn: conn}, pool, pattern)
+}
+
+// Same as NewDBconn, but with the connection settings and pool sizing
+func NewDBconnConfig(cfg *ConnConfig, pool, pattern string) (*DBconn, error) {
 
   // Validate the pool address received
   if len(pool) != 56 || pool[0] != 'G' {
     return nil, errors.New("ERROR: Invalid address provided")
   }
 
-  // Try opening a connection to the database
-  db, err := sql.Open(DB_DRIVER, conn)
+  // Try opening a connection to the database (and ping it)
+  db, err := cfg.Open()
   if err != nil {
-    return nil, errors.New("ERROR opening DB connection: " + err.Error())
+    return nil, err
   }
 
-  return &DBconn{conn, pool, pattern, db}, nil
+  return &DBconn{cfg.String(), pool, pattern, db}, nil
 }
 
 func (c *DBconn) Close() error {
@@ -109,34 +140,160 @@ func (c *DBconn) GetVoters() (*Data, error) {
         return nil, errors.New("ERROR scanning query result row: " + err.Error())
       }
 
-      // Get the voter for this ID in the map
-      v := data.Voters[id]
-      // Create a new one if it doesn't exist
-  		if v == nil {
-  			v = new(Voter)
-        data.Voters[id] = v
-  		}
-
-      // Add this voter's balance to the map (update if repeated)
-  		v.Balance = balance
-
-      // Add the (key, value) pair, if it exists
-  		if nameNull.Valid && valueNull.Valid {
-        // We expect a base64 encoded string
-  			decoded, err := base64.StdEncoding.DecodeString(valueNull.String)
-  			// Ignore the data if we can't decode it
-  			if err == nil {
-  				// Adding data to a uninitialized map is a runtime panic
-  				if v.Data == nil {
-  					v.Data = make(map[string]string)
-  				}
-  				// Finally, add the data pair to the voter
-  				v.Data[nameNull.String] = string(decoded)
-  			}
-  		}
+      data.AddRow(id, balance, nameNull, valueNull)
     }
   }
 
   // Return the pointer, now with a valid map of Voters
   return data, nil
 }
+
+// Add one row of the VOTERS_QUERY result (id, balance, dataname, datavalue)
+func (data *Data) AddRow(id, balance string, nameNull, valueNull sql.NullString) {
+  // Get the voter for this ID in the map
+  v := data.Voters[id]
+  // Create a new one if it doesn't exist
+  if v == nil {
+    v = new(Voter)
+    data.Voters[id] = v
+  }
+
+  // Add this voter's balance to the map (update if repeated)
+  v.Balance = balance
+
+  // Add the (key, value) pair, if it exists
+  if nameNull.Valid && valueNull.Valid {
+    // We expect a base64 encoded string
+    decoded, err := base64.StdEncoding.DecodeString(valueNull.String)
+    // Ignore the data if we can't decode it
+    if err == nil {
+      // Adding data to a uninitialized map is a runtime panic
+      if v.Data == nil {
+        v.Data = make(map[string]string)
+      }
+      // Finally, add the data pair to the voter
+      v.Data[nameNull.String] = string(decoded)
+    }
+  }
+}
+
+// Convert the voters to snapshot entries (sorted by account)
+func (data *Data) Entries() ([]snapshot.Entry, error) {
+  entries := make([]snapshot.Entry, 0, len(data.Voters))
+  for id, v := range data.Voters {
+    bal, err := strconv.ParseUint(v.Balance, 10, 64)
+    if err != nil {
+      return nil, errors.New("ERROR parsing balance of " + id + ": " + err.Error())
+    }
+    entry := snapshot.Entry{ID: id, Bal: bal}
+    for name, value := range v.Data {
+      entry.Data = append(entry.Data, snapshot.Data{Name: name, Value: value})
+    }
+    entries = append(entries, entry)
+  }
+  snapshot.SortEntries(entries)
+  return entries, nil
+}
+
+// Which of the accounts exist in the core DB (the payout destinations are
+// checked in batches, with one placeholder per account)
+func (c *DBconn) Existing(accounts []string) (map[string]bool, error) {
+  existing := make(map[string]bool)
+  for start := 0; start < len(accounts); start += EXISTING_BATCH {
+    end := start + EXISTING_BATCH
+    if end > len(accounts) {
+      end = len(accounts)
+    }
+    list, args := placeholders(accounts[start:end], 1)
+    rows, err := c.db.Query(EXISTING_QUERY + list, args...)
+    if err != nil {
+      return nil, errors.New("ERROR checking the accounts: " + err.Error())
+    }
+    for rows.Next() {
+      var id string
+      if err = rows.Scan(&id); err != nil {
+        rows.Close()
+        return nil, errors.New("ERROR scanning account: " + err.Error())
+      }
+      existing[id] = true
+    }
+    rows.Close()
+    if err = rows.Err(); err != nil {
+      return nil, errors.New("ERROR iterating accounts: " + err.Error())
+    }
+  }
+  return existing, nil
+}
+
+// Fee per operation of each transaction in the last ledgers of the core DB
+// (the fee statistics when there's no Horizon to ask)
+func (c *DBconn) RecentFees(ledgers int) ([]uint64, error) {
+  rows, err := c.db.Query(RECENT_TXS_QUERY, ledgers)
+  if err != nil {
+    return nil, errors.New("ERROR getting the recent transactions: " + err.Error())
+  }
+  defer rows.Close()
+
+  var fees []uint64
+  for rows.Next() {
+    var body string
+    if err = rows.Scan(&body); err != nil {
+      return nil, errors.New("ERROR scanning transaction: " + err.Error())
+    }
+    var env xdr.TransactionEnvelope
+    // Skip what can't be decoded, a few transactions are enough
+    if xdr.SafeUnmarshalBase64(body, &env) != nil || len(env.Tx.Operations) == 0 {
+      continue
+    }
+    fees = append(fees, uint64(env.Tx.Fee) / uint64(len(env.Tx.Operations)))
+  }
+  if err = rows.Err(); err != nil {
+    return nil, errors.New("ERROR iterating transactions: " + err.Error())
+  }
+  return fees, nil
+}
+
+// How much of an asset each account can still receive, checked in batches
+// like Existing (accounts without an authorized trustline are left out)
+func (c *DBconn) Trustlines(code, issuer string, accounts []string) (map[string]uint64, error) {
+  room := make(map[string]uint64)
+  for start := 0; start < len(accounts); start += EXISTING_BATCH {
+    end := start + EXISTING_BATCH
+    if end > len(accounts) {
+      end = len(accounts)
+    }
+    list, args := placeholders(accounts[start:end], 3)
+    args = append([]interface{}{issuer, code}, args...)
+    rows, err := c.db.Query(TRUSTLINES_QUERY + list, args...)
+    if err != nil {
+      return nil, errors.New("ERROR checking the trustlines: " + err.Error())
+    }
+    for rows.Next() {
+      var id string
+      var left int64
+      if err = rows.Scan(&id, &left); err != nil {
+        rows.Close()
+        return nil, errors.New("ERROR scanning trustline: " + err.Error())
+      }
+      if left > 0 {
+        room[id] = uint64(left)
+      }
+    }
+    rows.Close()
+    if err = rows.Err(); err != nil {
+      return nil, errors.New("ERROR iterating trustlines: " + err.Error())
+    }
+  }
+  return room, nil
+}
+
+// List of placeholders for the accounts, "($first,...)", and their values
+func placeholders(accounts []string, first int) (string, []interface{}) {
+  args := make([]interface{}, len(accounts))
+  holders := make([]string, len(accounts))
+  for i, account := range accounts {
+    args[i] = account
+    holders[i] = "$" + strconv.Itoa(first + i)
+  }
+  return "(" + strings.Join(holders, ",") + ")", args
+}
@@ -0,0 +1,234 @@
+package history
+
+import (
+	"errors"
+)
+
+// Reasons of the carry-over entries. The amount owed to an account is the
+// sum of its entries: positive when something wasn't delivered, negative
+// when it was added to a payout
+const (
+	CARRY_MIN_PAYOUT = "below minimum payout"
+	CARRY_FAILED = "payment failed"
+	CARRY_NO_DESTINATION = "destination missing"
+	// A donation target missing (never created, see OwedDonations)
+	CARRY_NO_TARGET = "donation target missing"
+	CARRY_TAKEN = "added to payout"
+)
+
+// Entries are keyed by run, account and reason, so recording a run again
+// replaces its entries instead of counting them twice
+const SAVE_CARRY_QUERY = `INSERT INTO carry_over
+(pool, ledger, account, reason, amount) VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (pool, ledger, account, reason) DO UPDATE SET amount = EXCLUDED.amount`
+
+// The entries of the plan of a run (all but the failed payments)
+const CLEAR_PLAN_CARRY_QUERY = `DELETE FROM carry_over
+WHERE pool = $1 AND ledger = $2 AND reason <> '` + CARRY_FAILED + `'`
+
+const CLEAR_FAILED_CARRY_QUERY = `DELETE FROM carry_over
+WHERE pool = $1 AND ledger = $2 AND reason = '` + CARRY_FAILED + `'`
+
+const OWED_QUERY = `SELECT account, SUM(amount) FROM carry_over
+WHERE pool = $1 GROUP BY account HAVING SUM(amount) > 0`
+
+const OWED_DONATIONS_QUERY = `SELECT account FROM carry_over
+WHERE pool = $1 GROUP BY account
+HAVING SUM(amount) > 0 AND bool_or(reason = '` + CARRY_NO_TARGET + `')`
+
+const ACCOUNT_CARRY_QUERY = `SELECT ledger, reason, amount
+FROM carry_over WHERE pool = $1 AND account = $2 ORDER BY ledger, created_at`
+
+const RUN_CARRY_QUERY = `SELECT account, reason, amount FROM carry_over
+WHERE pool = $1 AND ledger = $2 AND amount > 0 ORDER BY account, reason`
+
+// What the failed transactions of a run didn't deliver, by destination
+const FAILED_PAYMENTS_QUERY = `SELECT p.destination, SUM(p.amount)
+FROM payout_payments p JOIN payout_transactions t ON t.hash = p.tx_hash
+WHERE t.pool = $1 AND t.ledger = $2 AND t.status = '` + TX_FAILED + `'
+GROUP BY p.destination`
+
+// One movement of the carry-over ledger of an account
+type CarryEntry struct {
+	Ledger int32 `json:"ledger"`
+	// Only set when saving the entries of a run
+	Account string `json:"account,omitempty"`
+	Reason string `json:"reason"`
+	// Positive when owed to the account, negative when paid
+	Amount int64 `json:"amount"`
+}
+
+// Amount owed to each account of the pool (only the positive balances)
+func (s *Store) Owed(pool string) (map[string]uint64, error) {
+	rows, err := s.db.Query(OWED_QUERY, pool)
+	if err != nil {
+		return nil, errors.New("ERROR getting carry-over balances: " + err.Error())
+	}
+	defer rows.Close()
+
+	owed := make(map[string]uint64)
+	for rows.Next() {
+		var account string
+		var amount int64
+		if err = rows.Scan(&account, &amount); err != nil {
+			return nil, errors.New("ERROR scanning carry-over balance: " + err.Error())
+		}
+		owed[account] = uint64(amount)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New("ERROR iterating carry-over balances: " + err.Error())
+	}
+	return owed, nil
+}
+
+// Accounts owed something that was carried while they were missing as a
+// donation target (paid as a donation, so they aren't created)
+func (s *Store) OwedDonations(pool string) (map[string]bool, error) {
+	rows, err := s.db.Query(OWED_DONATIONS_QUERY, pool)
+	if err != nil {
+		return nil, errors.New("ERROR getting owed donations: " + err.Error())
+	}
+	defer rows.Close()
+
+	targets := make(map[string]bool)
+	for rows.Next() {
+		var account string
+		if err = rows.Scan(&account); err != nil {
+			return nil, errors.New("ERROR scanning owed donation: " + err.Error())
+		}
+		targets[account] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New("ERROR iterating owed donations: " + err.Error())
+	}
+	return targets, nil
+}
+
+// Movements of the carry-over of one account, oldest first
+func (s *Store) CarryOver(pool, account string) ([]CarryEntry, error) {
+	rows, err := s.db.Query(ACCOUNT_CARRY_QUERY, pool, account)
+	if err != nil {
+		return nil, errors.New("ERROR listing carry-over: " + err.Error())
+	}
+	defer rows.Close()
+
+	entries := []CarryEntry{}
+	for rows.Next() {
+		var e CarryEntry
+		if err = rows.Scan(&e.Ledger, &e.Reason, &e.Amount); err != nil {
+			return nil, errors.New("ERROR scanning carry-over: " + err.Error())
+		}
+		entries = append(entries, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New("ERROR iterating carry-over: " + err.Error())
+	}
+	return entries, nil
+}
+
+// What a run left in the carry-over (the plan, and the failed payments
+// once reconciled), by account
+func (s *Store) RunCarryOver(pool string, ledger int32) ([]CarryEntry, error) {
+	rows, err := s.db.Query(RUN_CARRY_QUERY, pool, ledger)
+	if err != nil {
+		return nil, errors.New("ERROR listing carry-over: " + err.Error())
+	}
+	defer rows.Close()
+
+	entries := []CarryEntry{}
+	for rows.Next() {
+		e := CarryEntry{Ledger: ledger}
+		if err = rows.Scan(&e.Account, &e.Reason, &e.Amount); err != nil {
+			return nil, errors.New("ERROR scanning carry-over: " + err.Error())
+		}
+		entries = append(entries, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New("ERROR iterating carry-over: " + err.Error())
+	}
+	return entries, nil
+}
+
+// Record what the plan of a run took from the carry-over (CARRY_TAKEN,
+// negative) and what it left in it, replacing the entries recorded for the
+// plan before, in one transaction. Entries of the same account and reason
+// are added up
+func (s *Store) SaveCarryOver(pool string, ledger int32, entries []CarryEntry) error {
+	sums := map[[2]string]int64{}
+	var keys [][2]string
+	for _, e := range entries {
+		key := [2]string{e.Account, e.Reason}
+		if _, ok := sums[key]; !ok {
+			keys = append(keys, key)
+		}
+		sums[key] += e.Amount
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errors.New("ERROR starting history transaction: " + err.Error())
+	}
+	if _, err = tx.Exec(CLEAR_PLAN_CARRY_QUERY, pool, ledger); err != nil {
+		tx.Rollback()
+		return errors.New("ERROR clearing carry-over: " + err.Error())
+	}
+	for _, key := range keys {
+		if _, err = tx.Exec(SAVE_CARRY_QUERY, pool, ledger, key[0], key[1], sums[key]); err != nil {
+			tx.Rollback()
+			return errors.New("ERROR saving carry-over of " + key[0] + ": " + err.Error())
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.New("ERROR saving carry-over in history: " + err.Error())
+	}
+	return nil
+}
+
+// Carry over what the failed transactions of a run didn't deliver, once
+// the transaction results are known, to the destination of each payment.
+// The failed entries of the run are replaced, so running it again doesn't
+// count them twice. Returns how many destinations were carried
+func (s *Store) ReconcileCarryOver(pool string, ledger int32) (int, error) {
+	rows, err := s.db.Query(FAILED_PAYMENTS_QUERY, pool, ledger)
+	if err != nil {
+		return 0, errors.New("ERROR listing failed payments: " + err.Error())
+	}
+	failed := map[string]int64{}
+	for rows.Next() {
+		var destination string
+		var amount int64
+		if err = rows.Scan(&destination, &amount); err != nil {
+			rows.Close()
+			return 0, errors.New("ERROR scanning failed payment: " + err.Error())
+		}
+		failed[destination] = amount
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, errors.New("ERROR iterating failed payments: " + err.Error())
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, errors.New("ERROR starting history transaction: " + err.Error())
+	}
+	if _, err = tx.Exec(CLEAR_FAILED_CARRY_QUERY, pool, ledger); err != nil {
+		tx.Rollback()
+		return 0, errors.New("ERROR clearing carry-over: " + err.Error())
+	}
+	carried := 0
+	for destination, amount := range failed {
+		if amount <= 0 {
+			continue
+		}
+		if _, err = tx.Exec(SAVE_CARRY_QUERY, pool, ledger, destination, CARRY_FAILED, amount); err != nil {
+			tx.Rollback()
+			return 0, errors.New("ERROR saving carry-over of " + destination + ": " + err.Error())
+		}
+		carried++
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, errors.New("ERROR saving carry-over in history: " + err.Error())
+	}
+	return carried, nil
+}
@@ -0,0 +1,136 @@
+package history
+
+import (
+	"testing"
+	"github.com/matheusb-comp/go/pool/fixtures"
+)
+
+func TestCarryOver(t *testing.T) {
+	pool := fixtures.Address(110)
+	s := openStore(t, pool)
+	defer s.Close()
+
+	for _, ledger := range []int32{10, 20} {
+		if err := s.SaveSnapshot(testSnapshot(t, pool, ledger)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Run 10 leaves voterB below the minimum twice (added up) and fails to pay charity
+	err := s.SaveCarryOver(pool, 10, []CarryEntry{
+		{Account: voterB, Reason: CARRY_MIN_PAYOUT, Amount: 4},
+		{Account: voterB, Reason: CARRY_MIN_PAYOUT, Amount: 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SaveTransactionPayments(pool, 10, txA, TX_FAILED,
+		[]TxPayment{{Kind: "donation", Destination: charity, Amount: 50}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reconciling twice doesn't count the failed payments twice
+	for i := 0; i < 2; i++ {
+		n, err := s.ReconcileCarryOver(pool, 10)
+		if err != nil || n != 1 {
+			t.Fatalf("%d destinations carried (%v), want 1", n, err)
+		}
+	}
+	owed, err := s.Owed(pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(owed) != 2 || owed[voterB] != 7 || owed[charity] != 50 {
+		t.Fatalf("owed %v", owed)
+	}
+
+	// Run 20 pays voterB back, planned twice (the second plan replaces the first)
+	for i := 0; i < 2; i++ {
+		err = s.SaveCarryOver(pool, 20, []CarryEntry{{Account: voterB, Reason: CARRY_TAKEN, Amount: -7}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if owed, err = s.Owed(pool); err != nil || len(owed) != 1 || owed[charity] != 50 {
+		t.Errorf("owed %v (%v), want only charity", owed, err)
+	}
+	// Planning run 10 again keeps the failed payments
+	if err = s.SaveCarryOver(pool, 10, nil); err != nil {
+		t.Fatal(err)
+	}
+	if owed, err = s.Owed(pool); err != nil || owed[charity] != 50 {
+		t.Errorf("owed %v (%v), want charity still owed", owed, err)
+	}
+
+	entries, err := s.CarryOver(pool, voterB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Ledger != 20 || entries[0].Amount != -7 {
+		t.Errorf("carry-over of voterB %+v", entries)
+	}
+}
+
+func TestRunCarryOver(t *testing.T) {
+	pool := fixtures.Address(111)
+	s := openStore(t, pool)
+	defer s.Close()
+
+	if err := s.SaveSnapshot(testSnapshot(t, pool, 10)); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := s.RunCarryOver(pool, 10)
+	if err != nil || entries == nil || len(entries) != 0 {
+		t.Fatalf("carry-over %v (%v), want empty but not nil", entries, err)
+	}
+	err = s.SaveCarryOver(pool, 10, []CarryEntry{
+		{Account: voterB, Reason: CARRY_MIN_PAYOUT, Amount: 7},
+		{Account: voterA, Reason: CARRY_TAKEN, Amount: -5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only what the run left
+	entries, err = s.RunCarryOver(pool, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Account != voterB || entries[0].Amount != 7 ||
+		entries[0].Ledger != 10 {
+		t.Errorf("carry-over %+v", entries)
+	}
+}
+
+func TestOwedDonations(t *testing.T) {
+	pool := fixtures.Address(112)
+	s := openStore(t, pool)
+	defer s.Close()
+
+	for _, ledger := range []int32{10, 20} {
+		if err := s.SaveSnapshot(testSnapshot(t, pool, ledger)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := s.SaveCarryOver(pool, 10, []CarryEntry{
+		{Account: charity, Reason: CARRY_NO_TARGET, Amount: 30},
+		{Account: voterB, Reason: CARRY_NO_DESTINATION, Amount: 9},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets, err := s.OwedDonations(pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 1 || !targets[charity] {
+		t.Errorf("owed donations %v, want charity", targets)
+	}
+
+	// Once paid, nothing is owed
+	err = s.SaveCarryOver(pool, 20, []CarryEntry{{Account: charity, Reason: CARRY_TAKEN, Amount: -30}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if targets, err = s.OwedDonations(pool); err != nil || len(targets) != 0 {
+		t.Errorf("owed donations %v (%v) after paying, want none", targets, err)
+	}
+}
@@ -0,0 +1,85 @@
+package history
+
+import (
+	"time"
+	"errors"
+)
+
+// Saving the donations of a run again replaces them
+const SAVE_DONATION_QUERY = `INSERT INTO donation_payouts
+(pool, ledger, destination, amount, donors) VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (pool, ledger, destination) DO UPDATE
+SET amount = EXCLUDED.amount, donors = EXCLUDED.donors`
+
+// Donations of the latest $2 runs of the pool (newest first)
+const LIST_DONATIONS_QUERY = `SELECT d.ledger, r.created_at, d.destination, d.amount, d.donors
+FROM donation_payouts d JOIN inflation_runs r ON r.pool = d.pool AND r.ledger = d.ledger
+WHERE d.pool = $1 AND d.ledger IN (SELECT ledger FROM inflation_runs
+WHERE pool = $1 ORDER BY ledger DESC LIMIT $2)
+ORDER BY d.ledger DESC, d.amount DESC`
+
+// Total donated to one destination (a charity) in a run
+type Donation struct {
+	Destination string `json:"destination"`
+	Amount uint64 `json:"amount"`
+	// Voters that donated to it
+	Donors int `json:"donors"`
+}
+
+// Donations of one run
+type RunDonations struct {
+	Ledger int32 `json:"ledger"`
+	Date time.Time `json:"date"`
+	Total uint64 `json:"total"`
+	Donations []Donation `json:"donations"`
+}
+
+// Record the donations of a run, one row per destination
+func (s *Store) SaveDonations(pool string, ledger int32, donations []Donation) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errors.New("ERROR starting history transaction: " + err.Error())
+	}
+	for _, d := range donations {
+		_, err = tx.Exec(SAVE_DONATION_QUERY, pool, ledger, d.Destination, int64(d.Amount), d.Donors)
+		if err != nil {
+			tx.Rollback()
+			return errors.New("ERROR saving donation to " + d.Destination + ": " + err.Error())
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.New("ERROR saving donations in history: " + err.Error())
+	}
+	return nil
+}
+
+// Totals donated to each destination in the latest runs of the pool
+func (s *Store) ListDonations(pool string, limit int) ([]RunDonations, error) {
+	rows, err := s.db.Query(LIST_DONATIONS_QUERY, pool, limit)
+	if err != nil {
+		return nil, errors.New("ERROR listing donations: " + err.Error())
+	}
+	defer rows.Close()
+
+	runs := []RunDonations{}
+	for rows.Next() {
+		var ledger int32
+		var date time.Time
+		var d Donation
+		var amount int64
+		if err = rows.Scan(&ledger, &date, &d.Destination, &amount, &d.Donors); err != nil {
+			return nil, errors.New("ERROR scanning donation: " + err.Error())
+		}
+		d.Amount = uint64(amount)
+		if len(runs) == 0 || runs[len(runs)-1].Ledger != ledger {
+			runs = append(runs, RunDonations{Ledger: ledger, Date: date})
+		}
+		run := &runs[len(runs)-1]
+		run.Total += d.Amount
+		run.Donations = append(run.Donations, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New("ERROR iterating donations: " + err.Error())
+	}
+	return runs, nil
+}
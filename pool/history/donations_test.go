@@ -0,0 +1,51 @@
+package history
+
+import (
+	"testing"
+	"github.com/matheusb-comp/go/pool/fixtures"
+)
+
+func TestDonations(t *testing.T) {
+	pool := fixtures.Address(120)
+	s := openStore(t, pool)
+	defer s.Close()
+	other := fixtures.Address(11)
+
+	for _, ledger := range []int32{10, 20, 30} {
+		if err := s.SaveSnapshot(testSnapshot(t, pool, ledger)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saves := []struct {
+		ledger int32
+		donations []Donation
+	}{
+		{10, []Donation{{Destination: charity, Amount: 100, Donors: 2}}},
+		{20, []Donation{{Destination: charity, Amount: 40, Donors: 1},
+			{Destination: other, Amount: 60, Donors: 3}}},
+		// Saved again, replaced
+		{20, []Donation{{Destination: charity, Amount: 50, Donors: 1}}},
+	}
+	for _, save := range saves {
+		if err := s.SaveDonations(pool, save.ledger, save.donations); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The latest 2 runs, run 30 without donations
+	runs, err := s.ListDonations(pool, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].Ledger != 20 || runs[0].Total != 110 || runs[0].Date.IsZero() {
+		t.Fatalf("donations %+v, want only run 20", runs)
+	}
+	// Largest first
+	if d := runs[0].Donations; len(d) != 2 || d[0].Destination != other || d[1].Amount != 50 {
+		t.Errorf("donations of run 20 %+v", d)
+	}
+
+	if runs, err = s.ListDonations(pool, 3); err != nil || len(runs) != 2 || runs[1].Total != 100 {
+		t.Errorf("donations %+v (%v), want runs 20 and 10", runs, err)
+	}
+}
@@ -0,0 +1,504 @@
+package history
+
+import (
+	"time"
+	"errors"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/matheusb-comp/go/pool/notify"
+	"github.com/matheusb-comp/go/pool/getvoters"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+// Tables owned by the pool tools (never in the stellar-core database).
+// Every statement is idempotent, so Migrate can run on every start
+var MIGRATIONS = []string{
+	`CREATE TABLE IF NOT EXISTS inflation_runs (
+		pool VARCHAR(56) NOT NULL,
+		ledger INTEGER NOT NULL,
+		network TEXT NOT NULL,
+		credit BIGINT NOT NULL,
+		voters BIGINT NOT NULL,
+		votes BIGINT NOT NULL,
+		snapshot_hash CHAR(64) NOT NULL,
+		merkle_root CHAR(64) NOT NULL,
+		snapshot TEXT NOT NULL,
+		payout_plan TEXT,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		PRIMARY KEY (pool, ledger)
+	)`,
+	`CREATE TABLE IF NOT EXISTS payout_transactions (
+		hash CHAR(64) PRIMARY KEY,
+		pool VARCHAR(56) NOT NULL,
+		ledger INTEGER NOT NULL,
+		status TEXT NOT NULL,
+		submitted_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		FOREIGN KEY (pool, ledger) REFERENCES inflation_runs (pool, ledger)
+	)`,
+	`CREATE TABLE IF NOT EXISTS voter_payouts (
+		pool VARCHAR(56) NOT NULL,
+		ledger INTEGER NOT NULL,
+		account VARCHAR(56) NOT NULL,
+		balance BIGINT NOT NULL,
+		gross BIGINT NOT NULL,
+		fee BIGINT NOT NULL,
+		donations BIGINT NOT NULL,
+		net BIGINT NOT NULL,
+		tx_hash CHAR(64),
+		PRIMARY KEY (pool, ledger, account),
+		FOREIGN KEY (pool, ledger) REFERENCES inflation_runs (pool, ledger)
+	)`,
+	`CREATE INDEX IF NOT EXISTS voter_payouts_account
+		ON voter_payouts (account, ledger)`,
+	`CREATE TABLE IF NOT EXISTS notification_deliveries (
+		id SERIAL PRIMARY KEY,
+		pool VARCHAR(56) NOT NULL,
+		ledger INTEGER NOT NULL,
+		event TEXT NOT NULL,
+		channel TEXT NOT NULL,
+		attempts INTEGER NOT NULL,
+		status TEXT NOT NULL,
+		error TEXT,
+		delivered_at TIMESTAMP WITH TIME ZONE NOT NULL
+	)`,
+	`ALTER TABLE voter_payouts
+		ADD COLUMN IF NOT EXISTS owed BIGINT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS carried BIGINT NOT NULL DEFAULT 0`,
+	`CREATE TABLE IF NOT EXISTS carry_over (
+		pool VARCHAR(56) NOT NULL,
+		ledger INTEGER NOT NULL,
+		account VARCHAR(56) NOT NULL,
+		reason TEXT NOT NULL,
+		amount BIGINT NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		PRIMARY KEY (pool, ledger, account, reason)
+	)`,
+	`CREATE INDEX IF NOT EXISTS carry_over_account
+		ON carry_over (pool, account)`,
+	`CREATE TABLE IF NOT EXISTS payout_payments (
+		tx_hash CHAR(64) NOT NULL REFERENCES payout_transactions (hash),
+		position INTEGER NOT NULL,
+		kind TEXT NOT NULL,
+		destination VARCHAR(56) NOT NULL,
+		amount BIGINT NOT NULL,
+		PRIMARY KEY (tx_hash, position)
+	)`,
+	`CREATE TABLE IF NOT EXISTS donation_payouts (
+		pool VARCHAR(56) NOT NULL,
+		ledger INTEGER NOT NULL,
+		destination VARCHAR(56) NOT NULL,
+		amount BIGINT NOT NULL,
+		donors INTEGER NOT NULL,
+		PRIMARY KEY (pool, ledger, destination),
+		FOREIGN KEY (pool, ledger) REFERENCES inflation_runs (pool, ledger)
+	)`,
+}
+
+const SAVE_RUN_QUERY = `INSERT INTO inflation_runs
+(pool, ledger, network, credit, voters, votes, snapshot_hash, merkle_root, snapshot)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+ON CONFLICT (pool, ledger) DO UPDATE SET
+network = EXCLUDED.network, credit = EXCLUDED.credit,
+voters = EXCLUDED.voters, votes = EXCLUDED.votes,
+snapshot_hash = EXCLUDED.snapshot_hash, merkle_root = EXCLUDED.merkle_root,
+snapshot = EXCLUDED.snapshot`
+
+const SAVE_PLAN_QUERY = `UPDATE inflation_runs SET payout_plan = $3
+WHERE pool = $1 AND ledger = $2`
+
+const SAVE_TRANSACTION_QUERY = `INSERT INTO payout_transactions
+(hash, pool, ledger, status) VALUES ($1, $2, $3, $4)
+ON CONFLICT (hash) DO UPDATE SET status = EXCLUDED.status`
+
+const SAVE_TX_PAYMENT_QUERY = `INSERT INTO payout_payments
+(tx_hash, position, kind, destination, amount) VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (tx_hash, position) DO UPDATE SET kind = EXCLUDED.kind,
+destination = EXCLUDED.destination, amount = EXCLUDED.amount`
+
+const SAVE_PAYOUT_QUERY = `INSERT INTO voter_payouts
+(pool, ledger, account, balance, gross, fee, donations, net, owed, carried)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+ON CONFLICT (pool, ledger, account) DO UPDATE SET
+balance = EXCLUDED.balance, gross = EXCLUDED.gross, fee = EXCLUDED.fee,
+donations = EXCLUDED.donations, net = EXCLUDED.net,
+owed = EXCLUDED.owed, carried = EXCLUDED.carried`
+
+const SET_PAYOUT_TX_QUERY = `UPDATE voter_payouts SET tx_hash = $4
+WHERE pool = $1 AND ledger = $2 AND account = $3`
+
+const SAVE_DELIVERY_QUERY = `INSERT INTO notification_deliveries
+(pool, ledger, event, channel, attempts, status, error, delivered_at)
+VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8)`
+
+const VOTER_PAYOUTS_QUERY = `SELECT p.ledger, r.created_at, p.account,
+p.balance, p.gross, p.fee, p.donations, p.net, p.owed, p.carried, p.tx_hash
+FROM voter_payouts p JOIN inflation_runs r
+ON r.pool = p.pool AND r.ledger = p.ledger
+WHERE p.pool = $1 AND p.account = $2 ORDER BY p.ledger DESC LIMIT $3`
+
+const RUN_COLUMNS = `pool, ledger, network, credit, voters, votes,
+snapshot_hash, merkle_root, created_at`
+
+const LIST_RUNS_QUERY = `SELECT ` + RUN_COLUMNS + `
+FROM inflation_runs WHERE pool = $1 ORDER BY ledger DESC LIMIT $2`
+
+const GET_RUN_QUERY = `SELECT ` + RUN_COLUMNS + `, payout_plan
+FROM inflation_runs WHERE pool = $1 AND ledger = $2`
+
+const GET_SNAPSHOT_QUERY = `SELECT snapshot
+FROM inflation_runs WHERE pool = $1 AND ledger = $2`
+
+const LIST_TRANSACTIONS_QUERY = `SELECT hash, status, submitted_at
+FROM payout_transactions WHERE pool = $1 AND ledger = $2 ORDER BY submitted_at`
+
+const LIST_TX_PAYMENTS_QUERY = `SELECT p.tx_hash, p.kind, p.destination, p.amount
+FROM payout_payments p JOIN payout_transactions t ON t.hash = p.tx_hash
+WHERE t.pool = $1 AND t.ledger = $2 ORDER BY p.tx_hash, p.position`
+
+// Returned when there is no run for the pool and ledger
+var ErrNotFound = errors.New("ERROR: Inflation run not found")
+
+// One inflation run, keyed by pool and inflation ledger
+type Run struct {
+	Pool string `json:"pool"`
+	Ledger int32 `json:"ledger"`
+	Network string `json:"network_passphrase"`
+	Credit uint64 `json:"credit"`
+	Totals snapshot.Totals `json:"totals"`
+	Hash string `json:"snapshot_hash"`
+	MerkleRoot string `json:"merkle_root"`
+	CreatedAt time.Time `json:"created_at"`
+	// Only filled when getting a single run
+	Plan json.RawMessage `json:"payout_plan,omitempty"`
+	Transactions []Transaction `json:"transactions,omitempty"`
+}
+
+// Payout transaction submitted for a run
+type Transaction struct {
+	Hash string `json:"hash"`
+	Status string `json:"status"`
+	SubmittedAt time.Time `json:"submitted_at"`
+	Payments []TxPayment `json:"payments,omitempty"`
+}
+
+// Payment of a payout transaction (the XLM value of it, in stroops)
+type TxPayment struct {
+	Kind string `json:"kind"`
+	Destination string `json:"destination"`
+	Amount uint64 `json:"amount"`
+}
+
+// Transaction status values
+const (
+	TX_SUBMITTED = "submitted"
+	TX_SUCCESS = "success"
+	TX_FAILED = "failed"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+// Open the history database and create the tables if needed
+func Open(cfg *getvoters.ConnConfig) (*Store, error) {
+	db, err := cfg.Open()
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{db}
+	if err = s.Migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) Close() error {
+	if err := s.db.Close(); err != nil {
+		return errors.New("ERROR closing history DB connection: " + err.Error())
+	}
+	return nil
+}
+
+// Create the tables owned by the store
+func (s *Store) Migrate() error {
+	for _, m := range MIGRATIONS {
+		if _, err := s.db.Exec(m); err != nil {
+			return errors.New("ERROR migrating history DB: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Record the snapshot of a run (replacing the one of the same ledger)
+func (s *Store) SaveSnapshot(snap *snapshot.Snapshot) error {
+	b, err := snapshot.Encode(snap)
+	if err != nil {
+		return err
+	}
+	hash, err := snap.HashHex()
+	if err != nil {
+		return err
+	}
+	root, err := snap.MerkleRoot()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(SAVE_RUN_QUERY, snap.Pool, snap.Ledger, snap.Network,
+		int64(snap.Credit), int64(snap.Totals.Voters), int64(snap.Totals.Votes),
+		hash, hex.EncodeToString(root[:]), string(b))
+	if err != nil {
+		return errors.New("ERROR saving snapshot in history: " + err.Error())
+	}
+	return nil
+}
+
+// Record the payout plan of a run (any JSON-encodable value)
+func (s *Store) SavePlan(pool string, ledger int32, plan interface{}) error {
+	b, err := json.Marshal(plan)
+	if err != nil {
+		return errors.New("ERROR encoding payout plan: " + err.Error())
+	}
+	res, err := s.db.Exec(SAVE_PLAN_QUERY, pool, ledger, string(b))
+	if err != nil {
+		return errors.New("ERROR saving payout plan in history: " + err.Error())
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Record (or update the status of) a payout transaction of a run
+func (s *Store) SaveTransaction(pool string, ledger int32, hash, status string) error {
+	_, err := s.db.Exec(SAVE_TRANSACTION_QUERY, hash, pool, ledger, status)
+	if err != nil {
+		return errors.New("ERROR saving transaction in history: " + err.Error())
+	}
+	return nil
+}
+
+// Record (or update the status of) a payout transaction of a run, with
+// its payments, in one transaction
+func (s *Store) SaveTransactionPayments(pool string, ledger int32, hash, status string,
+	payments []TxPayment) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errors.New("ERROR starting history transaction: " + err.Error())
+	}
+	if _, err = tx.Exec(SAVE_TRANSACTION_QUERY, hash, pool, ledger, status); err != nil {
+		tx.Rollback()
+		return errors.New("ERROR saving transaction in history: " + err.Error())
+	}
+	for i, p := range payments {
+		if _, err = tx.Exec(SAVE_TX_PAYMENT_QUERY, hash, i, p.Kind, p.Destination, int64(p.Amount)); err != nil {
+			tx.Rollback()
+			return errors.New("ERROR saving payment of " + hash + ": " + err.Error())
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.New("ERROR saving transaction in history: " + err.Error())
+	}
+	return nil
+}
+
+// Latest runs of a pool (newest first)
+func (s *Store) ListRuns(pool string, limit int) ([]Run, error) {
+	rows, err := s.db.Query(LIST_RUNS_QUERY, pool, limit)
+	if err != nil {
+		return nil, errors.New("ERROR listing runs: " + err.Error())
+	}
+	defer rows.Close()
+
+	runs := []Run{}
+	for rows.Next() {
+		var r Run
+		if err = scanRun(rows, &r); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New("ERROR iterating runs: " + err.Error())
+	}
+	return runs, nil
+}
+
+// One run, with its payout plan and transactions (and their payments)
+func (s *Store) GetRun(pool string, ledger int32) (*Run, error) {
+	var r Run
+	var plan sql.NullString
+	err := scanRun(s.db.QueryRow(GET_RUN_QUERY, pool, ledger), &r, &plan)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if plan.Valid {
+		r.Plan = json.RawMessage(plan.String)
+	}
+
+	rows, err := s.db.Query(LIST_TRANSACTIONS_QUERY, pool, ledger)
+	if err != nil {
+		return nil, errors.New("ERROR listing transactions: " + err.Error())
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t Transaction
+		if err = rows.Scan(&t.Hash, &t.Status, &t.SubmittedAt); err != nil {
+			return nil, errors.New("ERROR scanning transaction: " + err.Error())
+		}
+		r.Transactions = append(r.Transactions, t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New("ERROR iterating transactions: " + err.Error())
+	}
+	if err = s.listPayments(&r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Add the journaled payments to the transactions of the run
+func (s *Store) listPayments(r *Run) error {
+	rows, err := s.db.Query(LIST_TX_PAYMENTS_QUERY, r.Pool, r.Ledger)
+	if err != nil {
+		return errors.New("ERROR listing payments: " + err.Error())
+	}
+	defer rows.Close()
+	index := map[string]int{}
+	for i, t := range r.Transactions {
+		index[t.Hash] = i
+	}
+	for rows.Next() {
+		var hash string
+		var p TxPayment
+		var amount int64
+		if err = rows.Scan(&hash, &p.Kind, &p.Destination, &amount); err != nil {
+			return errors.New("ERROR scanning payment: " + err.Error())
+		}
+		p.Amount = uint64(amount)
+		if i, ok := index[hash]; ok {
+			r.Transactions[i].Payments = append(r.Transactions[i].Payments, p)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return errors.New("ERROR iterating payments: " + err.Error())
+	}
+	return nil
+}
+
+// The snapshot of a past run
+func (s *Store) GetSnapshot(pool string, ledger int32) (*snapshot.Snapshot, error) {
+	var b string
+	err := s.db.QueryRow(GET_SNAPSHOT_QUERY, pool, ledger).Scan(&b)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.New("ERROR getting snapshot: " + err.Error())
+	}
+	return snapshot.Decode([]byte(b))
+}
+
+// Anything with the Scan of sql.Row and sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRun(row scanner, r *Run, extra ...interface{}) error {
+	var credit, voters, votes int64
+	dest := append([]interface{}{&r.Pool, &r.Ledger, &r.Network, &credit,
+		&voters, &votes, &r.Hash, &r.MerkleRoot, &r.CreatedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		if err == sql.ErrNoRows {
+			return err
+		}
+		return errors.New("ERROR scanning run: " + err.Error())
+	}
+	r.Credit = uint64(credit)
+	r.Totals = snapshot.Totals{Voters: uint64(voters), Votes: uint64(votes)}
+	return nil
+}
+
+// Record the planned payout of each voter of a run (in one transaction)
+func (s *Store) SavePayouts(pool string, ledger int32, payouts []snapshot.Payout) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errors.New("ERROR starting history transaction: " + err.Error())
+	}
+	for _, p := range payouts {
+		_, err = tx.Exec(SAVE_PAYOUT_QUERY, pool, ledger, p.Account, int64(p.Bal),
+			int64(p.Gross), int64(p.Fee), int64(p.Donations), int64(p.Net),
+			int64(p.Owed), int64(p.Carried))
+		if err != nil {
+			tx.Rollback()
+			return errors.New("ERROR saving payout of " + p.Account + ": " + err.Error())
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.New("ERROR saving payouts in history: " + err.Error())
+	}
+	return nil
+}
+
+// Set the transaction that paid the voters of a run
+func (s *Store) SetPayoutTransaction(pool string, ledger int32, accounts []string, hash string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errors.New("ERROR starting history transaction: " + err.Error())
+	}
+	for _, account := range accounts {
+		if _, err = tx.Exec(SET_PAYOUT_TX_QUERY, pool, ledger, account, hash); err != nil {
+			tx.Rollback()
+			return errors.New("ERROR setting payout transaction of " + account + ": " + err.Error())
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.New("ERROR saving payout transactions in history: " + err.Error())
+	}
+	return nil
+}
+
+// Latest payouts of one voter of the pool (newest first)
+func (s *Store) VoterPayouts(pool, account string, limit int) ([]snapshot.Payout, error) {
+	rows, err := s.db.Query(VOTER_PAYOUTS_QUERY, pool, account, limit)
+	if err != nil {
+		return nil, errors.New("ERROR listing payouts: " + err.Error())
+	}
+	defer rows.Close()
+
+	payouts := []snapshot.Payout{}
+	for rows.Next() {
+		var p snapshot.Payout
+		var bal, gross, fee, donations, net, owed, carried int64
+		var hash sql.NullString
+		err = rows.Scan(&p.Ledger, &p.Date, &p.Account, &bal, &gross, &fee,
+			&donations, &net, &owed, &carried, &hash)
+		if err != nil {
+			return nil, errors.New("ERROR scanning payout: " + err.Error())
+		}
+		p.Bal, p.Gross, p.Fee = uint64(bal), uint64(gross), uint64(fee)
+		p.Donations, p.Net, p.Transaction = uint64(donations), uint64(net), hash.String
+		p.Owed, p.Carried = uint64(owed), uint64(carried)
+		payouts = append(payouts, p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New("ERROR iterating payouts: " + err.Error())
+	}
+	return payouts, nil
+}
+
+// Record the result of sending a notification (not tied to a run, since a
+// fatal error can happen before the snapshot is saved)
+func (s *Store) SaveDelivery(d notify.Delivery) error {
+	_, err := s.db.Exec(SAVE_DELIVERY_QUERY, d.Pool, d.Ledger, d.Event,
+		d.Channel, d.Attempts, d.Status, d.Error, d.Time)
+	if err != nil {
+		return errors.New("ERROR saving notification delivery: " + err.Error())
+	}
+	return nil
+}
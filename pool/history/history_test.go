@@ -0,0 +1,236 @@
+package history
+
+import (
+	"os"
+	"time"
+	"strings"
+	"testing"
+	"github.com/stellar/go/network"
+	"github.com/matheusb-comp/go/pool/notify"
+	"github.com/matheusb-comp/go/pool/fixtures"
+	"github.com/matheusb-comp/go/pool/getvoters"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+// PostgreSQL connection string of a scratch database for the tests (they're
+// skipped without it). Each test works on its own pool, cleared first
+const TEST_CONN_ENV = "HISTORY_TEST_CONN"
+
+var CLEAR_QUERIES = []string{
+	`DELETE FROM payout_payments WHERE tx_hash IN
+	(SELECT hash FROM payout_transactions WHERE pool = $1)`,
+	`DELETE FROM payout_transactions WHERE pool = $1`,
+	`DELETE FROM voter_payouts WHERE pool = $1`,
+	`DELETE FROM donation_payouts WHERE pool = $1`,
+	`DELETE FROM carry_over WHERE pool = $1`,
+	`DELETE FROM notification_deliveries WHERE pool = $1`,
+	`DELETE FROM inflation_runs WHERE pool = $1`,
+}
+
+var (
+	voterA = fixtures.Address(1)
+	voterB = fixtures.Address(2)
+	charity = fixtures.Address(10)
+	// Transaction hashes (the column is CHAR(64))
+	txA = strings.Repeat("a", 64)
+	txB = strings.Repeat("b", 64)
+)
+
+// Open the test store (migrating it twice, it must be idempotent) and
+// clear the rows of the pool
+func openStore(t *testing.T, pool string) *Store {
+	conn := os.Getenv(TEST_CONN_ENV)
+	if conn == "" {
+		t.Skip("no test history DB (set " + TEST_CONN_ENV + ")")
+	}
+	s, err := Open(&getvoters.ConnConfig{Conn: conn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range CLEAR_QUERIES {
+		if _, err = s.db.Exec(q, pool); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+// Snapshot of two voters of the pool, one donating
+func testSnapshot(t *testing.T, pool string, ledger int32) *snapshot.Snapshot {
+	core := &fixtures.Core{}
+	core.AddAccount(fixtures.Account{ID: voterA, Balance: 1000, InflationDest: pool,
+		Data: map[string]string{"lumenaut.net donation": "10%" + charity}})
+	core.AddAccount(fixtures.Account{ID: voterB, Balance: 300, InflationDest: pool})
+	s, err := core.Snapshot(network.TestNetworkPassphrase, pool, "lumenaut.net donation%", ledger, 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRuns(t *testing.T) {
+	pool := fixtures.Address(100)
+	s := openStore(t, pool)
+	defer s.Close()
+
+	if _, err := s.GetRun(pool, 10); err != ErrNotFound {
+		t.Errorf("run not saved: %v, want ErrNotFound", err)
+	}
+	if err := s.SavePlan(pool, 10, map[string]int{"fee": 1}); err != ErrNotFound {
+		t.Errorf("plan of a run not saved: %v, want ErrNotFound", err)
+	}
+	for _, ledger := range []int32{10, 20, 30} {
+		if err := s.SaveSnapshot(testSnapshot(t, pool, ledger)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Saved again, replaced
+	snap := testSnapshot(t, pool, 20)
+	if err := s.SaveSnapshot(snap); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := s.ListRuns(pool, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].Ledger != 30 || runs[1].Ledger != 20 {
+		t.Fatalf("runs %+v, want the ledgers 30 and 20", runs)
+	}
+	hash, _ := snap.HashHex()
+	if r := runs[1]; r.Hash != hash || r.Credit != 5000 || r.Totals != snap.Totals ||
+		r.Network != network.TestNetworkPassphrase || r.Plan != nil {
+		t.Errorf("run %+v", r)
+	}
+
+	if err = s.SavePlan(pool, 20, map[string]int{"fee": 1}); err != nil {
+		t.Fatal(err)
+	}
+	for _, hash := range []string{txA, txB} {
+		if err = s.SaveTransaction(pool, 20, hash, TX_SUBMITTED); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.SaveTransaction(pool, 20, txA, TX_SUCCESS); err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.GetRun(pool, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r.Plan) != `{"fee":1}` || len(r.Transactions) != 2 {
+		t.Fatalf("run %+v, want the plan and 2 transactions", r)
+	}
+	for _, tx := range r.Transactions {
+		if (tx.Hash == txA) != (tx.Status == TX_SUCCESS) {
+			t.Errorf("transaction %+v", tx)
+		}
+	}
+
+	got, err := s.GetSnapshot(pool, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, _ := got.HashHex(); h != hash {
+		t.Errorf("snapshot hash %s, want %s", h, hash)
+	}
+	if _, err = s.GetSnapshot(pool, 40); err != ErrNotFound {
+		t.Errorf("snapshot not saved: %v, want ErrNotFound", err)
+	}
+}
+
+func TestVoterPayouts(t *testing.T) {
+	pool := fixtures.Address(101)
+	s := openStore(t, pool)
+	defer s.Close()
+
+	for _, ledger := range []int32{10, 20} {
+		if err := s.SaveSnapshot(testSnapshot(t, pool, ledger)); err != nil {
+			t.Fatal(err)
+		}
+		err := s.SavePayouts(pool, ledger, []snapshot.Payout{
+			{Account: voterA, Bal: 1000, Gross: 3000, Fee: 30, Donations: 297, Net: 2673, Owed: 5},
+			{Account: voterB, Bal: 300, Gross: 900, Fee: 9, Net: 891},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := s.SetPayoutTransaction(pool, 20, []string{voterA}, txA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payouts, err := s.VoterPayouts(pool, voterA, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payouts) != 2 || payouts[0].Ledger != 20 || payouts[1].Ledger != 10 {
+		t.Fatalf("payouts %+v, want the ledgers 20 and 10", payouts)
+	}
+	if p := payouts[0]; p.Net != 2673 || p.Donations != 297 || p.Owed != 5 ||
+		p.Transaction != txA || p.Date.IsZero() {
+		t.Errorf("payout %+v", p)
+	}
+	if payouts[1].Transaction != "" {
+		t.Errorf("payout of ledger 10 with transaction %s", payouts[1].Transaction)
+	}
+
+	if payouts, err = s.VoterPayouts(pool, charity, 10); err != nil || len(payouts) != 0 {
+		t.Errorf("payouts of a non voter %+v (%v)", payouts, err)
+	}
+}
+
+func TestSaveDelivery(t *testing.T) {
+	pool := fixtures.Address(102)
+	s := openStore(t, pool)
+	defer s.Close()
+
+	// Not tied to a run (fatal errors happen before the snapshot is saved)
+	err := s.SaveDelivery(notify.Delivery{Event: notify.EVENT_FATAL, Pool: pool,
+		Channel: "webhook", Attempts: 3, Status: notify.DELIVERY_FAILED,
+		Error: "503 Service Unavailable", Time: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	err = s.db.QueryRow(`SELECT COUNT(*) FROM notification_deliveries WHERE pool = $1`, pool).Scan(&n)
+	if err != nil || n != 1 {
+		t.Errorf("%d deliveries (%v), want 1", n, err)
+	}
+}
+
+func TestTransactionPayments(t *testing.T) {
+	pool := fixtures.Address(103)
+	s := openStore(t, pool)
+	defer s.Close()
+
+	if err := s.SaveSnapshot(testSnapshot(t, pool, 10)); err != nil {
+		t.Fatal(err)
+	}
+	payments := []TxPayment{
+		{Kind: "donation", Destination: charity, Amount: 297},
+		{Kind: "voter", Destination: voterA, Amount: 2673},
+	}
+	if err := s.SaveTransactionPayments(pool, 10, txA, TX_SUBMITTED, payments); err != nil {
+		t.Fatal(err)
+	}
+	// The result known, only the status changes
+	if err := s.SaveTransactionPayments(pool, 10, txA, TX_FAILED, nil); err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.GetRun(pool, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Transactions) != 1 || r.Transactions[0].Status != TX_FAILED {
+		t.Fatalf("transactions %+v", r.Transactions)
+	}
+	got := r.Transactions[0].Payments
+	if len(got) != 2 || got[0] != payments[0] || got[1] != payments[1] {
+		t.Errorf("payments %+v, want %+v", got, payments)
+	}
+}
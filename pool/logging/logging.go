@@ -0,0 +1,137 @@
+package logging
+
+import (
+	"flag"
+	"time"
+	"errors"
+	"context"
+	"net/http"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/sirupsen/logrus"
+)
+
+// Header used to receive and return the request ID
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+// Field names shared by every command, so the logs can be searched together
+const (
+	FIELD_LEDGER = "ledger"
+	FIELD_POOL = "pool"
+	FIELD_REQUEST_ID = "request_id"
+	FIELD_DURATION = "duration"
+)
+
+// Key of the request logger in the request context
+type ctxKey struct{}
+
+// Logging options shared by the commands
+type Config struct {
+	// debug, info, warn or error
+	Level string
+	// text or json
+	Format string
+}
+
+// Define the logging flags in the flag set
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Level, "log-level", "info",
+		"Minimum level of the log messages (debug, info, warn or error)")
+
+	fs.StringVar(&c.Format, "log-format", "text",
+		"Format of the log messages (text or json)")
+}
+
+// Create a logger with the configured level and format
+func (c *Config) New() (*logrus.Logger, error) {
+	l := logrus.New()
+	if err := c.Apply(l); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Apply the level and format to an existing logger (used when reloading)
+func (c *Config) Apply(l *logrus.Logger) error {
+	level, err := logrus.ParseLevel(c.Level)
+	if err != nil {
+		return errors.New("ERROR parsing log level: " + err.Error())
+	}
+
+	var formatter logrus.Formatter
+	switch c.Format {
+	case "text":
+		formatter = &logrus.TextFormatter{FullTimestamp: true}
+	case "json":
+		formatter = &logrus.JSONFormatter{}
+	default:
+		return errors.New("ERROR: Unknown log format: " + c.Format)
+	}
+
+	l.SetLevel(level)
+	l.Formatter = formatter
+	return nil
+}
+
+// Get the logger of a request (with its ID), set by AccessLog
+func FromRequest(r *http.Request, l *logrus.Logger) *logrus.Entry {
+	if e, ok := r.Context().Value(ctxKey{}).(*logrus.Entry); ok {
+		return e
+	}
+	return logrus.NewEntry(l)
+}
+
+// Middleware that tags each request with an ID and logs it once it's done
+func AccessLog(l *logrus.Logger, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		// Keep the ID set by a proxy, or create a new one
+		id := r.Header.Get(REQUEST_ID_HEADER)
+		if id == "" {
+			id = newRequestID()
+		}
+		w.Header().Set(REQUEST_ID_HEADER, id)
+
+		entry := l.WithField(FIELD_REQUEST_ID, id)
+		r = r.WithContext(context.WithValue(r.Context(), ctxKey{}, entry))
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r)
+
+		entry.WithFields(logrus.Fields{
+			"method": r.Method,
+			"url": r.URL.String(),
+			"remote": r.RemoteAddr,
+			"status": sw.status,
+			FIELD_DURATION: time.Since(start).String(),
+		}).Info("Request")
+	})
+}
+
+// Random 16 characters hexadecimal ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// Response writer that remembers the status code sent
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Streaming responses (Server-Sent Events) need to flush through the wrapper
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
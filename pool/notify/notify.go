@@ -0,0 +1,418 @@
+package notify
+
+import (
+	"fmt"
+	"flag"
+	"sort"
+	"time"
+	"bytes"
+	"errors"
+	"context"
+	"strings"
+	"net/http"
+	"net/smtp"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Events of the inflation lifecycle
+const (
+	EVENT_INFLATION = "inflation_detected"
+	EVENT_SNAPSHOT = "snapshot_saved"
+	EVENT_PLAN = "payout_plan_ready"
+	EVENT_SUBMITTED = "payouts_submitted"
+	EVENT_FATAL = "fatal_error"
+)
+
+// Every event, in lifecycle order
+var EVENTS = []string{EVENT_INFLATION, EVENT_SNAPSHOT, EVENT_PLAN,
+	EVENT_SUBMITTED, EVENT_FATAL}
+
+// Header with the HMAC-SHA256 of the webhook body ("sha256=<hex>")
+const SIGNATURE_HEADER = "X-Pool-Signature"
+const EVENT_HEADER = "X-Pool-Event"
+
+// Time limit of each delivery attempt
+const SEND_TIMEOUT = 10 * time.Second
+
+// Delivery status values
+const (
+	DELIVERY_SENT = "sent"
+	DELIVERY_FAILED = "failed"
+)
+
+// Something that happened, sent to every channel
+type Notification struct {
+	Event string `json:"event"`
+	Pool string `json:"pool"`
+	Ledger int32 `json:"ledger,omitempty"`
+	Time time.Time `json:"time"`
+	Message string `json:"message"`
+	// Details of the event (hash, file, error, ...)
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// One line summary, for the email subject and chat messages
+func (n *Notification) Summary() string {
+	s := "[" + n.Event + "] " + n.Message
+	if n.Ledger != 0 {
+		s += fmt.Sprintf(" (ledger %d)", n.Ledger)
+	}
+	return s
+}
+
+// Summary followed by the fields, sorted by name
+func (n *Notification) Text() string {
+	var b strings.Builder
+	b.WriteString(n.Summary())
+	b.WriteString("\npool: " + n.Pool)
+	for _, k := range sortedKeys(n.Fields) {
+		b.WriteString("\n" + k + ": " + n.Fields[k])
+	}
+	return b.String()
+}
+
+// Result of sending a notification to one channel
+type Delivery struct {
+	Event string `json:"event"`
+	Pool string `json:"pool"`
+	Ledger int32 `json:"ledger"`
+	Channel string `json:"channel"`
+	Attempts int `json:"attempts"`
+	Status string `json:"status"`
+	Error string `json:"error,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// Destination of the notifications
+type Channel interface {
+	Name() string
+	Send(ctx context.Context, n *Notification) error
+}
+
+// Generic webhook: the notification as JSON, signed with the shared secret
+type Webhook struct {
+	URL string
+	Secret string
+	Client *http.Client
+}
+
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+func (w *Webhook) Send(ctx context.Context, n *Notification) error {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return errors.New("ERROR encoding notification: " + err.Error())
+	}
+	headers := map[string]string{EVENT_HEADER: n.Event}
+	if w.Secret != "" {
+		headers[SIGNATURE_HEADER] = "sha256=" + Sign(w.Secret, b)
+	}
+	return post(ctx, w.Client, w.URL, b, headers)
+}
+
+// HMAC-SHA256 of a webhook body, hex encoded (receivers compare it with
+// the SIGNATURE_HEADER value)
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Chat incoming webhook (Slack, Mattermost, Rocket.Chat...): {"text": ...}
+type Chat struct {
+	URL string
+	Client *http.Client
+}
+
+func (c *Chat) Name() string {
+	return "chat"
+}
+
+func (c *Chat) Send(ctx context.Context, n *Notification) error {
+	b, err := json.Marshal(map[string]string{"text": n.Text()})
+	if err != nil {
+		return errors.New("ERROR encoding chat message: " + err.Error())
+	}
+	return post(ctx, c.Client, c.URL, b, nil)
+}
+
+// Plain text email through an SMTP server (PLAIN auth if User is set)
+type Email struct {
+	// host:port of the SMTP server
+	Addr string
+	User string
+	Password string
+	From string
+	To []string
+}
+
+func (e *Email) Name() string {
+	return "email"
+}
+
+func (e *Email) Send(ctx context.Context, n *Notification) error {
+	var auth smtp.Auth
+	if e.User != "" {
+		host := e.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", e.User, e.Password, host)
+	}
+	msg := "From: " + e.From + "\r\n" +
+		"To: " + strings.Join(e.To, ", ") + "\r\n" +
+		"Subject: " + n.Summary() + "\r\n" +
+		"Date: " + n.Time.Format(time.RFC1123Z) + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n\r\n" +
+		strings.Replace(n.Text(), "\n", "\r\n", -1) + "\r\n"
+
+	// net/smtp has no context, so only stop waiting for it
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(e.Addr, auth, e.From, e.To, []byte(msg))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return errors.New("ERROR sending email: " + err.Error())
+		}
+		return nil
+	case <-ctx.Done():
+		return errors.New("ERROR sending email: " + ctx.Err().Error())
+	}
+}
+
+func post(ctx context.Context, client *http.Client, url string, body []byte, headers map[string]string) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return errors.New("ERROR creating request: " + err.Error())
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.New("ERROR posting to " + url + ": " + err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("ERROR posting to " + url + ": " + resp.Status)
+	}
+	return nil
+}
+
+// Sends the notifications to every channel, retrying the failed ones
+type Dispatcher struct {
+	Channels []Channel
+	// Events to send (all of them if empty)
+	Events map[string]bool
+	// Tries per channel, waiting Backoff, 2*Backoff, ... between them
+	Attempts int
+	Backoff time.Duration
+	// Called with the result of every delivery (optional)
+	Record func(d Delivery)
+}
+
+// Send the notification to every channel, and return the failed deliveries.
+// Blocks until all of them are done, so a command can notify and then exit
+func (d *Dispatcher) Notify(n Notification) []Delivery {
+	if d == nil || len(d.Channels) == 0 {
+		return nil
+	}
+	if len(d.Events) > 0 && !d.Events[n.Event] {
+		return nil
+	}
+	if n.Time.IsZero() {
+		n.Time = time.Now().UTC()
+	}
+
+	results := make(chan Delivery, len(d.Channels))
+	for _, c := range d.Channels {
+		go func(c Channel) {
+			results <- d.deliver(c, &n)
+		}(c)
+	}
+
+	var failed []Delivery
+	for range d.Channels {
+		r := <-results
+		if d.Record != nil {
+			d.Record(r)
+		}
+		if r.Status != DELIVERY_SENT {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+func (d *Dispatcher) deliver(c Channel, n *Notification) Delivery {
+	r := Delivery{Event: n.Event, Pool: n.Pool, Ledger: n.Ledger,
+		Channel: c.Name(), Status: DELIVERY_FAILED}
+	wait := d.Backoff
+	for r.Attempts < d.Attempts || r.Attempts == 0 {
+		if r.Attempts > 0 {
+			time.Sleep(wait)
+			wait *= 2
+		}
+		r.Attempts++
+
+		ctx, cancel := context.WithTimeout(context.Background(), SEND_TIMEOUT)
+		err := c.Send(ctx, n)
+		cancel()
+		if err == nil {
+			r.Status, r.Error = DELIVERY_SENT, ""
+			break
+		}
+		r.Error = err.Error()
+	}
+	r.Time = time.Now().UTC()
+	return r
+}
+
+// Notification options shared by the commands
+type Config struct {
+	Webhook string
+	WebhookSecret string
+	Chat string
+	SMTP string
+	SMTPUser string
+	SMTPPassword string
+	From string
+	// Comma separated lists
+	To string
+	Events string
+	Attempts int
+	Backoff time.Duration
+}
+
+// Define the notification flags in the flag set
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Webhook, "notify-webhook", "",
+		"URL to POST the notifications to, as JSON")
+
+	fs.StringVar(&c.WebhookSecret, "notify-webhook-secret", "",
+		"Secret to sign the webhook body with (HMAC-SHA256 in the " +
+		SIGNATURE_HEADER + " header). Prefer notify-webhook-secret_file " +
+		"in the config file or the <PREFIX>_NOTIFY_WEBHOOK_SECRET_FILE " +
+		"environment variable")
+
+	fs.StringVar(&c.Chat, "notify-chat", "",
+		"Chat incoming webhook URL (Slack, Mattermost...) to post the notifications to")
+
+	fs.StringVar(&c.SMTP, "notify-smtp", "",
+		"SMTP server (host:port) to email the notifications through")
+
+	fs.StringVar(&c.SMTPUser, "notify-smtp-user", "",
+		"SMTP user name (no authentication if empty)")
+
+	fs.StringVar(&c.SMTPPassword, "notify-smtp-pass", "",
+		"SMTP password. Prefer notify-smtp-pass_file in the config file or " +
+		"the <PREFIX>_NOTIFY_SMTP_PASS_FILE environment variable")
+
+	fs.StringVar(&c.From, "notify-from", "",
+		"Sender address of the notification emails")
+
+	fs.StringVar(&c.To, "notify-to", "",
+		"Comma separated recipients of the notification emails")
+
+	fs.StringVar(&c.Events, "notify-events", "",
+		"Comma separated events to notify (" + strings.Join(EVENTS, ", ") +
+		"). All of them if empty")
+
+	fs.IntVar(&c.Attempts, "notify-attempts", 3,
+		"Tries per notification channel before giving up")
+
+	fs.DurationVar(&c.Backoff, "notify-backoff", 2 * time.Second,
+		"Wait before the first retry (doubled after each one)")
+}
+
+// Check the options, returning the errors keyed by flag name
+func (c *Config) Check() map[string]error {
+	checks := map[string]error{}
+	if c.SMTP != "" {
+		if c.From == "" {
+			checks["notify-from"] = errors.New("ERROR: Sender needed to send emails")
+		}
+		if len(splitList(c.To)) == 0 {
+			checks["notify-to"] = errors.New("ERROR: Recipients needed to send emails")
+		}
+	}
+	for _, e := range splitList(c.Events) {
+		if !contains(EVENTS, e) {
+			checks["notify-events"] = errors.New("ERROR: Unknown event: " + e)
+		}
+	}
+	if c.Attempts < 1 {
+		checks["notify-attempts"] = errors.New("ERROR: At least 1 attempt is needed")
+	}
+	return checks
+}
+
+// Create the dispatcher for the configured channels (none is valid)
+func (c *Config) New() (*Dispatcher, error) {
+	for name, err := range c.Check() {
+		if err != nil {
+			return nil, errors.New("ERROR in " + name + ": " + err.Error())
+		}
+	}
+
+	client := &http.Client{Timeout: SEND_TIMEOUT}
+	d := &Dispatcher{Attempts: c.Attempts, Backoff: c.Backoff}
+	if c.Webhook != "" {
+		d.Channels = append(d.Channels, &Webhook{URL: c.Webhook,
+			Secret: c.WebhookSecret, Client: client})
+	}
+	if c.Chat != "" {
+		d.Channels = append(d.Channels, &Chat{URL: c.Chat, Client: client})
+	}
+	if c.SMTP != "" {
+		d.Channels = append(d.Channels, &Email{Addr: c.SMTP, User: c.SMTPUser,
+			Password: c.SMTPPassword, From: c.From, To: splitList(c.To)})
+	}
+	if events := splitList(c.Events); len(events) > 0 {
+		d.Events = make(map[string]bool)
+		for _, e := range events {
+			d.Events[e] = true
+		}
+	}
+	return d, nil
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,215 @@
+package notify
+
+import (
+	"sync"
+	"strings"
+	"testing"
+	"context"
+	"net/http"
+	"io/ioutil"
+	"encoding/json"
+	"net/http/httptest"
+)
+
+// HTTP receiver failing the first requests, recording the ones accepted
+type receiver struct {
+	*httptest.Server
+	mutex sync.Mutex
+	fail int
+	bodies [][]byte
+	headers []http.Header
+}
+
+func newReceiver(fail int) *receiver {
+	r := &receiver{fail: fail}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		if r.fail > 0 {
+			r.fail--
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		r.bodies = append(r.bodies, b)
+		r.headers = append(r.headers, req.Header)
+	}))
+	return r
+}
+
+var notification = Notification{
+	Event: EVENT_SNAPSHOT,
+	Pool: "GCCD6AJOYZCUAQLX32ZJF2MKFFAUJ53PVCFQI3RHWKL3V47QYE2BNAUT",
+	Ledger: 100,
+	Message: "Inflation snapshot saved",
+	Fields: map[string]string{"voters": "2", "hash": "abcd"},
+}
+
+func TestWebhook(t *testing.T) {
+	r := newReceiver(0)
+	defer r.Close()
+
+	w := &Webhook{URL: r.URL, Secret: "secret"}
+	if err := w.Send(context.Background(), &notification); err != nil {
+		t.Fatal(err)
+	}
+	var got Notification
+	if err := json.Unmarshal(r.bodies[0], &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Event != EVENT_SNAPSHOT || got.Ledger != 100 || got.Fields["hash"] != "abcd" {
+		t.Errorf("notification received %+v", got)
+	}
+	if r.headers[0].Get(EVENT_HEADER) != EVENT_SNAPSHOT {
+		t.Errorf("event header %q", r.headers[0].Get(EVENT_HEADER))
+	}
+	if sig := r.headers[0].Get(SIGNATURE_HEADER); sig != "sha256=" + Sign("secret", r.bodies[0]) {
+		t.Errorf("signature %q doesn't match the body", sig)
+	}
+}
+
+func TestChat(t *testing.T) {
+	r := newReceiver(0)
+	defer r.Close()
+
+	c := &Chat{URL: r.URL}
+	if err := c.Send(context.Background(), &notification); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]string
+	if err := json.Unmarshal(r.bodies[0], &got); err != nil {
+		t.Fatal(err)
+	}
+	want := "[snapshot_saved] Inflation snapshot saved (ledger 100)\npool: " +
+		notification.Pool + "\nhash: abcd\nvoters: 2"
+	if got["text"] != want {
+		t.Errorf("text %q, want %q", got["text"], want)
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	tests := []struct {
+		name string
+		// Requests failed by the receiver before accepting
+		fail int
+		events map[string]bool
+		attempts int
+		// Deliveries recorded, and the failed ones
+		recorded int
+		failed int
+		received int
+	}{
+		{name: "sent", attempts: 3, recorded: 1, received: 1},
+		{name: "sent after retrying", fail: 2, attempts: 3, recorded: 1, received: 1},
+		{name: "failed", fail: 3, attempts: 3, recorded: 1, failed: 1},
+		{name: "event filtered", events: map[string]bool{EVENT_FATAL: true}, attempts: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReceiver(tt.fail)
+			defer r.Close()
+
+			var deliveries []Delivery
+			d := &Dispatcher{Channels: []Channel{&Webhook{URL: r.URL}}, Events: tt.events,
+				Attempts: tt.attempts, Record: func(d Delivery) {
+					deliveries = append(deliveries, d)
+				}}
+			failed := d.Notify(notification)
+			if len(deliveries) != tt.recorded || len(failed) != tt.failed || len(r.bodies) != tt.received {
+				t.Fatalf("%d recorded, %d failed, %d received", len(deliveries), len(failed), len(r.bodies))
+			}
+			if tt.recorded == 0 {
+				return
+			}
+			want := DELIVERY_SENT
+			if tt.failed > 0 {
+				want = DELIVERY_FAILED
+			}
+			attempts := tt.fail + 1
+			if attempts > tt.attempts {
+				attempts = tt.attempts
+			}
+			if dl := deliveries[0]; dl.Status != want || dl.Attempts != attempts ||
+				dl.Ledger != 100 || dl.Channel != "webhook" {
+				t.Errorf("delivery %+v", dl)
+			}
+			if tt.failed > 0 && !strings.Contains(failed[0].Error, "503") {
+				t.Errorf("error %q", failed[0].Error)
+			}
+		})
+	}
+
+	// Without channels there is nothing to do
+	var none *Dispatcher
+	if failed := none.Notify(notification); failed != nil {
+		t.Error(failed)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		c Config
+		channels []string
+		// Options failing the check
+		errors []string
+	}{
+		{name: "nothing", c: Config{Attempts: 1}},
+		{
+			name: "every channel",
+			c: Config{Webhook: "http://hook", Chat: "http://chat", SMTP: "mail:25",
+				From: "pool@example.com", To: "a@example.com, b@example.com",
+				Events: "fatal_error, snapshot_saved", Attempts: 3},
+			channels: []string{"webhook", "chat", "email"},
+		},
+		{
+			name: "email without sender or recipients",
+			c: Config{SMTP: "mail:25", To: " , ", Attempts: 1},
+			errors: []string{"notify-from", "notify-to"},
+		},
+		{
+			name: "unknown event and no attempts",
+			c: Config{Events: "inflation", Attempts: 0},
+			errors: []string{"notify-events", "notify-attempts"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checks := tt.c.Check()
+			if len(checks) != len(tt.errors) {
+				t.Errorf("errors %v, want %v", checks, tt.errors)
+			}
+			for _, name := range tt.errors {
+				if checks[name] == nil {
+					t.Errorf("no error for %s", name)
+				}
+			}
+
+			d, err := tt.c.New()
+			if len(tt.errors) > 0 {
+				if err == nil {
+					t.Error("dispatcher created with errors")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, c := range d.Channels {
+				names = append(names, c.Name())
+			}
+			if strings.Join(names, ",") != strings.Join(tt.channels, ",") {
+				t.Errorf("channels %v, want %v", names, tt.channels)
+			}
+			if tt.c.Events != "" && (len(d.Events) != 2 || !d.Events[EVENT_FATAL]) {
+				t.Errorf("events %v", d.Events)
+			}
+			for _, c := range d.Channels {
+				if email, ok := c.(*Email); ok && len(email.To) != 2 {
+					t.Errorf("recipients %v", email.To)
+				}
+			}
+		})
+	}
+}
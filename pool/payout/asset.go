@@ -0,0 +1,336 @@
+package payout
+
+import (
+	"flag"
+	"errors"
+	"strings"
+	"math/big"
+	"net/http"
+	"encoding/json"
+	"github.com/stellar/go/clients/horizon"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+// How the voters are paid in a non-native asset
+const (
+	// The pool pays the asset it issues or holds
+	ASSET_DIRECT = "direct"
+	// The pool sends XLM, converted in the DEX with a path payment
+	ASSET_PATH = "path"
+)
+
+// Actions taken for each voter payment when paying in an asset
+const (
+	ACTION_ASSET = "asset"
+	ACTION_PATH = "path_payment"
+	ACTION_FALLBACK = "xlm_fallback"
+)
+
+// Asset types, as in the Horizon effects and balances
+const (
+	ASSET_NATIVE = "native"
+	ASSET_ALPHANUM4 = "credit_alphanum4"
+	ASSET_ALPHANUM12 = "credit_alphanum12"
+)
+
+// A Stellar asset, with the same fields the watcher reads in the effects
+// (the zero value is XLM). As a flag: "native" or "<code>:<issuer>"
+type Asset struct {
+	Type string `json:"asset_type"`
+	Code string `json:"asset_code,omitempty"`
+	Issuer string `json:"asset_issuer,omitempty"`
+}
+
+// Asset of the fields of a Horizon effect or balance
+func AssetOf(assetType, code, issuer string) Asset {
+	if assetType == "" || assetType == ASSET_NATIVE {
+		return Asset{Type: ASSET_NATIVE}
+	}
+	return Asset{Type: assetType, Code: code, Issuer: issuer}
+}
+
+func (a *Asset) Native() bool {
+	return a.Type == "" || a.Type == ASSET_NATIVE
+}
+
+func (a *Asset) String() string {
+	if a.Native() {
+		return ASSET_NATIVE
+	}
+	return a.Code + ":" + a.Issuer
+}
+
+func (a *Asset) Set(value string) error {
+	if value == "" || value == ASSET_NATIVE {
+		*a = Asset{Type: ASSET_NATIVE}
+		return nil
+	}
+	i := strings.Index(value, ":")
+	if i <= 0 || i > 12 {
+		return errors.New("ERROR: Invalid asset (native or <code>:<issuer>): " + value)
+	}
+	code, issuer := value[:i], value[i+1:]
+	if len(issuer) != 56 || issuer[0] != 'G' {
+		return errors.New("ERROR: Invalid asset issuer: " + issuer)
+	}
+	*a = Asset{Type: ASSET_ALPHANUM4, Code: code, Issuer: issuer}
+	if len(code) > 4 {
+		a.Type = ASSET_ALPHANUM12
+	}
+	return nil
+}
+
+// Tells how much of an asset each account can still receive (limit minus
+// balance of an authorized trustline). Accounts without one are left out
+type Trustlines interface {
+	Trustlines(asset Asset, accounts []string) (map[string]uint64, error)
+}
+
+// Settings of the payouts in a non-native asset. Amounts in the plan stay
+// in XLM, the asset amount of a payment is the XLM amount times the rate
+type AssetRules struct {
+	Asset Asset
+	// direct or path
+	Mode string
+	// Units of the asset per XLM, in stroops (1.0 is 10000000)
+	Rate uint64
+	// Part of each voter payout paid in the asset, in basis points (the
+	// rest is paid in XLM)
+	Share uint64
+	// XLM a path payment may send above the value of the asset amount at
+	// the rate, in basis points
+	Slippage uint64
+}
+
+// Define the asset flags in the flag set
+func (a *AssetRules) RegisterFlags(fs *flag.FlagSet) {
+	fs.Var(&a.Asset, "asset",
+		"Asset to pay the voters with: native or <code>:<issuer>")
+
+	fs.StringVar(&a.Mode, "asset-mode", ASSET_DIRECT,
+		"How to pay the asset: direct (the pool issues or holds it) or path " +
+		"(path payment from XLM, delivering the payout at -asset-rate)")
+
+	fs.Var((*amountValue)(&a.Rate), "asset-rate",
+		"Units of the asset paid for each XLM (with -asset-mode path, the expected " +
+		"price, taken from the best offer in the DEX if not set)")
+
+	fs.Uint64Var(&a.Share, "asset-share", BASIS_POINTS,
+		"Part of each voter payout paid in the asset, in basis points (the rest in XLM)")
+
+	fs.Uint64Var(&a.Slippage, "slippage", 100,
+		"XLM a path payment may send above the payout, in basis points, if the " +
+		"price is worse than -asset-rate (paid from the pool balance)")
+}
+
+// Check the asset settings
+func (a *AssetRules) Validate() error {
+	if a.Asset.Native() {
+		return nil
+	}
+	if a.Mode != ASSET_DIRECT && a.Mode != ASSET_PATH {
+		return errors.New("ERROR: Unknown asset mode: " + a.Mode)
+	}
+	// A path payment can take the rate from the DEX (see HorizonRate)
+	if a.Rate == 0 && a.Mode != ASSET_PATH {
+		return errors.New("ERROR: Paying in " + a.Asset.String() + " needs a rate (-asset-rate)")
+	}
+	if a.Share == 0 || a.Share > BASIS_POINTS || a.Slippage >= BASIS_POINTS {
+		return errors.New("ERROR: Invalid asset share or slippage")
+	}
+	return nil
+}
+
+// Reserve the operations of the voter payments in the rules, before
+// Calculate: a voter paid partly in the asset gets two payments
+func (a *AssetRules) Reserve(r *Rules) {
+	r.VoterOps = 1
+	if !a.Asset.Native() && a.Share < BASIS_POINTS {
+		r.VoterOps = 2
+	}
+}
+
+// Amount of the asset delivered for an amount in XLM, at the rate (rounded
+// down)
+func (a *AssetRules) Convert(amount uint64) uint64 {
+	v := new(big.Int).SetUint64(amount)
+	v.Mul(v, new(big.Int).SetUint64(a.Rate))
+	v.Div(v, big.NewInt(snapshot.STROOPS_PER_UNIT))
+	return v.Uint64()
+}
+
+// XLM sent at most by a path payment of an amount in XLM: the destination
+// amount is fixed at the rate, the slippage goes on what is sent
+func (a *AssetRules) SendMax(amount uint64) uint64 {
+	return share(amount, BASIS_POINTS + a.Slippage, BASIS_POINTS)
+}
+
+// Pay the voter payments (to existing accounts) in the asset. Voters
+// without an authorized trustline with room for the amount are paid in XLM
+// instead. Paying a part in the asset takes one more operation, reserved
+// by AssetRules.Reserve (it fails if the fees don't fit otherwise). Returns
+// the decision taken for every voter payment
+func (p *Plan) PayInAsset(t Trustlines, a *AssetRules, r *Rules) ([]Decision, error) {
+	if a.Asset.Native() {
+		return nil, nil
+	}
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+	if a.Rate == 0 {
+		return nil, errors.New("ERROR: No rate of " + a.Asset.String() + " to pay with")
+	}
+	r.defaults()
+	var accounts []string
+	for _, pay := range p.Payments {
+		if pay.Kind == PAYMENT_VOTER && !pay.Create {
+			accounts = append(accounts, pay.Destination)
+		}
+	}
+	room, err := t.Trustlines(a.Asset, accounts)
+	if err != nil {
+		return nil, err
+	}
+
+	var decisions []Decision
+	var payments []Payment
+	for _, pay := range p.Payments {
+		if pay.Kind != PAYMENT_VOTER || pay.Create {
+			payments = append(payments, pay)
+			continue
+		}
+		// The asset part, and the rest in XLM (if any)
+		part := new(big.Int).SetUint64(pay.Amount)
+		part.Mul(part, new(big.Int).SetUint64(a.Share))
+		part.Div(part, big.NewInt(BASIS_POINTS))
+		asset := Payment{Kind: pay.Kind, Account: pay.Account, Destination: pay.Destination,
+			Amount: part.Uint64(), Asset: &a.Asset, AssetAmount: a.Convert(part.Uint64())}
+		if a.Mode == ASSET_PATH {
+			asset.SendMax = a.SendMax(asset.Amount)
+		}
+
+		d := Decision{Payment: pay, Exists: true, Action: ACTION_ASSET}
+		left, trusted := room[pay.Destination]
+		switch {
+		case pay.Destination == a.Asset.Issuer:
+		case asset.AssetAmount == 0 || !trusted || left < asset.AssetAmount:
+			d.Action = ACTION_FALLBACK
+			payments = append(payments, pay)
+			decisions = append(decisions, d)
+			continue
+		}
+		if a.Mode == ASSET_PATH {
+			d.Action = ACTION_PATH
+		}
+		if trusted {
+			room[pay.Destination] = left - asset.AssetAmount
+		}
+		payments = append(payments, asset)
+		if rest := pay.Amount - asset.Amount; rest > 0 {
+			pay.Amount = rest
+			payments = append(payments, pay)
+		}
+		decisions = append(decisions, d)
+	}
+	// Left as it was if the payments don't fit
+	old := p.Payments
+	p.Payments = payments
+	if err = p.sum(r); err != nil {
+		p.Payments = old
+		p.sum(r)
+		return nil, err
+	}
+	p.Asset = &a.Asset
+	return decisions, nil
+}
+
+// Checks the trustlines with Horizon, one account at a time
+type HorizonTrustlines struct {
+	Client *horizon.Client
+}
+
+func (h *HorizonTrustlines) Trustlines(asset Asset, accounts []string) (map[string]uint64, error) {
+	room := make(map[string]uint64)
+	for _, account := range accounts {
+		req, err := http.NewRequest("GET", h.Client.URL + "/accounts/" + account, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := h.Client.HTTP.Do(req)
+		if err != nil {
+			return nil, errors.New("ERROR getting the trustlines of " + account + ": " + err.Error())
+		}
+		var acc struct {
+			Balances []struct {
+				AssetType string `json:"asset_type"`
+				AssetCode string `json:"asset_code"`
+				Issuer string `json:"asset_issuer"`
+				Balance string `json:"balance"`
+				Limit string `json:"limit"`
+				// Missing in older Horizon versions (then it's authorized)
+				Authorized *bool `json:"is_authorized"`
+			} `json:"balances"`
+		}
+		switch resp.StatusCode {
+		case http.StatusOK:
+			err = json.NewDecoder(resp.Body).Decode(&acc)
+		case http.StatusNotFound:
+		default:
+			err = errors.New(resp.Status)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.New("ERROR getting the trustlines of " + account + ": " + err.Error())
+		}
+
+		for _, b := range acc.Balances {
+			if AssetOf(b.AssetType, b.AssetCode, b.Issuer) != asset ||
+				(b.Authorized != nil && !*b.Authorized) {
+				continue
+			}
+			balance, err := snapshot.ParseAmount(b.Balance)
+			if err != nil {
+				return nil, err
+			}
+			limit, err := snapshot.ParseAmount(b.Limit)
+			if err != nil {
+				return nil, err
+			}
+			if limit > balance {
+				room[account] = limit - balance
+			}
+		}
+	}
+	return room, nil
+}
+
+// Units of the asset paid for one XLM by the best offer in the DEX (the
+// best bid of the native/asset order book), in stroops
+func HorizonRate(client *horizon.Client, asset Asset) (uint64, error) {
+	url := client.URL + "/order_book?selling_asset_type=native&buying_asset_type=" +
+		asset.Type + "&buying_asset_code=" + asset.Code + "&buying_asset_issuer=" + asset.Issuer
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.HTTP.Do(req)
+	if err != nil {
+		return 0, errors.New("ERROR getting the order book: " + err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.New("ERROR getting the order book: " + resp.Status)
+	}
+	var book struct {
+		Bids []struct {
+			Price string `json:"price"`
+		} `json:"bids"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&book); err != nil {
+		return 0, errors.New("ERROR decoding the order book: " + err.Error())
+	}
+	if len(book.Bids) == 0 {
+		return 0, errors.New("ERROR: No offers for " + asset.String() + " in the order book")
+	}
+	return snapshot.ParseAmount(book.Bids[0].Price)
+}
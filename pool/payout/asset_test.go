@@ -0,0 +1,303 @@
+package payout
+
+import (
+	"testing"
+	"github.com/matheusb-comp/go/pool/fixtures"
+)
+
+var usd = Asset{Type: ASSET_ALPHANUM4, Code: "USD", Issuer: fixtures.Address(20)}
+
+// Balance of a USD trustline, as in the Horizon account page (nil
+// authorized is an older Horizon, without the field)
+func trustline(balance, limit string, authorized *bool) map[string]interface{} {
+	b := map[string]interface{}{
+		"asset_type": usd.Type,
+		"asset_code": usd.Code,
+		"asset_issuer": usd.Issuer,
+		"balance": balance,
+		"limit": limit,
+	}
+	if authorized != nil {
+		b["is_authorized"] = *authorized
+	}
+	return b
+}
+
+func TestPayInAsset(t *testing.T) {
+	yes, no := true, false
+	native := map[string]interface{}{"asset_type": "native", "balance": "100.0000000"}
+	direct := AssetRules{Asset: usd, Mode: ASSET_DIRECT, Rate: 20000000, Share: BASIS_POINTS}
+	half := direct
+	half.Share = BASIS_POINTS / 2
+	path := direct
+	path.Mode, path.Slippage = ASSET_PATH, 100
+
+	voterPay := Payment{Kind: PAYMENT_VOTER, Account: voterA, Destination: voterA, Amount: 10000000}
+	tests := []struct {
+		name string
+		asset AssetRules
+		// Balances of voterA in Horizon (no page if nil)
+		balances []interface{}
+		payments []Payment
+		credit uint64
+		actions []string
+		want []Payment
+		slippage uint64
+		err bool
+	}{
+		{
+			name: "trustline with room",
+			asset: direct,
+			balances: []interface{}{native, trustline("0.0000000", "1000.0000000", &yes)},
+			payments: []Payment{voterPay},
+			actions: []string{ACTION_ASSET},
+			want: []Payment{
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 10000000, AssetAmount: 20000000},
+			},
+		},
+		{
+			name: "older Horizon without authorization",
+			asset: direct,
+			balances: []interface{}{trustline("0.0000000", "1000.0000000", nil)},
+			payments: []Payment{voterPay},
+			actions: []string{ACTION_ASSET},
+			want: []Payment{
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 10000000, AssetAmount: 20000000},
+			},
+		},
+		{
+			name: "no trustline",
+			asset: direct,
+			balances: []interface{}{native},
+			payments: []Payment{voterPay},
+			actions: []string{ACTION_FALLBACK},
+			want: []Payment{{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 10000000}},
+		},
+		{
+			name: "trustline without room",
+			asset: direct,
+			balances: []interface{}{trustline("9.5000000", "10.0000000", &yes)},
+			payments: []Payment{voterPay},
+			actions: []string{ACTION_FALLBACK},
+			want: []Payment{{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 10000000}},
+		},
+		{
+			name: "trustline not authorized",
+			asset: direct,
+			balances: []interface{}{trustline("0.0000000", "1000.0000000", &no)},
+			payments: []Payment{voterPay},
+			actions: []string{ACTION_FALLBACK},
+			want: []Payment{{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 10000000}},
+		},
+		{
+			name: "half in the asset",
+			asset: half,
+			balances: []interface{}{trustline("0.0000000", "1000.0000000", &yes)},
+			payments: []Payment{voterPay},
+			actions: []string{ACTION_ASSET},
+			want: []Payment{
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 5000000, AssetAmount: 10000000},
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 5000000},
+			},
+		},
+		{
+			name: "path payment",
+			asset: path,
+			balances: []interface{}{trustline("0.0000000", "1000.0000000", &yes)},
+			payments: []Payment{voterPay},
+			actions: []string{ACTION_PATH},
+			want: []Payment{
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 10000000, AssetAmount: 20000000,
+					SendMax: 10100000},
+			},
+			slippage: 100000,
+		},
+		{
+			name: "issuer without trustline",
+			asset: direct,
+			payments: []Payment{{Kind: PAYMENT_VOTER, Account: usd.Issuer,
+				Destination: usd.Issuer, Amount: 10000000}},
+			actions: []string{ACTION_ASSET},
+			want: []Payment{
+				{Kind: PAYMENT_VOTER, Destination: usd.Issuer, Amount: 10000000, AssetAmount: 20000000},
+			},
+		},
+		{
+			name: "only voters in the asset",
+			asset: direct,
+			balances: []interface{}{trustline("0.0000000", "1000.0000000", &yes)},
+			payments: []Payment{
+				{Kind: PAYMENT_DONATION, Destination: charity, Amount: 500, Donors: 1},
+				{Kind: PAYMENT_VOTER, Account: voterB, Destination: voterB, Amount: 20000000, Create: true},
+				voterPay,
+			},
+			actions: []string{ACTION_ASSET},
+			want: []Payment{
+				{Kind: PAYMENT_DONATION, Destination: charity, Amount: 500},
+				{Kind: PAYMENT_VOTER, Destination: voterB, Amount: 20000000, Create: true},
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 10000000, AssetAmount: 20000000},
+			},
+		},
+		{
+			name: "native",
+			asset: AssetRules{Asset: Asset{Type: ASSET_NATIVE}},
+			payments: []Payment{voterPay},
+			want: []Payment{{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 10000000}},
+		},
+		{
+			name: "fees of the split don't fit",
+			asset: half,
+			balances: []interface{}{trustline("0.0000000", "1000.0000000", &yes)},
+			payments: []Payment{voterPay},
+			credit: 10000100,
+			want: []Payment{{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 10000000}},
+			err: true,
+		},
+		{
+			name: "no rate",
+			asset: AssetRules{Asset: usd, Mode: ASSET_DIRECT, Share: BASIS_POINTS},
+			payments: []Payment{voterPay},
+			want: []Payment{{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 10000000}},
+			err: true,
+		},
+		{
+			name: "path payment without the DEX rate",
+			asset: AssetRules{Asset: usd, Mode: ASSET_PATH, Share: BASIS_POINTS},
+			payments: []Payment{voterPay},
+			want: []Payment{{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 10000000}},
+			err: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := fixtures.NewHorizon()
+			defer h.Close()
+			if tt.balances != nil {
+				err := h.AddPage("/accounts/" + voterA, map[string]interface{}{
+					"id": voterA,
+					"balances": tt.balances,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			credit := tt.credit
+			if credit == 0 {
+				credit = 1000000000
+			}
+			p := &Plan{Pool: pool, Credit: credit, Payments: tt.payments}
+			r := Rules{}
+			decisions, err := p.PayInAsset(&HorizonTrustlines{Client: h.Client()}, &tt.asset, &r)
+			checkPayments(t, p.Payments, tt.want)
+			if tt.err {
+				if err == nil {
+					t.Fatal("no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(decisions) != len(tt.actions) {
+				t.Fatalf("%d decisions, want %d", len(decisions), len(tt.actions))
+			}
+			for i, d := range decisions {
+				if d.Action != tt.actions[i] {
+					t.Errorf("decision %d: %s, want %s", i, d.Action, tt.actions[i])
+				}
+			}
+			if p.Slippage != tt.slippage {
+				t.Errorf("slippage %d, want %d", p.Slippage, tt.slippage)
+			}
+			// Paying in XLM leaves the plan alone
+			if !tt.asset.Asset.Native() {
+				checkBalanced(t, p)
+			}
+		})
+	}
+}
+
+func TestAssetReserve(t *testing.T) {
+	tests := []struct {
+		name string
+		asset AssetRules
+		ops int
+	}{
+		{"native", AssetRules{}, 1},
+		{"all in the asset", AssetRules{Asset: usd, Share: BASIS_POINTS}, 1},
+		{"part in the asset", AssetRules{Asset: usd, Share: BASIS_POINTS / 2}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Rules{VoterOps: 5}
+			tt.asset.Reserve(&r)
+			if r.VoterOps != tt.ops {
+				t.Errorf("%d operations per voter, want %d", r.VoterOps, tt.ops)
+			}
+		})
+	}
+}
+
+func TestAssetValidate(t *testing.T) {
+	direct := AssetRules{Asset: usd, Mode: ASSET_DIRECT, Rate: 20000000, Share: BASIS_POINTS}
+	tests := []struct {
+		name string
+		change func(a *AssetRules)
+		ok bool
+	}{
+		{"direct", func(a *AssetRules) {}, true},
+		{"native", func(a *AssetRules) { *a = AssetRules{} }, true},
+		{"direct without a rate", func(a *AssetRules) { a.Rate = 0 }, false},
+		{"path with a rate", func(a *AssetRules) { a.Mode = ASSET_PATH }, true},
+		// Taken from the order book when planning
+		{"path without a rate", func(a *AssetRules) { a.Mode, a.Rate = ASSET_PATH, 0 }, true},
+		{"unknown mode", func(a *AssetRules) { a.Mode = "swap" }, false},
+		{"no share", func(a *AssetRules) { a.Share = 0 }, false},
+		{"share above 100%", func(a *AssetRules) { a.Share = BASIS_POINTS + 1 }, false},
+		{"slippage of 100%", func(a *AssetRules) { a.Slippage = BASIS_POINTS }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := direct
+			tt.change(&a)
+			if err := a.Validate(); (err == nil) != tt.ok {
+				t.Errorf("Validate: %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestHorizonRate(t *testing.T) {
+	tests := []struct {
+		name string
+		// Order book page (none if nil)
+		bids []interface{}
+		want uint64
+		ok bool
+	}{
+		{"best bid", []interface{}{map[string]string{"price": "2.5000000"},
+			map[string]string{"price": "2.4000000"}}, 25000000, true},
+		{"no offers", []interface{}{}, 0, false},
+		{"no order book", nil, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := fixtures.NewHorizon()
+			defer h.Close()
+			if tt.bids != nil {
+				err := h.AddPage("/order_book", map[string]interface{}{"bids": tt.bids})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			rate, err := HorizonRate(h.Client(), usd)
+			if (err == nil) != tt.ok {
+				t.Fatalf("HorizonRate: %v, want ok %v", err, tt.ok)
+			}
+			if rate != tt.want {
+				t.Errorf("rate %d, want %d", rate, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package payout
+
+import (
+	"errors"
+	"net/http"
+	"github.com/stellar/go/clients/horizon"
+)
+
+// Reason for a payment routed to the carry-over
+const REASON_NO_DESTINATION = "destination missing (carried over)"
+
+// Actions taken for each checked destination
+const (
+	ACTION_PAY = "pay"
+	ACTION_CREATE = "create_account"
+	ACTION_CARRY = "carry_over"
+)
+
+// Minimum starting balance to create an account: 2 base reserves of 0.5 XLM
+const DEFAULT_MIN_CREATE = 10000000
+
+// Tells which of the accounts exist right now (the core DB or Horizon)
+type Checker interface {
+	Existing(accounts []string) (map[string]bool, error)
+}
+
+// What was done with a payment after checking its destination
+type Decision struct {
+	Payment Payment `json:"payment"`
+	Exists bool `json:"exists"`
+	Action string `json:"action"`
+}
+
+// Check every destination right before building the transactions, since
+// a single missing account (merged after the snapshot) fails the whole
+// transaction with op_no_destination. A missing account is created if the
+// sum of the payments to it covers MinCreate: they're merged into a single
+// create_account, in the place of the first one. Otherwise they're carried
+// over (on the payout too, for a voter). Donations are never made to a
+// missing account (a misconfigured or merged target), they're carried over
+// until it exists.
+// Returns the decision taken for every payment, so it can be logged
+func (p *Plan) CheckDestinations(c Checker, r *Rules) ([]Decision, error) {
+	r.defaults()
+	var accounts []string
+	seen := map[string]bool{}
+	for _, pay := range p.Payments {
+		if !seen[pay.Destination] {
+			seen[pay.Destination] = true
+			accounts = append(accounts, pay.Destination)
+		}
+	}
+	existing, err := c.Existing(accounts)
+	if err != nil {
+		return nil, err
+	}
+
+	// What could create each missing account
+	missing := map[string]uint64{}
+	for _, pay := range p.Payments {
+		if !existing[pay.Destination] && pay.Kind != PAYMENT_DONATION {
+			missing[pay.Destination] += pay.Amount
+		}
+	}
+
+	var decisions []Decision
+	var payments []Payment
+	created := map[string]int{}
+	for _, pay := range p.Payments {
+		d := Decision{Payment: pay, Exists: existing[pay.Destination], Action: ACTION_PAY}
+		switch {
+		case d.Exists:
+			payments = append(payments, pay)
+		case pay.Kind != PAYMENT_DONATION && missing[pay.Destination] >= r.MinCreate:
+			d.Action = ACTION_CREATE
+			if i, ok := created[pay.Destination]; ok {
+				payments[i].Amount += pay.Amount
+				break
+			}
+			pay.Create = true
+			created[pay.Destination] = len(payments)
+			payments = append(payments, pay)
+		default:
+			p.CarriedOut += pay.Amount
+			p.Exclusions = append(p.Exclusions, Exclusion{Account: pay.Destination,
+				Reason: REASON_NO_DESTINATION, Carried: pay.Amount, Kind: pay.Kind})
+			if pay.Kind == PAYMENT_VOTER {
+				for i := range p.Payouts {
+					if p.Payouts[i].Account == pay.Account {
+						p.Payouts[i].Carried += pay.Amount
+					}
+				}
+			}
+			d.Action = ACTION_CARRY
+		}
+		decisions = append(decisions, d)
+	}
+	p.Payments = payments
+	if err = p.sum(r); err != nil {
+		return nil, err
+	}
+	return decisions, nil
+}
+
+// Checks the accounts with Horizon, one request each
+type HorizonChecker struct {
+	Client *horizon.Client
+}
+
+func (h *HorizonChecker) Existing(accounts []string) (map[string]bool, error) {
+	existing := make(map[string]bool)
+	for _, account := range accounts {
+		req, err := http.NewRequest("GET", h.Client.URL + "/accounts/" + account, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := h.Client.HTTP.Do(req)
+		if err != nil {
+			return nil, errors.New("ERROR checking account " + account + ": " + err.Error())
+		}
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK:
+			existing[account] = true
+		case http.StatusNotFound:
+		default:
+			return nil, errors.New("ERROR checking account " + account + ": " + resp.Status)
+		}
+	}
+	return existing, nil
+}
@@ -0,0 +1,183 @@
+package payout
+
+import (
+	"testing"
+	"github.com/matheusb-comp/go/pool/fixtures"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+// Serve the accounts in the fake Horizon (the others are missing)
+func addAccounts(t *testing.T, h *fixtures.Horizon, accounts ...string) {
+	for _, account := range accounts {
+		if err := h.AddPage("/accounts/" + account, map[string]interface{}{
+			"id": account,
+			"balances": []interface{}{},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCheckDestinations(t *testing.T) {
+	tests := []struct {
+		name string
+		existing []string
+		rules Rules
+		payments []Payment
+		actions []string
+		want []Payment
+		carriedOut uint64
+		// Carried on the payout of each voter
+		carried map[string]uint64
+	}{
+		{
+			name: "all exist",
+			existing: []string{voterA, voterB, charity},
+			payments: []Payment{
+				{Kind: PAYMENT_VOTER, Account: voterA, Destination: voterA, Amount: 100},
+				{Kind: PAYMENT_VOTER, Account: voterB, Destination: voterB, Amount: 200},
+				{Kind: PAYMENT_DONATION, Destination: charity, Amount: 50, Donors: 2},
+			},
+			actions: []string{ACTION_PAY, ACTION_PAY, ACTION_PAY},
+			want: []Payment{
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 100},
+				{Kind: PAYMENT_VOTER, Destination: voterB, Amount: 200},
+				{Kind: PAYMENT_DONATION, Destination: charity, Amount: 50},
+			},
+		},
+		{
+			name: "voter created",
+			existing: []string{voterB},
+			payments: []Payment{
+				{Kind: PAYMENT_VOTER, Account: voterA, Destination: voterA, Amount: 20000000},
+				{Kind: PAYMENT_VOTER, Account: voterB, Destination: voterB, Amount: 200},
+			},
+			actions: []string{ACTION_CREATE, ACTION_PAY},
+			want: []Payment{
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 20000000, Create: true},
+				{Kind: PAYMENT_VOTER, Destination: voterB, Amount: 200},
+			},
+		},
+		{
+			name: "voter carried over",
+			existing: []string{voterB},
+			payments: []Payment{
+				{Kind: PAYMENT_VOTER, Account: voterA, Destination: voterA, Amount: 100},
+				{Kind: PAYMENT_VOTER, Account: voterB, Destination: voterB, Amount: 200},
+			},
+			actions: []string{ACTION_CARRY, ACTION_PAY},
+			want: []Payment{
+				{Kind: PAYMENT_VOTER, Destination: voterB, Amount: 200},
+			},
+			carriedOut: 100,
+			carried: map[string]uint64{voterA: 100},
+		},
+		{
+			name: "donation target never created",
+			existing: []string{voterA},
+			payments: []Payment{
+				{Kind: PAYMENT_VOTER, Account: voterA, Destination: voterA, Amount: 100},
+				{Kind: PAYMENT_DONATION, Destination: charity, Amount: 50000000, Donors: 1},
+			},
+			actions: []string{ACTION_PAY, ACTION_CARRY},
+			want: []Payment{
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 100},
+			},
+			carriedOut: 50000000,
+		},
+		{
+			name: "owed donation to a target still missing",
+			existing: []string{voterA},
+			payments: []Payment{
+				{Kind: PAYMENT_VOTER, Account: voterA, Destination: voterA, Amount: 100},
+				{Kind: PAYMENT_DONATION, Destination: charity, Amount: 50000000},
+			},
+			actions: []string{ACTION_PAY, ACTION_CARRY},
+			want: []Payment{
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 100},
+			},
+			carriedOut: 50000000,
+		},
+		{
+			name: "payments merged to create",
+			existing: []string{voterA},
+			payments: []Payment{
+				{Kind: PAYMENT_POOL_FEE, Destination: feeAccount, Amount: 6000000},
+				{Kind: PAYMENT_VOTER, Account: voterA, Destination: voterA, Amount: 100},
+				{Kind: PAYMENT_OWED, Account: feeAccount, Destination: feeAccount, Amount: 6000000},
+			},
+			actions: []string{ACTION_CREATE, ACTION_PAY, ACTION_CREATE},
+			want: []Payment{
+				{Kind: PAYMENT_POOL_FEE, Destination: feeAccount, Amount: 12000000, Create: true},
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 100},
+			},
+		},
+		{
+			name: "payments below the minimum together",
+			existing: []string{voterA},
+			payments: []Payment{
+				{Kind: PAYMENT_POOL_FEE, Destination: feeAccount, Amount: 3000000},
+				{Kind: PAYMENT_VOTER, Account: voterA, Destination: voterA, Amount: 100},
+				{Kind: PAYMENT_OWED, Account: feeAccount, Destination: feeAccount, Amount: 3000000},
+			},
+			actions: []string{ACTION_CARRY, ACTION_PAY, ACTION_CARRY},
+			want: []Payment{
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 100},
+			},
+			carriedOut: 6000000,
+		},
+		{
+			name: "custom minimum to create",
+			rules: Rules{MinCreate: 100},
+			payments: []Payment{
+				{Kind: PAYMENT_VOTER, Account: voterA, Destination: voterA, Amount: 100},
+				{Kind: PAYMENT_VOTER, Account: voterB, Destination: voterB, Amount: 99},
+			},
+			actions: []string{ACTION_CREATE, ACTION_CARRY},
+			want: []Payment{
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 100, Create: true},
+			},
+			carriedOut: 99,
+			carried: map[string]uint64{voterB: 99},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := fixtures.NewHorizon()
+			defer h.Close()
+			addAccounts(t, h, tt.existing...)
+
+			p := &Plan{Pool: pool, Credit: 1000000000, Payments: tt.payments,
+				Payouts: []snapshot.Payout{{Account: voterA}, {Account: voterB}}}
+			decisions, err := p.CheckDestinations(&HorizonChecker{Client: h.Client()}, &tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(decisions) != len(tt.actions) {
+				t.Fatalf("%d decisions, want %d", len(decisions), len(tt.actions))
+			}
+			for i, d := range decisions {
+				if d.Action != tt.actions[i] {
+					t.Errorf("decision %d (%s): %s, want %s", i, d.Payment.Destination,
+						d.Action, tt.actions[i])
+				}
+			}
+			checkPayments(t, p.Payments, tt.want)
+			checkBalanced(t, p)
+
+			if p.CarriedOut != tt.carriedOut {
+				t.Errorf("carried out %d, want %d", p.CarriedOut, tt.carriedOut)
+			}
+			for _, po := range p.Payouts {
+				if po.Carried != tt.carried[po.Account] {
+					t.Errorf("%s: carried %d, want %d", po.Account, po.Carried, tt.carried[po.Account])
+				}
+			}
+			for _, ex := range p.Exclusions {
+				if ex.Reason != REASON_NO_DESTINATION || ex.Kind == "" {
+					t.Errorf("%s excluded for %q (kind %q)", ex.Account, ex.Reason, ex.Kind)
+				}
+			}
+		})
+	}
+}
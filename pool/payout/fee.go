@@ -0,0 +1,217 @@
+package payout
+
+import (
+	"flag"
+	"sort"
+	"errors"
+	"strconv"
+	"net/http"
+	"encoding/json"
+	"github.com/stellar/go/clients/horizon"
+)
+
+// Percentiles of the fees reported by Horizon (/fee_stats)
+var FEE_PERCENTILES = []int{10, 20, 30, 40, 50, 60, 70, 80, 90, 95, 99}
+
+// Sources of the fee statistics
+const (
+	FEE_FIXED = "fixed"
+	FEE_HORIZON = "horizon"
+	FEE_CORE = "core"
+)
+
+// Fees of the recent ledgers, per operation in stroops
+type FeeStats struct {
+	LastBaseFee uint64 `json:"last_ledger_base_fee"`
+	// Fee by percentile (charged, or accepted in older Horizon versions),
+	// and the most common one
+	Percentiles map[int]uint64 `json:"percentiles"`
+	Mode uint64 `json:"mode"`
+}
+
+// Where the fee statistics come from (Horizon or the core DB)
+type FeeSource interface {
+	FeeStats() (*FeeStats, error)
+}
+
+// Picks the fee per operation of the payout transactions: the accepted fee
+// of a percentile of the recent transactions (never below the base fee),
+// capped at the ceiling. Without a source, the fee of the rules is kept
+type FeeStrategy struct {
+	// fixed, horizon or core (the caller sets the Source from it)
+	Name string
+	Source FeeSource
+	// Percentile to pay (0 is the most common fee)
+	Percentile int
+	// Maximum fee per operation, in stroops (0 is no limit)
+	Ceiling uint64
+	// Ledgers to look at in the core DB
+	Ledgers int
+}
+
+// Define the fee flags in the flag set
+func (f *FeeStrategy) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&f.Name, "fee-source", FEE_FIXED,
+		"Where to get the recent fees from: fixed (-base-fee), horizon (/fee_stats) or core (txhistory)")
+
+	fs.IntVar(&f.Percentile, "fee-percentile", 90,
+		"Percentile of the recently accepted fees to pay (0 is the most common fee)")
+
+	fs.Uint64Var(&f.Ceiling, "max-fee", 10000,
+		"Maximum fee per operation, in stroops, even during surge pricing (0 is no limit)")
+
+	fs.IntVar(&f.Ledgers, "fee-ledgers", 5,
+		"Recent ledgers to get the fees from, with -fee-source core")
+}
+
+// Set the fee per operation of the rules, and return the statistics it
+// came from (nil with a fixed fee)
+func (f *FeeStrategy) Apply(r *Rules) (*FeeStats, error) {
+	r.defaults()
+	if f.Source == nil {
+		r.BaseFee = f.capped(r.BaseFee)
+		return nil, nil
+	}
+	stats, err := f.Source.FeeStats()
+	if err != nil {
+		return nil, err
+	}
+
+	fee := stats.Mode
+	if f.Percentile > 0 {
+		// The first reported percentile at or above the one asked
+		for _, p := range FEE_PERCENTILES {
+			if p >= f.Percentile {
+				fee = stats.Percentiles[p]
+				break
+			}
+		}
+	}
+	if fee < stats.LastBaseFee {
+		fee = stats.LastBaseFee
+	}
+	r.BaseFee = f.capped(fee)
+	return stats, nil
+}
+
+func (f *FeeStrategy) capped(fee uint64) uint64 {
+	if fee == 0 {
+		fee = DEFAULT_BASE_FEE
+	}
+	if f.Ceiling > 0 && fee > f.Ceiling {
+		fee = f.Ceiling
+	}
+	return fee
+}
+
+// Fee statistics from Horizon
+type HorizonFees struct {
+	Client *horizon.Client
+}
+
+func (h *HorizonFees) FeeStats() (*FeeStats, error) {
+	req, err := http.NewRequest("GET", h.Client.URL + "/fee_stats", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.Client.HTTP.Do(req)
+	if err != nil {
+		return nil, errors.New("ERROR getting fee stats: " + err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("ERROR getting fee stats: " + resp.Status)
+	}
+
+	// The values are strings, like the amounts. Current versions report
+	// the fees charged in the last ledgers in an object, older ones had
+	// the accepted fees at the top level
+	var raw map[string]interface{}
+	if err = json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, errors.New("ERROR decoding fee stats: " + err.Error())
+	}
+	fields, suffix := raw, "_accepted_fee"
+	if charged, ok := raw["fee_charged"].(map[string]interface{}); ok {
+		fields, suffix = charged, ""
+	}
+	get := func(values map[string]interface{}, name string) (uint64, error) {
+		v, err := strconv.ParseUint(statString(values[name]), 10, 64)
+		if err != nil {
+			return 0, errors.New("ERROR: Missing or invalid " + name + " in fee stats")
+		}
+		return v, nil
+	}
+	stats := &FeeStats{Percentiles: make(map[int]uint64)}
+	if stats.LastBaseFee, err = get(raw, "last_ledger_base_fee"); err != nil {
+		return nil, err
+	}
+	if stats.Mode, err = get(fields, "mode" + suffix); err != nil {
+		return nil, err
+	}
+	for _, p := range FEE_PERCENTILES {
+		if stats.Percentiles[p], err = get(fields, "p" + strconv.Itoa(p) + suffix); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+func statString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	}
+	return ""
+}
+
+// Fee statistics from the transactions of the recent ledgers in the core DB
+type CoreFees struct {
+	Conn interface {
+		RecentFees(ledgers int) ([]uint64, error)
+	}
+	// How many ledgers to look at
+	Ledgers int
+}
+
+func (c *CoreFees) FeeStats() (*FeeStats, error) {
+	fees, err := c.Conn.RecentFees(c.Ledgers)
+	if err != nil {
+		return nil, err
+	}
+	return StatsFromFees(fees), nil
+}
+
+// Percentiles and mode of the fees per operation of some transactions (the
+// base fee is the smallest one, since the core DB doesn't have it apart)
+func StatsFromFees(fees []uint64) *FeeStats {
+	stats := &FeeStats{Percentiles: make(map[int]uint64)}
+	if len(fees) == 0 {
+		stats.LastBaseFee = DEFAULT_BASE_FEE
+		return stats
+	}
+	sorted := append([]uint64(nil), fees...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	stats.LastBaseFee = sorted[0]
+	for _, p := range FEE_PERCENTILES {
+		i := (len(sorted) * p + 99) / 100 - 1
+		if i < 0 {
+			i = 0
+		}
+		stats.Percentiles[p] = sorted[i]
+	}
+	count, best := 0, 0
+	for i := range sorted {
+		if i > 0 && sorted[i] == sorted[i-1] {
+			count++
+		} else {
+			count = 1
+		}
+		if count > best {
+			best, stats.Mode = count, sorted[i]
+		}
+	}
+	return stats
+}
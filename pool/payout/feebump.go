@@ -0,0 +1,101 @@
+package payout
+
+import (
+	"bytes"
+	"errors"
+	"encoding/hex"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/stellar/go/xdr"
+	"github.com/stellar/go/strkey"
+	"github.com/stellar/go/keypair"
+)
+
+// Envelope types (CAP-15). A legacy envelope has the same bytes as a
+// ENVELOPE_TYPE_TX one without the type, since the source account starts
+// with the key type (0)
+const (
+	ENVELOPE_TYPE_TX = 2
+	ENVELOPE_TYPE_TX_FEE_BUMP = 5
+)
+
+// Wrap a signed payout transaction stuck on a low fee in a fee-bump
+// envelope, paid and signed by another account (so the pool balance and
+// sequence number are left alone). The fee per operation must be at least
+// the one of the inner transaction, and the outer fee counts the fee-bump
+// as one more operation. Returns the base64 envelope and its hash
+func FeeBump(envelope, feeSeed, passphrase string, baseFee uint64) (string, string, error) {
+	raw, err := base64.StdEncoding.DecodeString(envelope)
+	if err != nil || len(raw) < 4 {
+		return "", "", errors.New("ERROR: Invalid transaction envelope")
+	}
+	switch binary.BigEndian.Uint32(raw) {
+	case ENVELOPE_TYPE_TX:
+		raw = raw[4:]
+	case ENVELOPE_TYPE_TX_FEE_BUMP:
+		return "", "", errors.New("ERROR: The transaction is already a fee-bump")
+	}
+
+	// The inner transaction, to check its fee and signatures
+	var inner xdr.TransactionEnvelope
+	err = xdr.SafeUnmarshalBase64(base64.StdEncoding.EncodeToString(raw), &inner)
+	if err != nil {
+		return "", "", errors.New("ERROR decoding the transaction: " + err.Error())
+	}
+	ops := uint64(len(inner.Tx.Operations))
+	if len(inner.Signatures) == 0 {
+		return "", "", errors.New("ERROR: The transaction isn't signed")
+	}
+	if ops == 0 || baseFee * ops < uint64(inner.Tx.Fee) {
+		return "", "", errors.New("ERROR: The fee-bump must pay at least the fee of the transaction")
+	}
+
+	kp, err := keypair.Parse(feeSeed)
+	if err != nil {
+		return "", "", errors.New("ERROR parsing the fee account seed: " + err.Error())
+	}
+	full, ok := kp.(*keypair.Full)
+	if !ok {
+		return "", "", errors.New("ERROR: The fee account needs a secret seed")
+	}
+	key, err := strkey.Decode(strkey.VersionByteAccountID, full.Address())
+	if err != nil {
+		return "", "", errors.New("ERROR decoding the fee account: " + err.Error())
+	}
+
+	// FeeBumpTransaction: fee source (MuxedAccount ed25519), fee, inner
+	// envelope (ENVELOPE_TYPE_TX) and the empty extension
+	var tx bytes.Buffer
+	binary.Write(&tx, binary.BigEndian, uint32(0))
+	tx.Write(key)
+	binary.Write(&tx, binary.BigEndian, int64(baseFee * (ops + 1)))
+	binary.Write(&tx, binary.BigEndian, uint32(ENVELOPE_TYPE_TX))
+	tx.Write(raw)
+	binary.Write(&tx, binary.BigEndian, uint32(0))
+
+	// Signed over the network ID, the envelope type and the transaction
+	var payload bytes.Buffer
+	network := sha256.Sum256([]byte(passphrase))
+	payload.Write(network[:])
+	binary.Write(&payload, binary.BigEndian, uint32(ENVELOPE_TYPE_TX_FEE_BUMP))
+	payload.Write(tx.Bytes())
+	hash := sha256.Sum256(payload.Bytes())
+	sig, err := full.Sign(hash[:])
+	if err != nil {
+		return "", "", errors.New("ERROR signing the fee-bump: " + err.Error())
+	}
+
+	// Envelope: type, transaction and one DecoratedSignature (hint, signature)
+	var env bytes.Buffer
+	binary.Write(&env, binary.BigEndian, uint32(ENVELOPE_TYPE_TX_FEE_BUMP))
+	env.Write(tx.Bytes())
+	binary.Write(&env, binary.BigEndian, uint32(1))
+	hint := full.Hint()
+	env.Write(hint[:])
+	binary.Write(&env, binary.BigEndian, uint32(len(sig)))
+	env.Write(sig)
+	env.Write(make([]byte, (4 - len(sig) % 4) % 4))
+
+	return base64.StdEncoding.EncodeToString(env.Bytes()), hex.EncodeToString(hash[:]), nil
+}
@@ -0,0 +1,116 @@
+package payout
+
+import (
+	"bytes"
+	"testing"
+	"encoding/hex"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/stellar/go/strkey"
+	"github.com/stellar/go/network"
+	"github.com/matheusb-comp/go/pool/fixtures"
+)
+
+// Signed payout transaction of two payments (fee 200), as submitted
+func signedPayout(t *testing.T) string {
+	p := &Plan{Pool: pool, Network: network.TestNetworkPassphrase, Credit: 1000000000,
+		Payments: []Payment{
+			{Kind: PAYMENT_VOTER, Account: voterA, Destination: voterA, Amount: 10000000},
+			{Kind: PAYMENT_VOTER, Account: voterB, Destination: voterB, Amount: 20000000},
+		}}
+	txs, err := p.Build(&Rules{}, 41, [32]byte{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := txs[0].Sign(fixtures.Keypair(0).Seed(), network.TestNetworkPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func TestFeeBump(t *testing.T) {
+	signed := signedPayout(t)
+	raw, _ := base64.StdEncoding.DecodeString(signed)
+	typed := base64.StdEncoding.EncodeToString(append([]byte{0, 0, 0, ENVELOPE_TYPE_TX}, raw...))
+	sponsor := fixtures.Keypair(30)
+	bumped, _, err := FeeBump(signed, sponsor.Seed(), network.TestNetworkPassphrase, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	built, err := (&Plan{Pool: pool, Network: network.TestNetworkPassphrase,
+		Payments: []Payment{{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 1}}}).
+		Build(&Rules{}, 1, [32]byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		envelope string
+		seed string
+		baseFee uint64
+		ok bool
+	}{
+		{"legacy envelope", signed, sponsor.Seed(), 200, true},
+		{"typed envelope", typed, sponsor.Seed(), 200, true},
+		{"same fee", signed, sponsor.Seed(), 100, true},
+		{"lower fee", signed, sponsor.Seed(), 99, false},
+		{"unsigned", built[0].Envelope, sponsor.Seed(), 200, false},
+		{"already a fee-bump", bumped, sponsor.Seed(), 400, false},
+		{"not base64", "not an envelope!", sponsor.Seed(), 200, false},
+		{"truncated", signed[:40], sponsor.Seed(), 200, false},
+		{"fee account address", signed, sponsor.Address(), 200, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, hash, err := FeeBump(tt.envelope, tt.seed, network.TestNetworkPassphrase, tt.baseFee)
+			if (err == nil) != tt.ok {
+				t.Fatalf("FeeBump: %v, want ok %v", err, tt.ok)
+			}
+			if !tt.ok {
+				return
+			}
+			b, err := base64.StdEncoding.DecodeString(env)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Type, then the transaction, and one signature (count, hint,
+			// length and the 64 bytes)
+			if binary.BigEndian.Uint32(b) != ENVELOPE_TYPE_TX_FEE_BUMP {
+				t.Fatalf("envelope type %d", binary.BigEndian.Uint32(b))
+			}
+			tx, sig := b[4:len(b)-76], b[len(b)-64:]
+			if binary.BigEndian.Uint32(b[len(b)-76:]) != 1 {
+				t.Error("not one signature")
+			}
+			hint := sponsor.Hint()
+			if !bytes.Equal(b[len(b)-72:len(b)-68], hint[:]) {
+				t.Error("signature hint of another key")
+			}
+			key, _ := strkey.Decode(strkey.VersionByteAccountID, sponsor.Address())
+			if binary.BigEndian.Uint32(tx) != 0 || !bytes.Equal(tx[4:36], key) {
+				t.Error("fee source isn't the fee account")
+			}
+			if fee := binary.BigEndian.Uint64(tx[36:]); fee != tt.baseFee * 3 {
+				t.Errorf("fee %d, want %d", fee, tt.baseFee * 3)
+			}
+			if binary.BigEndian.Uint32(tx[44:]) != ENVELOPE_TYPE_TX ||
+				!bytes.Equal(tx[48:len(tx)-4], raw) {
+				t.Error("inner transaction changed")
+			}
+
+			id := sha256.Sum256([]byte(network.TestNetworkPassphrase))
+			payload := append(append(id[:], 0, 0, 0, ENVELOPE_TYPE_TX_FEE_BUMP), tx...)
+			want := sha256.Sum256(payload)
+			if hash != hex.EncodeToString(want[:]) {
+				t.Errorf("hash %s, want %x", hash, want)
+			}
+			if err = sponsor.Verify(want[:], sig); err != nil {
+				t.Errorf("signature: %v", err)
+			}
+		})
+	}
+}
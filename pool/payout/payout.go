@@ -0,0 +1,555 @@
+package payout
+
+import (
+	"io"
+	"fmt"
+	"sort"
+	"time"
+	"errors"
+	"strconv"
+	"strings"
+	"math/big"
+	"text/tabwriter"
+	"github.com/stellar/go/strkey"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+// Kinds of payment in a plan
+const (
+	PAYMENT_VOTER = "voter"
+	PAYMENT_DONATION = "donation"
+	PAYMENT_POOL_FEE = "pool_fee"
+	// Amount owed from previous runs to an account not paid as a voter
+	PAYMENT_OWED = "owed"
+)
+
+// Limits of the Stellar network
+const MAX_OPERATIONS = 100
+const DEFAULT_BASE_FEE = 100
+
+// Percentages are kept in basis points (1% = 100)
+const BASIS_POINTS = 10000
+
+// Settings of the payout calculation
+type Rules struct {
+	// Pattern (SQL LIKE, only a trailing %) of the donation data names
+	DonationKey string
+	// Fee of each operation, in stroops
+	BaseFee uint64
+	// Operations in each transaction
+	MaxOps int
+	// Operations of each voter payment (2 when paid partly in an asset, see
+	// AssetRules.Reserve)
+	VoterOps int
+
+	// Eligibility (see Eligible), amounts in stroops
+	MinBalance uint64
+	MinPayout uint64
+	Include AccountSet
+	Exclude AccountSet
+	MinAge time.Duration
+	// Creation time of an account (needed for MinAge), and the time to
+	// measure the age at (default: now)
+	Created func(account string) (time.Time, error)
+	Now time.Time
+	// Amounts carried over from previous runs, added to the next payout.
+	// They're paid even if the account isn't an eligible voter anymore
+	Owed map[string]uint64
+	// Accounts of Owed carried as donation targets: paid as a donation, so
+	// they're never created (see CheckDestinations)
+	OwedDonations AccountSet
+	// Smallest payment that creates a missing destination (see
+	// CheckDestinations), in stroops
+	MinCreate uint64
+	// Pay each donation apart, instead of one payment per destination
+	SplitDonations bool
+	// Pool fee of the voters (none by default)
+	FeeModel FeeModel
+}
+
+// Donation set by a voter in a data pair: "<percent>%<destination>"
+type Donation struct {
+	Destination string `json:"destination"`
+	// Share of the voter payout, in basis points
+	Percent uint64 `json:"percent"`
+}
+
+// One operation of the payout transactions (amounts in stroops)
+type Payment struct {
+	Kind string `json:"kind"`
+	// Voter the payment comes from
+	Account string `json:"account"`
+	Destination string `json:"destination"`
+	Amount uint64 `json:"amount"`
+	// The destination doesn't exist, and is created with the amount
+	Create bool `json:"create,omitempty"`
+	// Voters in an aggregated donation (the Account is empty then)
+	Donors int `json:"donors,omitempty"`
+	// Paid in an asset (see PayInAsset): the amount delivered, and the XLM
+	// sent at most when converted with a path payment (Amount plus the
+	// slippage). Amount is still the value in XLM, taken from the credit
+	Asset *Asset `json:"asset,omitempty"`
+	AssetAmount uint64 `json:"asset_amount,omitempty"`
+	SendMax uint64 `json:"send_max,omitempty"`
+}
+
+// Distribution of the inflation credit of one run
+type Plan struct {
+	Pool string `json:"pool"`
+	Network string `json:"network_passphrase"`
+	Ledger int32 `json:"ledger"`
+	Credit uint64 `json:"credit"`
+	// Estimated network fees of all the transactions, paid from the credit,
+	// and the fee per operation they were estimated with
+	TxFee uint64 `json:"tx_fee"`
+	BaseFee uint64 `json:"base_fee"`
+	// Recent fees the base fee was picked from (nil if fixed)
+	FeeStats *FeeStats `json:"fee_stats,omitempty"`
+	Operations int `json:"operations"`
+	Transactions int `json:"transactions"`
+	// Sum of the payments, and what is left in the pool (rounding)
+	Distributed uint64 `json:"distributed"`
+	Remainder uint64 `json:"remainder"`
+	// XLM the path payments may send above their value, at most (see
+	// PayInAsset), paid from the pool balance
+	Slippage uint64 `json:"slippage,omitempty"`
+	// Owed amounts of previous runs taken in (paid or carried again), and
+	// the amounts carried over to the next run
+	CarriedIn uint64 `json:"carried_in"`
+	CarriedOut uint64 `json:"carried_out"`
+	// Owed amount taken in for each account
+	Owed map[string]uint64 `json:"owed,omitempty"`
+	// Pool fees of the voters, paid to the fee account (or kept in the
+	// pool, as part of the remainder)
+	PoolFee uint64 `json:"pool_fee"`
+	FeeAccount string `json:"fee_account,omitempty"`
+	Payouts []snapshot.Payout `json:"payouts"`
+	Payments []Payment `json:"payments"`
+	// Voters not paid, with the reason
+	Exclusions []Exclusion `json:"exclusions"`
+	// Asset of the voter payments (nil if only XLM)
+	Asset *Asset `json:"asset,omitempty"`
+}
+
+// Parse the donation in a data pair of a voter (the value was already
+// decoded from base64 when the snapshot was taken)
+func ParseDonation(value string) (Donation, error) {
+	var d Donation
+	i := strings.Index(value, "%")
+	if i <= 0 {
+		return d, errors.New("ERROR: Donation without percentage: " + value)
+	}
+	percent, err := strconv.ParseFloat(value[:i], 64)
+	if err != nil || percent <= 0 || percent > 100 {
+		return d, errors.New("ERROR: Invalid donation percentage: " + value[:i])
+	}
+	d.Percent = uint64(percent * BASIS_POINTS / 100 + 0.5)
+	// The checksum catches a mistyped address, not only a malformed one
+	d.Destination = strings.TrimSpace(value[i+1:])
+	if _, err = strkey.Decode(strkey.VersionByteAccountID, d.Destination); err != nil {
+		return d, errors.New("ERROR: Invalid donation destination: " + d.Destination)
+	}
+	return d, nil
+}
+
+// Donations of a voter, in the order of the data pairs (the ones that can't
+// be parsed are ignored, and the total never goes above 100%)
+func (r *Rules) Donations(e snapshot.Entry) []Donation {
+	prefix := strings.TrimSuffix(r.DonationKey, "%")
+	var list []Donation
+	var total uint64
+	for _, data := range e.Data {
+		if !strings.HasPrefix(data.Name, prefix) {
+			continue
+		}
+		d, err := ParseDonation(data.Value)
+		if err != nil || d.Destination == e.ID {
+			continue
+		}
+		if total + d.Percent > BASIS_POINTS {
+			d.Percent = BASIS_POINTS - total
+		}
+		if d.Percent > 0 {
+			list = append(list, d)
+			total += d.Percent
+		}
+	}
+	return list
+}
+
+// Split the credit of the snapshot between the eligible voters, in
+// proportion to their balances, after the network fees. The pool fee and
+// then the donations are taken from each share. Amounts are rounded down,
+// and the rest stays in the pool. Payments below the minimum are carried
+// over (with what was already owed to the account).
+// What is owed from previous runs is paid whatever happens to the account
+// in this one: added to the payout of an eligible voter, to the aggregated
+// donation or pool fee paid to the account, or paid alone (PAYMENT_OWED,
+// or PAYMENT_DONATION for a donation target).
+// Voters that aren't paid, and the accounts that stopped voting, are listed
+// in the exclusions with the owed amount
+func Calculate(s *snapshot.Snapshot, r *Rules) (*Plan, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	if err := r.FeeModel.Validate(); err != nil {
+		return nil, err
+	}
+	r.defaults()
+
+	p := &Plan{Pool: s.Pool, Network: s.Network, Ledger: s.Ledger, Credit: s.Credit}
+
+	// Only the eligible voters share the credit
+	var eligible []snapshot.Entry
+	var excluded []Exclusion
+	var votes uint64
+	voters := map[string]bool{}
+	for _, e := range s.Entries {
+		voters[e.ID] = true
+		reason, err := r.Eligible(e)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			excluded = append(excluded, Exclusion{Account: e.ID,
+				Balance: e.Bal, Reason: reason, Owed: r.Owed[e.ID]})
+			continue
+		}
+		eligible = append(eligible, e)
+		votes += e.Bal
+	}
+	// The accounts owed something that aren't in the snapshot anymore
+	var gone []string
+	for account, owed := range r.Owed {
+		if owed > 0 && !voters[account] {
+			gone = append(gone, account)
+		}
+	}
+	sort.Strings(gone)
+	for _, account := range gone {
+		excluded = append(excluded, Exclusion{Account: account,
+			Reason: REASON_NOT_VOTING, Owed: r.Owed[account]})
+	}
+	owing := 0
+	for _, ex := range excluded {
+		if ex.Owed > 0 {
+			owing++
+		}
+	}
+	if votes == 0 && owing == 0 {
+		p.Exclusions = excluded
+		p.Remainder = s.Credit
+		return p, nil
+	}
+
+	// Reserve the fees of the voter payments (VoterOps each), and one
+	// operation per donation, or per donation destination when aggregated
+	// (the payments below the minimum are dropped later). Donations back to
+	// the pool are left out
+	donations := make([][]Donation, len(eligible))
+	destinations := map[string]bool{}
+	ops := 0
+	for i, e := range eligible {
+		for _, d := range r.Donations(e) {
+			if d.Destination != s.Pool {
+				donations[i] = append(donations[i], d)
+				destinations[d.Destination] = true
+			}
+		}
+		ops += r.VoterOps
+		if r.SplitDonations {
+			ops += len(donations[i])
+		}
+	}
+	if !r.SplitDonations {
+		ops += len(destinations)
+	}
+	if r.FeeModel.Account != "" && r.FeeModel.Account != s.Pool {
+		p.FeeAccount = r.FeeModel.Account
+		ops += 1
+	}
+	// And one for each owed amount paid alone
+	ops += owing
+	reserved := r.Fee(ops)
+	if reserved >= s.Credit {
+		return nil, errors.New("ERROR: The credit doesn't cover the transaction fees")
+	}
+	available := s.Credit - reserved
+
+	aggregated := map[string]int{}
+	for i, e := range eligible {
+		gross := share(available, e.Bal, votes)
+		po := snapshot.Payout{Ledger: s.Ledger, Account: e.ID, Bal: e.Bal, Gross: gross}
+		// The pool fee first, the donations are a part of what is left
+		po.Fee = r.PoolFee(e, s.Pool, gross)
+		p.PoolFee += po.Fee
+		for _, d := range donations[i] {
+			// A donation too small to pay stays with the voter
+			amount := share(gross - po.Fee, d.Percent, BASIS_POINTS)
+			if amount == 0 || amount < r.MinPayout {
+				continue
+			}
+			po.Donations += amount
+			if r.SplitDonations {
+				p.Payments = append(p.Payments, Payment{Kind: PAYMENT_DONATION,
+					Account: e.ID, Destination: d.Destination, Amount: amount})
+				continue
+			}
+			// One payment per destination, for all the voters
+			if j, ok := aggregated[d.Destination]; ok {
+				p.Payments[j].Amount += amount
+				p.Payments[j].Donors++
+				continue
+			}
+			aggregated[d.Destination] = len(p.Payments)
+			p.Payments = append(p.Payments, Payment{Kind: PAYMENT_DONATION,
+				Destination: d.Destination, Amount: amount, Donors: 1})
+		}
+		po.Net = gross - po.Fee - po.Donations
+
+		po.Owed = p.takeOwed(e.ID, r)
+		amount := po.Net + po.Owed
+		switch {
+		case amount == 0:
+		case amount < r.MinPayout:
+			po.Carried = amount
+			p.CarriedOut += amount
+			p.Exclusions = append(p.Exclusions, Exclusion{Account: e.ID,
+				Balance: e.Bal, Reason: REASON_MIN_PAYOUT, Carried: amount})
+		default:
+			p.Payments = append(p.Payments, Payment{Kind: PAYMENT_VOTER,
+				Account: e.ID, Destination: e.ID, Amount: amount})
+		}
+		p.Payouts = append(p.Payouts, po)
+	}
+	if p.FeeAccount != "" && p.PoolFee > 0 {
+		aggregated[p.FeeAccount] = len(p.Payments)
+		p.Payments = append(p.Payments, Payment{Kind: PAYMENT_POOL_FEE,
+			Destination: p.FeeAccount, Amount: p.PoolFee})
+	}
+	for _, ex := range excluded {
+		if ex.Owed > 0 {
+			p.takeOwed(ex.Account, r)
+			// A donation target or the fee account (carried over while it
+			// was missing) gets it with the payment it's already due
+			if i, ok := aggregated[ex.Account]; ok {
+				p.Payments[i].Amount += ex.Owed
+				if ex.Reason == REASON_NOT_VOTING {
+					continue
+				}
+			} else if ex.Owed < r.MinPayout {
+				ex.Carried = ex.Owed
+				p.CarriedOut += ex.Carried
+			} else if r.OwedDonations[ex.Account] {
+				p.Payments = append(p.Payments, Payment{Kind: PAYMENT_DONATION,
+					Destination: ex.Account, Amount: ex.Owed})
+			} else {
+				p.Payments = append(p.Payments, Payment{Kind: PAYMENT_OWED,
+					Account: ex.Account, Destination: ex.Account, Amount: ex.Owed})
+			}
+		}
+		p.Exclusions = append(p.Exclusions, ex)
+	}
+
+	if err := p.sum(r); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Totals of the payments that are actually made (and their fees). Fails
+// if they don't fit in the credit and what was carried in
+func (p *Plan) sum(r *Rules) error {
+	p.Operations = len(p.Payments)
+	p.Transactions = (p.Operations + r.MaxOps - 1) / r.MaxOps
+	p.TxFee = r.Fee(p.Operations)
+	p.BaseFee = r.BaseFee
+	p.Distributed, p.Slippage = 0, 0
+	for _, pay := range p.Payments {
+		p.Distributed += pay.Amount
+		if pay.SendMax > pay.Amount {
+			p.Slippage += pay.SendMax - pay.Amount
+		}
+	}
+	if p.TxFee + p.Distributed + p.CarriedOut > p.Credit + p.CarriedIn {
+		return errors.New("ERROR: The credit doesn't cover the payments and their fees (" +
+			strconv.Itoa(p.Operations) + " operations)")
+	}
+	p.Remainder = p.Credit + p.CarriedIn - p.TxFee - p.Distributed - p.CarriedOut
+	return nil
+}
+
+// Take in what is owed to the account from previous runs
+func (p *Plan) takeOwed(account string, r *Rules) uint64 {
+	owed := r.Owed[account]
+	if owed > 0 {
+		if p.Owed == nil {
+			p.Owed = make(map[string]uint64)
+		}
+		p.Owed[account] += owed
+		p.CarriedIn += owed
+	}
+	return owed
+}
+
+// Amounts owed to each account after the run (the ones carried over)
+func (p *Plan) CarryOver() map[string]uint64 {
+	owed := make(map[string]uint64)
+	for _, ex := range p.Exclusions {
+		if ex.Carried > 0 {
+			owed[ex.Account] += ex.Carried
+		}
+	}
+	return owed
+}
+
+// Network fees of the operations (every transaction pays per operation)
+func (r *Rules) Fee(ops int) uint64 {
+	return uint64(ops) * r.BaseFee
+}
+
+func (r *Rules) defaults() {
+	if r.BaseFee == 0 {
+		r.BaseFee = DEFAULT_BASE_FEE
+	}
+	if r.MinCreate == 0 {
+		r.MinCreate = DEFAULT_MIN_CREATE
+	}
+	if r.MaxOps <= 0 || r.MaxOps > MAX_OPERATIONS {
+		r.MaxOps = MAX_OPERATIONS
+	}
+	if r.VoterOps <= 0 {
+		r.VoterOps = 1
+	}
+}
+
+// amount * part / total, rounded down (without overflowing uint64)
+func share(amount, part, total uint64) uint64 {
+	n := new(big.Int).SetUint64(amount)
+	n.Mul(n, new(big.Int).SetUint64(part))
+	n.Quo(n, new(big.Int).SetUint64(total))
+	return n.Uint64()
+}
+
+// The payments in groups of at most max operations (one per transaction)
+func (p *Plan) Batches(max int) [][]Payment {
+	if max <= 0 || max > MAX_OPERATIONS {
+		max = MAX_OPERATIONS
+	}
+	var batches [][]Payment
+	for i := 0; i < len(p.Payments); i += max {
+		end := i + max
+		if end > len(p.Payments) {
+			end = len(p.Payments)
+		}
+		batches = append(batches, p.Payments[i:end])
+	}
+	return batches
+}
+
+// Human readable summary: what each voter gets, and the totals
+func (p *Plan) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "ACCOUNT\tBALANCE\tGROSS\tFEE\tDONATIONS\tNET\n")
+	for _, po := range p.Payouts {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", po.Account,
+			snapshot.FormatAmount(po.Bal), snapshot.FormatAmount(po.Gross),
+			snapshot.FormatAmount(po.Fee), snapshot.FormatAmount(po.Donations),
+			snapshot.FormatAmount(po.Net))
+	}
+	if donations := p.paymentsOf(PAYMENT_DONATION); len(donations) > 0 {
+		fmt.Fprintf(tw, "\nDONATION TO\tAMOUNT\tFROM\n")
+		for _, pay := range donations {
+			from := pay.Account
+			if from == "" {
+				from = strconv.Itoa(pay.Donors) + " voters"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", pay.Destination,
+				snapshot.FormatAmount(pay.Amount), from)
+		}
+	}
+	var created, assets []Payment
+	for _, pay := range p.Payments {
+		if pay.Create {
+			created = append(created, pay)
+		}
+		if pay.Asset != nil {
+			assets = append(assets, pay)
+		}
+	}
+	if len(assets) > 0 {
+		fmt.Fprintf(tw, "\nPAID IN %s\tXLM VALUE\tASSET AMOUNT\tSEND MAX\n", p.Asset.Code)
+		for _, pay := range assets {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", pay.Destination, snapshot.FormatAmount(pay.Amount),
+				snapshot.FormatAmount(pay.AssetAmount), snapshot.FormatAmount(pay.SendMax))
+		}
+	}
+	if len(created) > 0 {
+		fmt.Fprintf(tw, "\nCREATE ACCOUNT\tSTARTING BALANCE\tKIND\n")
+		for _, pay := range created {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", pay.Destination,
+				snapshot.FormatAmount(pay.Amount), pay.Kind)
+		}
+	}
+	if len(p.Exclusions) > 0 {
+		fmt.Fprintf(tw, "\nNOT PAID\tBALANCE\tREASON\tOWED\tCARRIED\n")
+		for _, ex := range p.Exclusions {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", ex.Account,
+				snapshot.FormatAmount(ex.Balance), ex.Reason,
+				snapshot.FormatAmount(ex.Owed), snapshot.FormatAmount(ex.Carried))
+		}
+	}
+	fmt.Fprintf(tw, "\nPOOL\t%s\n", p.Pool)
+	fmt.Fprintf(tw, "LEDGER\t%d\n", p.Ledger)
+	fmt.Fprintf(tw, "CREDIT\t%s\n", snapshot.FormatAmount(p.Credit))
+	fmt.Fprintf(tw, "DISTRIBUTED\t%s\n", snapshot.FormatAmount(p.Distributed))
+	if p.FeeAccount != "" {
+		fmt.Fprintf(tw, "POOL FEE\t%s\t(paid to %s)\n", snapshot.FormatAmount(p.PoolFee), p.FeeAccount)
+	} else {
+		fmt.Fprintf(tw, "POOL FEE\t%s\t(kept in the pool)\n", snapshot.FormatAmount(p.PoolFee))
+	}
+	fmt.Fprintf(tw, "TX FEES\t%s\t(%d operations in %d transactions, %d stroops each)\n",
+		snapshot.FormatAmount(p.TxFee), p.Operations, p.Transactions, p.BaseFee)
+	fmt.Fprintf(tw, "CARRIED IN\t%s\n", snapshot.FormatAmount(p.CarriedIn))
+	fmt.Fprintf(tw, "CARRIED OUT\t%s\n", snapshot.FormatAmount(p.CarriedOut))
+	fmt.Fprintf(tw, "REMAINDER\t%s\n", snapshot.FormatAmount(p.Remainder))
+	if p.Slippage > 0 {
+		fmt.Fprintf(tw, "SLIPPAGE\t%s\t(at most, from the pool balance)\n", snapshot.FormatAmount(p.Slippage))
+	}
+	return tw.Flush()
+}
+
+// Donations of the plan, one per destination (also when paid apart), in
+// the order they were first made
+func (p *Plan) DonationTotals() []Payment {
+	var totals []Payment
+	index := map[string]int{}
+	for _, pay := range p.Payments {
+		if pay.Kind != PAYMENT_DONATION {
+			continue
+		}
+		donors := pay.Donors
+		if donors == 0 {
+			donors = 1
+		}
+		if i, ok := index[pay.Destination]; ok {
+			totals[i].Amount += pay.Amount
+			totals[i].Donors += donors
+			continue
+		}
+		index[pay.Destination] = len(totals)
+		totals = append(totals, Payment{Kind: PAYMENT_DONATION,
+			Destination: pay.Destination, Amount: pay.Amount, Donors: donors})
+	}
+	return totals
+}
+
+func (p *Plan) paymentsOf(kind string) []Payment {
+	var list []Payment
+	for _, pay := range p.Payments {
+		if pay.Kind == kind {
+			list = append(list, pay)
+		}
+	}
+	return list
+}
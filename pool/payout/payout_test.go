@@ -0,0 +1,375 @@
+package payout
+
+import (
+	"math"
+	"testing"
+	"github.com/stellar/go/network"
+	"github.com/matheusb-comp/go/pool/fixtures"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+const DONATION_KEY = "lumenaut.net donation%"
+
+// Accounts of the tests: the pool, voters, a donation target, the fee
+// account, and one owed something that doesn't vote anymore
+var (
+	pool = fixtures.Address(0)
+	voterA = fixtures.Address(1)
+	voterB = fixtures.Address(2)
+	voterC = fixtures.Address(3)
+	charity = fixtures.Address(10)
+	feeAccount = fixtures.Address(11)
+	gone = fixtures.Address(12)
+)
+
+// Voter of the pool with donations (one data pair each)
+func voter(id string, balance int64, donations ...string) fixtures.Account {
+	a := fixtures.Account{ID: id, Balance: balance, InflationDest: pool}
+	for i, d := range donations {
+		if a.Data == nil {
+			a.Data = make(map[string]string)
+		}
+		a.Data["lumenaut.net donation " + string('a' + rune(i))] = d
+	}
+	return a
+}
+
+// Snapshot of the voters with the credit, taken from the core stand-in
+func snap(t *testing.T, credit uint64, voters ...fixtures.Account) *snapshot.Snapshot {
+	core := &fixtures.Core{}
+	for _, v := range voters {
+		core.AddAccount(v)
+	}
+	s, err := core.Snapshot(network.TestNetworkPassphrase, pool, DONATION_KEY, 100, credit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// The address with one character changed (right length and prefix, wrong
+// checksum)
+func mistyped(address string) string {
+	b := []byte(address)
+	if b[20] == 'A' {
+		b[20] = 'B'
+	} else {
+		b[20] = 'A'
+	}
+	return string(b)
+}
+
+// Compare the kind, destination and amount of the payments (in order)
+func checkPayments(t *testing.T, got, want []Payment) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%d payments, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Kind != w.Kind || g.Destination != w.Destination || g.Amount != w.Amount ||
+			g.Create != w.Create || g.AssetAmount != w.AssetAmount || g.SendMax != w.SendMax {
+			t.Errorf("payment %d: %+v, want %+v", i, g, w)
+		}
+	}
+}
+
+// Every stroop of the credit (and of the carry-over) is accounted for
+func checkBalanced(t *testing.T, p *Plan) {
+	t.Helper()
+	if p.Distributed + p.TxFee + p.CarriedOut + p.Remainder != p.Credit + p.CarriedIn {
+		t.Errorf("plan doesn't add up: distributed %d + fees %d + carried out %d + remainder %d "+
+			"!= credit %d + carried in %d", p.Distributed, p.TxFee, p.CarriedOut, p.Remainder,
+			p.Credit, p.CarriedIn)
+	}
+}
+
+func TestShare(t *testing.T) {
+	tests := []struct {
+		name string
+		amount, part, total uint64
+		want uint64
+	}{
+		{"exact", 1000, 1, 4, 250},
+		{"rounded down", 1000, 1, 3, 333},
+		{"whole", 1000, 7, 7, 1000},
+		{"nothing", 1000, 0, 7, 0},
+		{"basis points", 12345, 150, BASIS_POINTS, 185},
+		{"no overflow", math.MaxUint64, 2, 3, math.MaxUint64 / 3 * 2},
+		{"large balances", 100000000000000000, 9000000000000000000, 10000000000000000000, 90000000000000000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := share(tt.amount, tt.part, tt.total); got != tt.want {
+				t.Errorf("share(%d, %d, %d) = %d, want %d", tt.amount, tt.part, tt.total, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculate(t *testing.T) {
+	tests := []struct {
+		name string
+		voters []fixtures.Account
+		credit uint64
+		rules Rules
+		payments []Payment
+		// Reason of each account not paid
+		excluded map[string]string
+		poolFee uint64
+		carriedOut uint64
+		remainder uint64
+		err bool
+	}{
+		{
+			name: "proportional to the balance",
+			voters: []fixtures.Account{voter(voterA, 100), voter(voterB, 300)},
+			credit: 1200,
+			payments: []Payment{
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 250},
+				{Kind: PAYMENT_VOTER, Destination: voterB, Amount: 750},
+			},
+		},
+		{
+			name: "rounding stays in the pool",
+			voters: []fixtures.Account{voter(voterA, 1), voter(voterB, 1), voter(voterC, 1)},
+			credit: 1300,
+			payments: []Payment{
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 333},
+				{Kind: PAYMENT_VOTER, Destination: voterB, Amount: 333},
+				{Kind: PAYMENT_VOTER, Destination: voterC, Amount: 333},
+			},
+			remainder: 1,
+		},
+		{
+			name: "donations aggregated by destination",
+			voters: []fixtures.Account{voter(voterA, 100, "10%" + charity),
+				voter(voterB, 100, "20%" + charity)},
+			credit: 2300,
+			payments: []Payment{
+				{Kind: PAYMENT_DONATION, Destination: charity, Amount: 300},
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 900},
+				{Kind: PAYMENT_VOTER, Destination: voterB, Amount: 800},
+			},
+		},
+		{
+			name: "donations paid apart",
+			voters: []fixtures.Account{voter(voterA, 100, "10%" + charity),
+				voter(voterB, 100, "20%" + charity)},
+			credit: 2400,
+			rules: Rules{SplitDonations: true},
+			payments: []Payment{
+				{Kind: PAYMENT_DONATION, Destination: charity, Amount: 100},
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 900},
+				{Kind: PAYMENT_DONATION, Destination: charity, Amount: 200},
+				{Kind: PAYMENT_VOTER, Destination: voterB, Amount: 800},
+			},
+		},
+		{
+			name: "donation to the pool left out",
+			voters: []fixtures.Account{voter(voterA, 100, "10%" + pool)},
+			credit: 1100,
+			payments: []Payment{
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 1000},
+			},
+		},
+		{
+			name: "donations capped at 100%",
+			voters: []fixtures.Account{voter(voterA, 100, "80%" + charity, "50%" + feeAccount)},
+			credit: 1300,
+			payments: []Payment{
+				{Kind: PAYMENT_DONATION, Destination: charity, Amount: 800},
+				{Kind: PAYMENT_DONATION, Destination: feeAccount, Amount: 200},
+			},
+			remainder: 100,
+		},
+		{
+			name: "pool fee paid to the fee account",
+			voters: []fixtures.Account{voter(voterA, 100, "10%" + charity), voter(voterB, 100)},
+			credit: 2400,
+			rules: Rules{FeeModel: FeeModel{Percent: 1000, Account: feeAccount}},
+			payments: []Payment{
+				{Kind: PAYMENT_DONATION, Destination: charity, Amount: 90},
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 810},
+				{Kind: PAYMENT_VOTER, Destination: voterB, Amount: 900},
+				{Kind: PAYMENT_POOL_FEE, Destination: feeAccount, Amount: 200},
+			},
+			poolFee: 200,
+		},
+		{
+			name: "pool fee kept in the pool",
+			voters: []fixtures.Account{voter(voterA, 100), voter(voterB, 100)},
+			credit: 2200,
+			rules: Rules{FeeModel: FeeModel{Percent: 1000}},
+			payments: []Payment{
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 900},
+				{Kind: PAYMENT_VOTER, Destination: voterB, Amount: 900},
+			},
+			poolFee: 200,
+			remainder: 200,
+		},
+		{
+			name: "not eligible",
+			voters: []fixtures.Account{voter(voterA, 100), voter(voterB, 10), voter(voterC, 500)},
+			credit: 1100,
+			rules: Rules{MinBalance: 50, Exclude: AccountSet{voterC: true}},
+			payments: []Payment{
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 1000},
+			},
+			excluded: map[string]string{voterB: REASON_MIN_BALANCE, voterC: REASON_EXCLUDED},
+		},
+		{
+			name: "included below the minimum balance",
+			voters: []fixtures.Account{voter(voterA, 100), voter(voterB, 100)},
+			credit: 1100,
+			rules: Rules{MinBalance: 500, Include: AccountSet{voterB: true}},
+			payments: []Payment{
+				{Kind: PAYMENT_VOTER, Destination: voterB, Amount: 1000},
+			},
+			excluded: map[string]string{voterA: REASON_MIN_BALANCE},
+		},
+		{
+			name: "below the minimum payout",
+			voters: []fixtures.Account{voter(voterA, 100), voter(voterB, 900)},
+			credit: 1200,
+			rules: Rules{MinPayout: 300},
+			payments: []Payment{
+				{Kind: PAYMENT_VOTER, Destination: voterB, Amount: 900},
+			},
+			excluded: map[string]string{voterA: REASON_MIN_PAYOUT},
+			carriedOut: 100,
+			remainder: 100,
+		},
+		{
+			name: "owed added to the payout",
+			voters: []fixtures.Account{voter(voterA, 100), voter(voterB, 100)},
+			credit: 1200,
+			rules: Rules{Owed: map[string]uint64{voterA: 50}},
+			payments: []Payment{
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 550},
+				{Kind: PAYMENT_VOTER, Destination: voterB, Amount: 500},
+			},
+		},
+		{
+			name: "owed reaching the minimum payout",
+			voters: []fixtures.Account{voter(voterA, 100), voter(voterB, 900)},
+			credit: 1200,
+			rules: Rules{MinPayout: 300, Owed: map[string]uint64{voterA: 200}},
+			payments: []Payment{
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 300},
+				{Kind: PAYMENT_VOTER, Destination: voterB, Amount: 900},
+			},
+		},
+		{
+			name: "owed to an account not voting",
+			voters: []fixtures.Account{voter(voterA, 100)},
+			credit: 1200,
+			rules: Rules{Owed: map[string]uint64{gone: 500}},
+			payments: []Payment{
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 1000},
+				{Kind: PAYMENT_OWED, Destination: gone, Amount: 500},
+			},
+			excluded: map[string]string{gone: REASON_NOT_VOTING},
+		},
+		{
+			name: "owed below the minimum carried again",
+			voters: []fixtures.Account{voter(voterA, 100)},
+			credit: 1200,
+			rules: Rules{MinPayout: 100, Owed: map[string]uint64{gone: 50}},
+			payments: []Payment{
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 1000},
+			},
+			excluded: map[string]string{gone: REASON_NOT_VOTING},
+			carriedOut: 50,
+			remainder: 100,
+		},
+		{
+			name: "owed to a donation target",
+			voters: []fixtures.Account{voter(voterA, 100, "10%" + charity)},
+			credit: 1300,
+			rules: Rules{Owed: map[string]uint64{charity: 40}},
+			payments: []Payment{
+				{Kind: PAYMENT_DONATION, Destination: charity, Amount: 140},
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 900},
+			},
+			remainder: 100,
+		},
+		{
+			name: "owed to a donation target without donations",
+			voters: []fixtures.Account{voter(voterA, 100)},
+			credit: 1200,
+			rules: Rules{Owed: map[string]uint64{charity: 500},
+				OwedDonations: AccountSet{charity: true}},
+			payments: []Payment{
+				{Kind: PAYMENT_VOTER, Destination: voterA, Amount: 1000},
+				{Kind: PAYMENT_DONATION, Destination: charity, Amount: 500},
+			},
+			excluded: map[string]string{charity: REASON_NOT_VOTING},
+		},
+		{
+			name: "only owed amounts",
+			credit: 1000,
+			rules: Rules{Owed: map[string]uint64{gone: 500}},
+			payments: []Payment{
+				{Kind: PAYMENT_OWED, Destination: gone, Amount: 500},
+			},
+			excluded: map[string]string{gone: REASON_NOT_VOTING},
+			remainder: 900,
+		},
+		{
+			name: "no voters",
+			credit: 1000,
+			remainder: 1000,
+		},
+		{
+			name: "credit below the fees",
+			voters: []fixtures.Account{voter(voterA, 100), voter(voterB, 100)},
+			credit: 200,
+			err: true,
+		},
+		{
+			name: "invalid fee account",
+			voters: []fixtures.Account{voter(voterA, 100)},
+			credit: 1100,
+			rules: Rules{FeeModel: FeeModel{Account: mistyped(feeAccount)}},
+			err: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := snap(t, tt.credit, tt.voters...)
+			p, err := Calculate(s, &tt.rules)
+			if tt.err {
+				if err == nil {
+					t.Fatal("no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkPayments(t, p.Payments, tt.payments)
+			checkBalanced(t, p)
+
+			excluded := map[string]string{}
+			for _, ex := range p.Exclusions {
+				excluded[ex.Account] = ex.Reason
+			}
+			if len(excluded) != len(tt.excluded) {
+				t.Errorf("excluded %v, want %v", excluded, tt.excluded)
+			}
+			for account, reason := range tt.excluded {
+				if excluded[account] != reason {
+					t.Errorf("%s excluded for %q, want %q", account, excluded[account], reason)
+				}
+			}
+			if p.PoolFee != tt.poolFee || p.CarriedOut != tt.carriedOut || p.Remainder != tt.remainder {
+				t.Errorf("pool fee %d, carried out %d, remainder %d, want %d, %d, %d",
+					p.PoolFee, p.CarriedOut, p.Remainder, tt.poolFee, tt.carriedOut, tt.remainder)
+			}
+			if p.Operations != len(tt.payments) || p.TxFee != p.BaseFee * uint64(len(tt.payments)) {
+				t.Errorf("%d operations, fee %d", p.Operations, p.TxFee)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package payout
+
+import (
+	"flag"
+	"sort"
+	"errors"
+	"strconv"
+	"strings"
+	"github.com/stellar/go/strkey"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+// Value of a data pair (named like the donations, see -key) asking for the
+// supporter tier, without pool fee
+const DEFAULT_SUPPORTER_VALUE = "supporter"
+
+// Pool fee of the voters with at least a balance (stroops), in basis points
+type FeeTier struct {
+	MinBalance uint64 `json:"min_balance"`
+	Percent uint64 `json:"percent"`
+}
+
+// Pool fee taken from the share of each voter: the flat percentage, or the
+// one of the highest tier the balance reaches, up to the cap. Supporters
+// (and the pool itself) pay nothing. The fee is paid to the fee account,
+// or stays in the pool if there's none
+type FeeModel struct {
+	// Flat fee and tiers, in basis points
+	Percent uint64
+	Tiers []FeeTier
+	// Maximum fee of one voter in a run, in stroops (0 is no limit)
+	Cap uint64
+	// Value of a data pair matched by the donation key asking for no fee
+	SupporterValue string
+	Account string
+}
+
+// Percentage flag, kept in basis points ("2", "2%" or "1.5%")
+type percentValue uint64
+
+func (p *percentValue) String() string {
+	return formatPercent(uint64(*p))
+}
+
+func (p *percentValue) Set(value string) error {
+	v, err := parsePercent(value)
+	if err != nil {
+		return err
+	}
+	*p = percentValue(v)
+	return nil
+}
+
+// Tiers flag: comma separated <min balance XLM>:<percent>
+type tiersValue []FeeTier
+
+func (t *tiersValue) String() string {
+	var list []string
+	for _, tier := range *t {
+		list = append(list, snapshot.FormatAmount(tier.MinBalance) + ":" + formatPercent(tier.Percent))
+	}
+	return strings.Join(list, ",")
+}
+
+func (t *tiersValue) Set(value string) error {
+	var tiers []FeeTier
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		i := strings.Index(v, ":")
+		if i <= 0 {
+			return errors.New("ERROR: Invalid fee tier (<min balance>:<percent>): " + v)
+		}
+		balance, err := snapshot.ParseAmount(v[:i])
+		if err != nil {
+			return err
+		}
+		percent, err := parsePercent(v[i+1:])
+		if err != nil {
+			return err
+		}
+		tiers = append(tiers, FeeTier{MinBalance: balance, Percent: percent})
+	}
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].MinBalance < tiers[j].MinBalance })
+	*t = tiers
+	return nil
+}
+
+func parsePercent(value string) (uint64, error) {
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "%"), 64)
+	if err != nil || percent < 0 || percent > 100 {
+		return 0, errors.New("ERROR: Invalid percentage: " + value)
+	}
+	return uint64(percent * BASIS_POINTS / 100 + 0.5), nil
+}
+
+func formatPercent(bp uint64) string {
+	return strconv.FormatFloat(float64(bp) * 100 / BASIS_POINTS, 'f', -1, 64) + "%"
+}
+
+// Define the pool fee flags in the flag set (also part of the rules flags)
+func (f *FeeModel) RegisterFlags(fs *flag.FlagSet) {
+	fs.Var((*percentValue)(&f.Percent), "pool-fee",
+		"Pool fee taken from the payout of each voter (for example, 1.5%)")
+
+	fs.Var((*tiersValue)(&f.Tiers), "pool-fee-tiers",
+		"Pool fee by voter balance, replacing -pool-fee for the balances reached " +
+		"(comma separated <min balance XLM>:<percent>, for example 10000:1%,100000:0.5%)")
+
+	fs.Var((*amountValue)(&f.Cap), "pool-fee-cap",
+		"Maximum pool fee (XLM) of one voter in a run (0 is no limit)")
+
+	fs.StringVar(&f.SupporterValue, "supporter-value", DEFAULT_SUPPORTER_VALUE,
+		"Value of a data pair named like the donations (-key) that asks for " +
+		"the supporter tier, without pool fee")
+
+	fs.StringVar(&f.Account, "pool-fee-account", "",
+		"Account the pool fees of each run are paid to (default: kept in the pool)")
+}
+
+// Check the fee account
+func (f *FeeModel) Validate() error {
+	if f.Account == "" {
+		return nil
+	}
+	if _, err := strkey.Decode(strkey.VersionByteAccountID, f.Account); err != nil {
+		return errors.New("ERROR: Invalid fee account: " + f.Account)
+	}
+	return nil
+}
+
+// The voter asked for the supporter tier in a data pair
+func (r *Rules) Supporter(e snapshot.Entry) bool {
+	value := r.FeeModel.SupporterValue
+	if value == "" {
+		value = DEFAULT_SUPPORTER_VALUE
+	}
+	prefix := strings.TrimSuffix(r.DonationKey, "%")
+	for _, data := range e.Data {
+		if strings.HasPrefix(data.Name, prefix) && strings.TrimSpace(data.Value) == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Pool fee of a voter, out of its share of the credit
+func (r *Rules) PoolFee(e snapshot.Entry, pool string, gross uint64) uint64 {
+	if e.ID == pool || r.Supporter(e) {
+		return 0
+	}
+	percent := r.FeeModel.Percent
+	for _, tier := range r.FeeModel.Tiers {
+		if e.Bal >= tier.MinBalance {
+			percent = tier.Percent
+		}
+	}
+	fee := share(gross, percent, BASIS_POINTS)
+	if r.FeeModel.Cap > 0 && fee > r.FeeModel.Cap {
+		fee = r.FeeModel.Cap
+	}
+	return fee
+}
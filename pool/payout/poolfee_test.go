@@ -0,0 +1,107 @@
+package payout
+
+import (
+	"testing"
+	"github.com/matheusb-comp/go/pool/fixtures"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+func TestPoolFee(t *testing.T) {
+	const XLM = snapshot.STROOPS_PER_UNIT
+	tiers := []FeeTier{{MinBalance: 1000 * XLM, Percent: 100}, {MinBalance: 10000 * XLM, Percent: 50}}
+	supporter := []snapshot.Data{{Name: "lumenaut.net donation", Value: " supporter "}}
+
+	tests := []struct {
+		name string
+		model FeeModel
+		entry snapshot.Entry
+		gross uint64
+		want uint64
+	}{
+		{"no fee", FeeModel{}, snapshot.Entry{ID: voterA, Bal: XLM}, 10000, 0},
+		{"flat", FeeModel{Percent: 200}, snapshot.Entry{ID: voterA, Bal: XLM}, 10000, 200},
+		{"rounded down", FeeModel{Percent: 150}, snapshot.Entry{ID: voterA, Bal: XLM}, 999, 14},
+		{"below the tiers", FeeModel{Percent: 200, Tiers: tiers},
+			snapshot.Entry{ID: voterA, Bal: 999 * XLM}, 10000, 200},
+		{"first tier", FeeModel{Percent: 200, Tiers: tiers},
+			snapshot.Entry{ID: voterA, Bal: 1000 * XLM}, 10000, 100},
+		{"highest tier", FeeModel{Percent: 200, Tiers: tiers},
+			snapshot.Entry{ID: voterA, Bal: 50000 * XLM}, 10000, 50},
+		{"capped", FeeModel{Percent: 1000, Cap: 500}, snapshot.Entry{ID: voterA, Bal: XLM}, 10000, 500},
+		{"below the cap", FeeModel{Percent: 1000, Cap: 5000}, snapshot.Entry{ID: voterA, Bal: XLM}, 10000, 1000},
+		{"supporter", FeeModel{Percent: 200},
+			snapshot.Entry{ID: voterA, Bal: XLM, Data: supporter}, 10000, 0},
+		{"custom supporter value", FeeModel{Percent: 200, SupporterValue: "friend"},
+			snapshot.Entry{ID: voterA, Bal: XLM, Data: []snapshot.Data{
+				{Name: "lumenaut.net donation 2", Value: "friend"}}}, 10000, 0},
+		{"default value with a custom one", FeeModel{Percent: 200, SupporterValue: "friend"},
+			snapshot.Entry{ID: voterA, Bal: XLM, Data: supporter}, 10000, 200},
+		{"other data name", FeeModel{Percent: 200},
+			snapshot.Entry{ID: voterA, Bal: XLM, Data: []snapshot.Data{
+				{Name: "supporter", Value: "supporter"}}}, 10000, 200},
+		{"the pool", FeeModel{Percent: 200}, snapshot.Entry{ID: pool, Bal: XLM}, 10000, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Rules{DonationKey: DONATION_KEY, FeeModel: tt.model}
+			if got := r.PoolFee(tt.entry, pool, tt.gross); got != tt.want {
+				t.Errorf("PoolFee = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFeeModelValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		account string
+		ok bool
+	}{
+		{"kept in the pool", "", true},
+		{"fee account", feeAccount, true},
+		{"mistyped", mistyped(feeAccount), false},
+		{"secret seed", fixtures.Keypair(11).Seed(), false},
+		{"too short", feeAccount[:55], false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := FeeModel{Account: tt.account}
+			if err := f.Validate(); (err == nil) != tt.ok {
+				t.Errorf("Validate: %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestFeeFlags(t *testing.T) {
+	tests := []struct {
+		value string
+		tiers []FeeTier
+		ok bool
+	}{
+		{"1000:1%", []FeeTier{{1000 * snapshot.STROOPS_PER_UNIT, 100}}, true},
+		{"100000:0.5%, 10000:1.5", []FeeTier{{10000 * snapshot.STROOPS_PER_UNIT, 150},
+			{100000 * snapshot.STROOPS_PER_UNIT, 50}}, true},
+		{"", nil, true},
+		{"1000", nil, false},
+		{"1000:101%", nil, false},
+		{"x:1%", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			var tiers tiersValue
+			err := tiers.Set(tt.value)
+			if (err == nil) != tt.ok {
+				t.Fatalf("Set: %v, want ok %v", err, tt.ok)
+			}
+			if len(tiers) != len(tt.tiers) {
+				t.Fatalf("tiers %v, want %v", tiers, tt.tiers)
+			}
+			for i := range tiers {
+				if tiers[i] != tt.tiers[i] {
+					t.Errorf("tier %d: %v, want %v", i, tiers[i], tt.tiers[i])
+				}
+			}
+		})
+	}
+}
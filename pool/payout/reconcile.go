@@ -0,0 +1,458 @@
+package payout
+
+import (
+	"io"
+	"fmt"
+	"errors"
+	"strconv"
+	"strings"
+	"net/http"
+	"encoding/json"
+	"text/tabwriter"
+	"github.com/stellar/go/clients/horizon"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+// Result of matching a planned payment with the payments on-chain
+const (
+	MATCH_OK = "matched"
+	MATCH_MISSING = "missing"
+	MATCH_DUPLICATE = "duplicate"
+	MATCH_MISMATCH = "amount mismatch"
+	MATCH_UNEXPECTED = "unexpected"
+)
+
+// Records of a Horizon page (any kind)
+const RECONCILE_PAGE_LIMIT = 200
+
+// A payment made by the pool account, as seen in Horizon (amounts in stroops)
+type OnChain struct {
+	ID string `json:"id"`
+	Hash string `json:"transaction_hash"`
+	Ledger int32 `json:"ledger"`
+	Type string `json:"type"`
+	Destination string `json:"destination"`
+	// Amount delivered (in the asset), and the XLM taken from the pool
+	Amount uint64 `json:"amount"`
+	Asset Asset `json:"asset"`
+	Sent uint64 `json:"sent"`
+}
+
+// A planned payment and the on-chain payment it was matched with (either
+// can be missing)
+type Match struct {
+	Status string `json:"status"`
+	Planned *Payment `json:"planned,omitempty"`
+	OnChain *OnChain `json:"on_chain,omitempty"`
+}
+
+// Comparison of a payout plan with what happened on-chain in a ledger
+// range. The XLM balance change of the pool (credited minus debited minus
+// fees) must be the credit minus the planned payouts minus the planned
+// fees, so fees above the plan show in the difference
+type Reconciliation struct {
+	Pool string `json:"pool"`
+	From int32 `json:"from_ledger"`
+	To int32 `json:"to_ledger"`
+	Matches []Match `json:"matches"`
+	// XLM effects of the pool in the range, the fees it paid, and the fees
+	// of the plan
+	Credited uint64 `json:"credited"`
+	Debited uint64 `json:"debited"`
+	Fees uint64 `json:"fees"`
+	PlannedFees uint64 `json:"planned_fees"`
+	// Balance change expected from the plan, and the one observed
+	Expected int64 `json:"expected"`
+	Observed int64 `json:"observed"`
+	Difference int64 `json:"difference"`
+	// Problems found (matches other than MATCH_OK)
+	Problems int `json:"problems"`
+}
+
+// Everything matched and the balance change reconciles exactly
+func (r *Reconciliation) OK() bool {
+	return r.Problems == 0 && r.Difference == 0
+}
+
+// Match the planned payments with the on-chain ones by destination, asset
+// and amount (a path payment matches if it delivered at least the planned
+// amount, sending at most SendMax). Then, what is left is paired by
+// destination (mismatched amounts), or flagged as duplicate, unexpected or
+// missing
+func Reconcile(p *Plan, paid []OnChain, credited, debited, fees uint64) *Reconciliation {
+	r := &Reconciliation{Pool: p.Pool, Credited: credited, Debited: debited,
+		Fees: fees, PlannedFees: p.TxFee}
+	planned := make([]*Payment, len(p.Payments))
+	for i := range p.Payments {
+		planned[i] = &p.Payments[i]
+	}
+	onChain := make([]*OnChain, len(paid))
+	for i := range paid {
+		onChain[i] = &paid[i]
+	}
+
+	// XLM the plan takes from the pool (path payments send what they cost)
+	var sent uint64
+	take := func(pay *Payment, oc *OnChain) {
+		switch {
+		case pay.Asset == nil:
+			sent += pay.Amount
+		case pay.SendMax > 0:
+			sent += oc.Sent
+		}
+	}
+
+	// Exact matches
+	for i, pay := range planned {
+		for j, oc := range onChain {
+			if oc != nil && matches(pay, oc) {
+				r.Matches = append(r.Matches, Match{MATCH_OK, pay, oc})
+				take(pay, oc)
+				planned[i], onChain[j] = nil, nil
+				break
+			}
+		}
+	}
+	// Payments to a destination planned with another amount
+	for i, pay := range planned {
+		if pay == nil {
+			continue
+		}
+		for j, oc := range onChain {
+			if oc != nil && oc.Destination == pay.Destination {
+				r.Matches = append(r.Matches, Match{MATCH_MISMATCH, pay, oc})
+				take(pay, oc)
+				planned[i], onChain[j] = nil, nil
+				break
+			}
+		}
+	}
+	// Payments left over: duplicates of a matched one, or not planned at all
+	for _, oc := range onChain {
+		if oc == nil {
+			continue
+		}
+		status := MATCH_UNEXPECTED
+		for _, pay := range p.Payments {
+			if matches(&pay, oc) {
+				status = MATCH_DUPLICATE
+				break
+			}
+		}
+		r.Matches = append(r.Matches, Match{Status: status, OnChain: oc})
+	}
+	for _, pay := range planned {
+		if pay != nil {
+			r.Matches = append(r.Matches, Match{Status: MATCH_MISSING, Planned: pay})
+		}
+	}
+	for _, m := range r.Matches {
+		if m.Status != MATCH_OK {
+			r.Problems++
+		}
+	}
+
+	r.Expected = int64(p.Credit) - int64(sent) - int64(p.TxFee)
+	r.Observed = int64(credited) - int64(debited) - int64(fees)
+	r.Difference = r.Observed - r.Expected
+	return r
+}
+
+func matches(pay *Payment, oc *OnChain) bool {
+	if pay.Destination != oc.Destination {
+		return false
+	}
+	if pay.Asset == nil {
+		return oc.Asset.Native() && pay.Amount == oc.Amount
+	}
+	if oc.Asset != *pay.Asset {
+		return false
+	}
+	if pay.SendMax > 0 {
+		return oc.Amount >= pay.AssetAmount && oc.Sent <= pay.SendMax
+	}
+	return oc.Amount == pay.AssetAmount
+}
+
+// Problems and totals of the reconciliation, as a table
+func (r *Reconciliation) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if r.Problems > 0 {
+		fmt.Fprintf(tw, "STATUS\tDESTINATION\tPLANNED\tON-CHAIN\tTRANSACTION\n")
+		for _, m := range r.Matches {
+			if m.Status == MATCH_OK {
+				continue
+			}
+			var dest, planned, onChain, hash string
+			if m.Planned != nil {
+				dest, planned = m.Planned.Destination, snapshot.FormatAmount(m.Planned.Amount)
+				if m.Planned.Asset != nil {
+					planned = snapshot.FormatAmount(m.Planned.AssetAmount) + " " + m.Planned.Asset.Code
+				}
+			}
+			if m.OnChain != nil {
+				dest, hash = m.OnChain.Destination, m.OnChain.Hash
+				onChain = snapshot.FormatAmount(m.OnChain.Amount)
+				if !m.OnChain.Asset.Native() {
+					onChain += " " + m.OnChain.Asset.Code
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", m.Status, dest, planned, onChain, hash)
+		}
+		fmt.Fprintln(tw)
+	}
+	fmt.Fprintf(tw, "POOL\t%s\n", r.Pool)
+	fmt.Fprintf(tw, "LEDGERS\t%d to %d\n", r.From, r.To)
+	fmt.Fprintf(tw, "PAYMENTS\t%d matched, %d problems\n", len(r.Matches) - r.Problems, r.Problems)
+	fmt.Fprintf(tw, "CREDITED\t%s\n", snapshot.FormatAmount(r.Credited))
+	fmt.Fprintf(tw, "DEBITED\t%s\n", snapshot.FormatAmount(r.Debited))
+	fmt.Fprintf(tw, "FEES PAID\t%s\t(planned %s)\n", snapshot.FormatAmount(r.Fees),
+		snapshot.FormatAmount(r.PlannedFees))
+	fmt.Fprintf(tw, "EXPECTED CHANGE\t%s\n", signedAmount(r.Expected))
+	fmt.Fprintf(tw, "OBSERVED CHANGE\t%s\n", signedAmount(r.Observed))
+	fmt.Fprintf(tw, "DIFFERENCE\t%s\n", signedAmount(r.Difference))
+	return tw.Flush()
+}
+
+func signedAmount(v int64) string {
+	if v < 0 {
+		return "-" + snapshot.FormatAmount(uint64(-v))
+	}
+	return snapshot.FormatAmount(uint64(v))
+}
+
+// Walks the activity of the pool account in Horizon between two ledgers
+// (to 0 is up to the latest one)
+type HorizonActivity struct {
+	Client *horizon.Client
+	Pool string
+	From int32
+	To int32
+	// Transactions of the run: only their payments and fees are counted
+	// (all the activity of the pool in the range if empty)
+	Hashes map[string]bool
+}
+
+// Returned by a visit to stop the walk early
+var errStopWalk = errors.New("stop")
+
+// Set To to the last ledger with a transaction of the run (see Hashes), so
+// the activity of the pool after it isn't counted. If none of them is
+// on-chain, the range is only the first ledger
+func (h *HorizonActivity) ToLastLedger() error {
+	h.To = 0
+	last, found := h.From, 0
+	err := h.walk("transactions", func(raw json.RawMessage, ledger int32) error {
+		var rec struct {
+			Hash string `json:"hash"`
+		}
+		if err := json.Unmarshal(raw, &rec); err != nil {
+			return err
+		}
+		if h.Hashes[rec.Hash] {
+			last, found = ledger, found + 1
+			if found == len(h.Hashes) {
+				return errStopWalk
+			}
+		}
+		return nil
+	})
+	h.To = last
+	return err
+}
+
+// Counted in the walk (a transaction of the run, if they're known)
+func (h *HorizonActivity) counts(hash string) bool {
+	return len(h.Hashes) == 0 || h.Hashes[hash]
+}
+
+// Outgoing payments of the pool (payment, the path payments and
+// create_account)
+func (h *HorizonActivity) Payments() ([]OnChain, error) {
+	var list []OnChain
+	err := h.walk("payments", func(raw json.RawMessage, ledger int32) error {
+		var rec struct {
+			ID string `json:"id"`
+			Hash string `json:"transaction_hash"`
+			Type string `json:"type"`
+			From string `json:"from"`
+			To string `json:"to"`
+			Amount string `json:"amount"`
+			AssetType string `json:"asset_type"`
+			AssetCode string `json:"asset_code"`
+			Issuer string `json:"asset_issuer"`
+			SourceAmount string `json:"source_amount"`
+			Funder string `json:"funder"`
+			Account string `json:"account"`
+			StartingBalance string `json:"starting_balance"`
+		}
+		if err := json.Unmarshal(raw, &rec); err != nil {
+			return err
+		}
+		oc := OnChain{ID: rec.ID, Hash: rec.Hash, Ledger: ledger, Type: rec.Type,
+			Asset: AssetOf(rec.AssetType, rec.AssetCode, rec.Issuer)}
+		var err error
+		switch {
+		case !h.counts(rec.Hash):
+			return nil
+		case rec.Type == "create_account" && rec.Funder == h.Pool:
+			oc.Destination, oc.Asset = rec.Account, Asset{Type: ASSET_NATIVE}
+			oc.Amount, err = snapshot.ParseAmount(rec.StartingBalance)
+			oc.Sent = oc.Amount
+		case rec.Type == "payment" && rec.From == h.Pool:
+			oc.Destination = rec.To
+			oc.Amount, err = snapshot.ParseAmount(rec.Amount)
+			if oc.Asset.Native() {
+				oc.Sent = oc.Amount
+			}
+		case pathPayment(rec.Type) && rec.From == h.Pool:
+			oc.Destination = rec.To
+			if oc.Amount, err = snapshot.ParseAmount(rec.Amount); err == nil {
+				oc.Sent, err = snapshot.ParseAmount(rec.SourceAmount)
+			}
+		default:
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		list = append(list, oc)
+		return nil
+	})
+	return list, err
+}
+
+// Path payments are "path_payment" in older Horizon versions, and
+// strict receive or strict send after protocol 12
+func pathPayment(kind string) bool {
+	switch kind {
+	case "path_payment", "path_payment_strict_receive", "path_payment_strict_send":
+		return true
+	}
+	return false
+}
+
+// Sum of the XLM credited to and debited from the pool, in the whole range
+// (the effects don't tell their transaction)
+func (h *HorizonActivity) Balance() (uint64, uint64, error) {
+	var credited, debited uint64
+	err := h.walk("effects", func(raw json.RawMessage, ledger int32) error {
+		var rec struct {
+			Type string `json:"type"`
+			Amount string `json:"amount"`
+			AssetType string `json:"asset_type"`
+		}
+		if err := json.Unmarshal(raw, &rec); err != nil {
+			return err
+		}
+		if rec.AssetType != ASSET_NATIVE ||
+			(rec.Type != "account_credited" && rec.Type != "account_debited") {
+			return nil
+		}
+		amount, err := snapshot.ParseAmount(rec.Amount)
+		if err != nil {
+			return err
+		}
+		if rec.Type == "account_credited" {
+			credited += amount
+		} else {
+			debited += amount
+		}
+		return nil
+	})
+	return credited, debited, err
+}
+
+// Fees paid by the pool in its transactions (failed ones pay too). The fee
+// is the fee_charged string in current Horizon versions, and the fee_paid
+// number in older ones
+func (h *HorizonActivity) Fees() (uint64, error) {
+	var fees uint64
+	err := h.walk("transactions", func(raw json.RawMessage, ledger int32) error {
+		var rec struct {
+			Hash string `json:"hash"`
+			Source string `json:"source_account"`
+			FeeCharged interface{} `json:"fee_charged"`
+			FeePaid interface{} `json:"fee_paid"`
+		}
+		if err := json.Unmarshal(raw, &rec); err != nil {
+			return err
+		}
+		if rec.Source != h.Pool || !h.counts(rec.Hash) {
+			return nil
+		}
+		fee := statString(rec.FeeCharged)
+		if fee == "" {
+			fee = statString(rec.FeePaid)
+		}
+		v, err := strconv.ParseUint(fee, 10, 64)
+		if err != nil {
+			return errors.New("ERROR: Missing or invalid fee of transaction " + rec.Hash)
+		}
+		fees += v
+		return nil
+	})
+	return fees, err
+}
+
+// Visit the records of /accounts/<pool>/<kind> in the ledger range. The
+// paging tokens start with the operation ID, which has the ledger in its
+// upper 32 bits, so the walk starts at the first ledger with a cursor
+func (h *HorizonActivity) walk(kind string, visit func(json.RawMessage, int32) error) error {
+	cursor := strconv.FormatInt(int64(h.From) << 32, 10)
+	next := h.Client.URL + "/accounts/" + h.Pool + "/" + kind + "?order=asc&limit=" +
+		strconv.Itoa(RECONCILE_PAGE_LIMIT) + "&cursor=" + cursor
+	for {
+		var page struct {
+			Links struct {
+				Next horizon.Link `json:"next"`
+			} `json:"_links"`
+			Embedded struct {
+				Records []json.RawMessage `json:"records"`
+			} `json:"_embedded"`
+		}
+		req, err := http.NewRequest("GET", next, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := h.Client.HTTP.Do(req)
+		if err != nil {
+			return errors.New("ERROR getting " + kind + ": " + err.Error())
+		}
+		if resp.StatusCode == http.StatusOK {
+			err = json.NewDecoder(resp.Body).Decode(&page)
+		} else {
+			err = errors.New(resp.Status)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return errors.New("ERROR getting " + kind + ": " + err.Error())
+		}
+		if len(page.Embedded.Records) == 0 {
+			return nil
+		}
+
+		for _, raw := range page.Embedded.Records {
+			var rec struct {
+				PT string `json:"paging_token"`
+			}
+			if err = json.Unmarshal(raw, &rec); err != nil {
+				return errors.New("ERROR decoding " + kind + ": " + err.Error())
+			}
+			id, err := strconv.ParseInt(strings.SplitN(rec.PT, "-", 2)[0], 10, 64)
+			if err != nil {
+				return errors.New("ERROR: Invalid paging token: " + rec.PT)
+			}
+			ledger := int32(id >> 32)
+			if h.To > 0 && ledger > h.To {
+				return nil
+			}
+			if err = visit(raw, ledger); err == errStopWalk {
+				return nil
+			} else if err != nil {
+				return errors.New("ERROR decoding " + kind + ": " + err.Error())
+			}
+		}
+		next = page.Links.Next.Href
+	}
+}
@@ -0,0 +1,232 @@
+package payout
+
+import (
+	"strconv"
+	"testing"
+	"github.com/matheusb-comp/go/pool/fixtures"
+)
+
+func TestReconcile(t *testing.T) {
+	xlm := Asset{Type: ASSET_NATIVE}
+	plan := &Plan{Pool: pool, Credit: 2100, TxFee: 300, Payments: []Payment{
+		{Kind: PAYMENT_VOTER, Account: voterA, Destination: voterA, Amount: 1000},
+		{Kind: PAYMENT_DONATION, Destination: charity, Amount: 200, Donors: 1},
+		{Kind: PAYMENT_VOTER, Account: voterB, Destination: voterB, Amount: 500,
+			Asset: &usd, AssetAmount: 1000, SendMax: 505},
+	}}
+	paidA := OnChain{ID: "1", Type: "payment", Destination: voterA, Amount: 1000, Asset: xlm}
+	paidCharity := OnChain{ID: "2", Type: "payment", Destination: charity, Amount: 200, Asset: xlm}
+	paidB := OnChain{ID: "3", Type: "path_payment_strict_receive", Destination: voterB,
+		Amount: 1000, Asset: usd, Sent: 502}
+
+	// The balance change of each case is credited - debited - fees, and the
+	// pool is always credited 2100
+	tests := []struct {
+		name string
+		paid []OnChain
+		debited uint64
+		fees uint64
+		statuses map[string]int
+		difference int64
+		ok bool
+	}{
+		{
+			name: "all paid",
+			paid: []OnChain{paidA, paidCharity, paidB},
+			debited: 1702, fees: 300,
+			statuses: map[string]int{MATCH_OK: 3},
+			ok: true,
+		},
+		{
+			name: "in any order",
+			paid: []OnChain{paidB, paidA, paidCharity},
+			debited: 1702, fees: 300,
+			statuses: map[string]int{MATCH_OK: 3},
+			ok: true,
+		},
+		{
+			name: "missing payment",
+			paid: []OnChain{paidA, paidB},
+			debited: 1502, fees: 300,
+			statuses: map[string]int{MATCH_OK: 2, MATCH_MISSING: 1},
+		},
+		{
+			name: "amount mismatch",
+			paid: []OnChain{{ID: "1", Destination: voterA, Amount: 900, Asset: xlm}, paidCharity, paidB},
+			debited: 1602, fees: 300,
+			statuses: map[string]int{MATCH_OK: 2, MATCH_MISMATCH: 1},
+			difference: 100,
+		},
+		{
+			name: "paid twice",
+			paid: []OnChain{paidA, paidCharity, paidB, {ID: "4", Destination: voterA, Amount: 1000, Asset: xlm}},
+			debited: 2702, fees: 300,
+			statuses: map[string]int{MATCH_OK: 3, MATCH_DUPLICATE: 1},
+			difference: -1000,
+		},
+		{
+			name: "not planned",
+			paid: []OnChain{paidA, paidCharity, paidB, {ID: "4", Destination: gone, Amount: 50, Asset: xlm}},
+			debited: 1752, fees: 300,
+			statuses: map[string]int{MATCH_OK: 3, MATCH_UNEXPECTED: 1},
+			difference: -50,
+		},
+		{
+			name: "fees above the plan",
+			paid: []OnChain{paidA, paidCharity, paidB},
+			debited: 1702, fees: 400,
+			statuses: map[string]int{MATCH_OK: 3},
+			difference: -100,
+		},
+		{
+			name: "path payment delivering more",
+			paid: []OnChain{paidA, paidCharity, {ID: "3", Destination: voterB, Amount: 1010,
+				Asset: usd, Sent: 505}},
+			debited: 1705, fees: 300,
+			statuses: map[string]int{MATCH_OK: 3},
+			ok: true,
+		},
+		{
+			name: "path payment above the send max",
+			paid: []OnChain{paidA, paidCharity, {ID: "3", Destination: voterB, Amount: 1000,
+				Asset: usd, Sent: 510}},
+			debited: 1710, fees: 300,
+			statuses: map[string]int{MATCH_OK: 2, MATCH_MISMATCH: 1},
+		},
+		{
+			name: "paid in the wrong asset",
+			paid: []OnChain{{ID: "1", Destination: voterA, Amount: 1000, Asset: usd}, paidCharity, paidB},
+			debited: 702, fees: 300,
+			statuses: map[string]int{MATCH_OK: 2, MATCH_MISMATCH: 1},
+			difference: 1000,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Reconcile(plan, tt.paid, 2100, tt.debited, tt.fees)
+			statuses := map[string]int{}
+			problems := 0
+			for _, m := range r.Matches {
+				statuses[m.Status]++
+				if m.Status != MATCH_OK {
+					problems++
+				}
+			}
+			if len(statuses) != len(tt.statuses) {
+				t.Errorf("matches %v, want %v", statuses, tt.statuses)
+			}
+			for status, n := range tt.statuses {
+				if statuses[status] != n {
+					t.Errorf("%d %s, want %d", statuses[status], status, n)
+				}
+			}
+			if r.Problems != problems {
+				t.Errorf("%d problems, counted %d", r.Problems, problems)
+			}
+			if r.Difference != tt.difference || r.Difference != r.Observed - r.Expected {
+				t.Errorf("difference %d (observed %d, expected %d), want %d",
+					r.Difference, r.Observed, r.Expected, tt.difference)
+			}
+			if r.OK() != tt.ok {
+				t.Errorf("OK = %v, want %v", r.OK(), tt.ok)
+			}
+			if r.PlannedFees != plan.TxFee {
+				t.Errorf("planned fees %d, want %d", r.PlannedFees, plan.TxFee)
+			}
+		})
+	}
+}
+
+func TestPathPayment(t *testing.T) {
+	tests := []struct {
+		kind string
+		want bool
+	}{
+		{"path_payment", true},
+		{"path_payment_strict_receive", true},
+		{"path_payment_strict_send", true},
+		{"payment", false},
+		{"create_account", false},
+	}
+	for _, tt := range tests {
+		if got := pathPayment(tt.kind); got != tt.want {
+			t.Errorf("pathPayment(%q) = %v, want %v", tt.kind, got, tt.want)
+		}
+	}
+}
+
+// Page of the pool activity in the fake Horizon, followed by an empty one
+func activityPage(t *testing.T, h *fixtures.Horizon, kind string, records ...map[string]interface{}) {
+	for _, rec := range records {
+		rec["paging_token"] = strconv.FormatInt(int64(rec["ledger"].(int)) << 32 | 1, 10)
+	}
+	err := h.AddPage("/accounts/" + pool + "/" + kind, map[string]interface{}{
+		"_links": map[string]interface{}{"next": map[string]string{
+			"href": h.Client().URL + "/end/" + kind}},
+		"_embedded": map[string]interface{}{"records": records},
+	})
+	if err == nil {
+		err = h.AddPage("/end/" + kind, map[string]interface{}{
+			"_embedded": map[string]interface{}{"records": []interface{}{}}})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHorizonActivity(t *testing.T) {
+	h := fixtures.NewHorizon()
+	defer h.Close()
+	// The run paid in aa and bb, dd and cc (later) are other payments
+	tx := func(hash string, ledger int) map[string]interface{} {
+		return map[string]interface{}{"hash": hash, "ledger": ledger,
+			"source_account": pool, "fee_charged": "100"}
+	}
+	pay := func(hash string, ledger int, to, amount string) map[string]interface{} {
+		return map[string]interface{}{"transaction_hash": hash, "ledger": ledger,
+			"type": "payment", "from": pool, "to": to, "amount": amount, "asset_type": "native"}
+	}
+	activityPage(t, h, "transactions", tx("aa", 101), tx("dd", 101), tx("bb", 102), tx("cc", 105))
+	activityPage(t, h, "payments", pay("aa", 101, voterA, "0.0001000"),
+		pay("dd", 101, gone, "0.0000050"), pay("bb", 102, charity, "0.0000200"),
+		pay("cc", 105, gone, "0.0000050"))
+
+	tests := []struct {
+		name string
+		hashes map[string]bool
+		to int32
+		payments int
+		fees uint64
+	}{
+		{"the run's transactions", map[string]bool{"aa": true, "bb": true}, 102, 2, 200},
+		{"all the activity", nil, 0, 4, 400},
+		{"nothing on-chain", map[string]bool{"zz": true}, 100, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &HorizonActivity{Client: h.Client(), Pool: pool, From: 100, Hashes: tt.hashes}
+			if len(tt.hashes) > 0 {
+				if err := a.ToLastLedger(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if a.To != tt.to {
+				t.Errorf("to ledger %d, want %d", a.To, tt.to)
+			}
+			paid, err := a.Payments()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(paid) != tt.payments {
+				t.Errorf("%d payments, want %d: %+v", len(paid), tt.payments, paid)
+			}
+			fees, err := a.Fees()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fees != tt.fees {
+				t.Errorf("fees %d, want %d", fees, tt.fees)
+			}
+		})
+	}
+}
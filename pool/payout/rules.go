@@ -0,0 +1,185 @@
+package payout
+
+import (
+	"flag"
+	"time"
+	"errors"
+	"strings"
+	"net/http"
+	"io/ioutil"
+	"encoding/json"
+	"github.com/stellar/go/clients/horizon"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+// Reasons for a voter not being paid in a run
+const (
+	REASON_EXCLUDED = "excluded"
+	REASON_MIN_BALANCE = "balance below minimum"
+	REASON_MIN_AGE = "account too new"
+	REASON_MIN_PAYOUT = "payout below minimum (carried over)"
+	// Owed from previous runs, but not in the snapshot anymore
+	REASON_NOT_VOTING = "not voting for the pool"
+)
+
+// Voter left out of the payments of a run, and why. The owed amount (from
+// previous runs) is still paid, unless it's below the minimum payout. A
+// carried amount is owed to the account, to be added to its next payout
+type Exclusion struct {
+	Account string `json:"account"`
+	Balance uint64 `json:"balance"`
+	Reason string `json:"reason"`
+	Owed uint64 `json:"owed,omitempty"`
+	Carried uint64 `json:"carried,omitempty"`
+	// Kind of the payment carried for a missing destination
+	Kind string `json:"kind,omitempty"`
+}
+
+// Set of accounts given as a comma separated list, or as @<file> with one
+// account per line (exchange lists can be long)
+type AccountSet map[string]bool
+
+func (s *AccountSet) String() string {
+	var list []string
+	for account := range *s {
+		list = append(list, account)
+	}
+	return strings.Join(list, ",")
+}
+
+// Replace the set (the config loader resets a flag with its default)
+func (s *AccountSet) Set(value string) error {
+	set := AccountSet{}
+	if strings.HasPrefix(value, "@") {
+		b, err := ioutil.ReadFile(value[1:])
+		if err != nil {
+			return errors.New("ERROR reading accounts file: " + err.Error())
+		}
+		value = strings.Replace(string(b), "\n", ",", -1)
+	}
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v == "" || strings.HasPrefix(v, "#") {
+			continue
+		}
+		if len(v) != 56 || v[0] != 'G' {
+			return errors.New("ERROR: Invalid account in list: " + v)
+		}
+		set[v] = true
+	}
+	*s = set
+	return nil
+}
+
+// Amount flag in XLM, kept in stroops
+type amountValue uint64
+
+func (a *amountValue) String() string {
+	return snapshot.FormatAmount(uint64(*a))
+}
+
+func (a *amountValue) Set(value string) error {
+	v, err := snapshot.ParseAmount(value)
+	if err != nil {
+		return err
+	}
+	*a = amountValue(v)
+	return nil
+}
+
+// Define the flags of the payout rules in the flag set
+func (r *Rules) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&r.DonationKey, "key", "lumenaut.net donation%",
+		"Format of key for a voter data pair to mark a donation")
+
+	fs.Uint64Var(&r.BaseFee, "base-fee", DEFAULT_BASE_FEE,
+		"Fee per operation, in stroops")
+
+	fs.Var((*amountValue)(&r.MinBalance), "min-balance",
+		"Minimum balance (XLM) of a voter to be paid")
+
+	fs.Var((*amountValue)(&r.MinPayout), "min-payout",
+		"Minimum payment (XLM). Smaller payouts are carried over to the next run")
+
+	fs.Var(&r.Include, "include",
+		"Accounts always paid, whatever their balance and age " +
+		"(comma separated, or @<file> with one per line)")
+
+	fs.Var(&r.Exclude, "exclude",
+		"Accounts never paid, like exchanges (comma separated, or @<file> with one per line)")
+
+	r.MinCreate = DEFAULT_MIN_CREATE
+	fs.Var((*amountValue)(&r.MinCreate), "min-create",
+		"Smallest payment (XLM) that creates a missing destination account " +
+		"with create_account. Smaller ones are carried over")
+
+	fs.BoolVar(&r.SplitDonations, "split-donations", false,
+		"Pay each donation apart, instead of one payment per destination for all the voters")
+
+	fs.DurationVar(&r.MinAge, "min-age", 0,
+		"Minimum age of a voter account to be paid (for example, 168h)")
+
+	r.FeeModel.RegisterFlags(fs)
+}
+
+// Why the voter is not eligible for a payout (empty if it is)
+func (r *Rules) Eligible(e snapshot.Entry) (string, error) {
+	if r.Exclude[e.ID] {
+		return REASON_EXCLUDED, nil
+	}
+	if r.Include[e.ID] {
+		return "", nil
+	}
+	if e.Bal < r.MinBalance {
+		return REASON_MIN_BALANCE, nil
+	}
+	if r.MinAge > 0 {
+		if r.Created == nil {
+			return "", errors.New("ERROR: Minimum age set without a way to get the account creation")
+		}
+		created, err := r.Created(e.ID)
+		if err != nil {
+			return "", err
+		}
+		now := r.Now
+		if now.IsZero() {
+			now = time.Now()
+		}
+		if now.Sub(created) < r.MinAge {
+			return REASON_MIN_AGE, nil
+		}
+	}
+	return "", nil
+}
+
+// Creation time of the accounts, from their first operation in Horizon
+func HorizonCreated(client *horizon.Client) func(account string) (time.Time, error) {
+	return func(account string) (time.Time, error) {
+		var page struct {
+			Embedded struct {
+				Records []struct {
+					CreatedAt time.Time `json:"created_at"`
+				} `json:"records"`
+			} `json:"_embedded"`
+		}
+		url := client.URL + "/accounts/" + account + "/operations?order=asc&limit=1"
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return time.Time{}, err
+		}
+		resp, err := client.HTTP.Do(req)
+		if err != nil {
+			return time.Time{}, errors.New("ERROR getting the creation of " + account + ": " + err.Error())
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return time.Time{}, errors.New("ERROR getting the creation of " + account + ": " + resp.Status)
+		}
+		if err = json.NewDecoder(resp.Body).Decode(&page); err != nil {
+			return time.Time{}, errors.New("ERROR decoding operations of " + account + ": " + err.Error())
+		}
+		if len(page.Embedded.Records) == 0 {
+			return time.Time{}, errors.New("ERROR: No operations for " + account)
+		}
+		return page.Embedded.Records[0].CreatedAt, nil
+	}
+}
@@ -0,0 +1,98 @@
+package payout
+
+import (
+	"errors"
+	"github.com/stellar/go/xdr"
+	"github.com/stellar/go/build"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+// Payout transaction, built but not signed (see Sign)
+type Tx struct {
+	Hash string `json:"hash"`
+	Sequence uint64 `json:"sequence"`
+	Operations int `json:"operations"`
+	Fee uint64 `json:"fee"`
+	Amount uint64 `json:"amount"`
+	// Base64 XDR of the unsigned envelope
+	Envelope string `json:"envelope_xdr,omitempty"`
+	Payments []Payment `json:"-"`
+}
+
+// Build the payout transactions from the pool account, one per batch of
+// payments, with consecutive sequence numbers after the current one. The
+// memo is the Merkle root of the snapshot, so voters can check their
+// inclusion proof against it
+func (p *Plan) Build(r *Rules, sequence uint64, memo [32]byte) ([]Tx, error) {
+	r.defaults()
+	var txs []Tx
+	for i, batch := range p.Batches(r.MaxOps) {
+		t := Tx{Sequence: sequence + uint64(i) + 1, Operations: len(batch),
+			Fee: r.Fee(len(batch)), Payments: batch}
+
+		muts := []build.TransactionMutator{
+			build.SourceAccount{AddressOrSeed: p.Pool},
+			build.Sequence{Sequence: t.Sequence},
+			build.Network{Passphrase: p.Network},
+			build.BaseFee{Amount: r.BaseFee},
+			build.MemoHash{Value: xdr.Hash(memo)},
+		}
+		for _, pay := range batch {
+			t.Amount += pay.Amount
+			dest := build.Destination{AddressOrSeed: pay.Destination}
+			amount := build.NativeAmount{Amount: snapshot.FormatAmount(pay.Amount)}
+			switch {
+			case pay.Create:
+				muts = append(muts, build.CreateAccount(dest, amount))
+			case pay.Asset != nil && pay.SendMax > 0:
+				// Delivers the asset amount, sending at most SendMax XLM
+				credit := build.CreditAmount{Code: pay.Asset.Code, Issuer: pay.Asset.Issuer,
+					Amount: snapshot.FormatAmount(pay.AssetAmount)}
+				muts = append(muts, build.Payment(dest, credit,
+					build.PayWith(build.NativeAsset(), snapshot.FormatAmount(pay.SendMax))))
+			case pay.Asset != nil:
+				muts = append(muts, build.Payment(dest, build.CreditAmount{Code: pay.Asset.Code,
+					Issuer: pay.Asset.Issuer, Amount: snapshot.FormatAmount(pay.AssetAmount)}))
+			default:
+				muts = append(muts, build.Payment(dest, amount))
+			}
+		}
+
+		tx, err := build.Transaction(muts...)
+		if err != nil {
+			return nil, errors.New("ERROR building transaction: " + err.Error())
+		}
+		if t.Hash, err = tx.HashHex(); err != nil {
+			return nil, errors.New("ERROR hashing transaction: " + err.Error())
+		}
+		// Signing with no keys gives the unsigned envelope
+		env, err := tx.Sign()
+		if err == nil {
+			t.Envelope, err = env.Base64()
+		}
+		if err != nil {
+			return nil, errors.New("ERROR encoding transaction: " + err.Error())
+		}
+		txs = append(txs, t)
+	}
+	return txs, nil
+}
+
+// Sign a transaction built by Build, and return the signed envelope
+// (base64) to submit
+func (t *Tx) Sign(seed, passphrase string) (string, error) {
+	var env xdr.TransactionEnvelope
+	if err := xdr.SafeUnmarshalBase64(t.Envelope, &env); err != nil {
+		return "", errors.New("ERROR decoding transaction: " + err.Error())
+	}
+	tx := &build.TransactionBuilder{TX: &env.Tx, NetworkPassphrase: passphrase}
+	signed, err := tx.Sign(seed)
+	if err != nil {
+		return "", errors.New("ERROR signing transaction: " + err.Error())
+	}
+	b64, err := signed.Base64()
+	if err != nil {
+		return "", errors.New("ERROR encoding transaction: " + err.Error())
+	}
+	return b64, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"flag"
+	"errors"
+	"strings"
+	"io/ioutil"
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/clients/horizon"
+	"github.com/matheusb-comp/go/pool/config"
+	"github.com/matheusb-comp/go/pool/payout"
+	"github.com/matheusb-comp/go/pool/getvoters"
+)
+
+// Set the source of the fee statistics named in the flags. The returned
+// function closes the core DB connection, if one was opened
+func feeSource(f *payout.FeeStrategy, client *horizon.Client,
+	cfg *getvoters.ConnConfig, pool, key string) (func(), error) {
+	switch f.Name {
+	case payout.FEE_FIXED:
+	case payout.FEE_HORIZON:
+		f.Source = &payout.HorizonFees{Client: client}
+	case payout.FEE_CORE:
+		conn, err := getvoters.NewDBconnConfig(cfg, pool, key)
+		if err != nil {
+			return nil, err
+		}
+		f.Source = &payout.CoreFees{Conn: conn, Ledgers: f.Ledgers}
+		return func() { conn.Close() }, nil
+	default:
+		return nil, errors.New("ERROR: Unknown fee source: " + f.Name)
+	}
+	return func() {}, nil
+}
+
+// Wrap a signed payout transaction in a fee-bump envelope, paid by another
+// account, so a transaction stuck during surge pricing can be resubmitted
+// without a new sequence number
+func bump(args []string) int {
+	fs := flag.NewFlagSet("bump", flag.ContinueOnError)
+	feeSeed := fs.String("fee-seed", "",
+		"Secret seed of the account paying the fee. Prefer " + ENV_PREFIX + "_FEE_SEED_FILE, " +
+		"so the seed doesn't show up in the process list")
+	fee := fs.Uint64("fee", 0,
+		"Fee per operation, in stroops (default: from -fee-source, capped at -max-fee)")
+	horizonURL := fs.String("horizon", "",
+		"Horizon server to get the fee stats (default: the one of the network)")
+	var strategy payout.FeeStrategy
+	strategy.RegisterFlags(fs)
+	var network config.Network
+	network.RegisterFlags(fs)
+	var dbConfig getvoters.ConnConfig
+	dbConfig.RegisterFlags(fs)
+	if !parseFlags(fs, args) || fs.NArg() != 1 || *feeSeed == "" {
+		fmt.Fprintln(os.Stderr, "Usage: poolctl bump -fee-seed <S...> [-fee <stroops>] <envelope base64 | @file>")
+		return EXIT_USAGE
+	}
+	passphrase, err := network.Passphrase()
+	if err != nil {
+		return fail(err)
+	}
+	envelope := fs.Arg(0)
+	if strings.HasPrefix(envelope, "@") {
+		b, err := ioutil.ReadFile(envelope[1:])
+		if err != nil {
+			return fail(err)
+		}
+		envelope = strings.TrimSpace(string(b))
+	}
+
+	if *fee == 0 {
+		client, err := network.Client(*horizonURL)
+		if err != nil {
+			return fail(err)
+		}
+		// The core DB connection only needs a valid address as the pool
+		kp, err := keypair.Parse(*feeSeed)
+		if err != nil {
+			return fail(errors.New("ERROR parsing the fee account seed: " + err.Error()))
+		}
+		done, err := feeSource(&strategy, client, &dbConfig, kp.Address(), "")
+		if err != nil {
+			return fail(err)
+		}
+		rules := payout.Rules{BaseFee: payout.DEFAULT_BASE_FEE}
+		_, err = strategy.Apply(&rules)
+		done()
+		if err != nil {
+			return fail(err)
+		}
+		*fee = rules.BaseFee
+	}
+
+	bumped, hash, err := payout.FeeBump(envelope, *feeSeed, passphrase, *fee)
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Fprintf(os.Stderr, "Fee-bump %s: %d stroops per operation\n", hash, *fee)
+	fmt.Println(bumped)
+	return EXIT_OK
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"flag"
+	"sort"
+	"errors"
+	"strings"
+	"io/ioutil"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/matheusb-comp/go/pool/config"
+	"github.com/matheusb-comp/go/pool/getvoters"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+// Process exit status codes
+const EXIT_OK = 0
+const EXIT_ERROR = 1
+const EXIT_USAGE = 2
+
+// Prefix of the environment variables overriding the options
+const ENV_PREFIX = "POOLCTL"
+
+// A subcommand receives its arguments (without the name) and returns the exit status
+type command struct {
+	run func(args []string) int
+	help string
+}
+
+var commands = map[string]command{
+	"bump": {bump, "Wrap a stuck payout transaction in a fee-bump paid by another account"},
+	"diff": {diff, "Compare two snapshot files (or a file and the live DB)"},
+	"proof": {proof, "Check a voter inclusion proof (as served in /proof/<account>)"},
+	"reconcile": {reconcile, "Match the payments of the pool on-chain with a payout plan"},
+	"report": {auditReport, "Write the audit report of a run (Markdown or HTML)"},
+	"sign": {sign, "Sign a snapshot file with a Stellar secret seed"},
+	"simulate": {simulate, "Calculate the payouts of an inflation and build the transactions, without submitting"},
+	"verify": {verify, "Check the signatures and totals of a snapshot file"},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(EXIT_USAGE)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Unknown command:", os.Args[1])
+		usage()
+		os.Exit(EXIT_USAGE)
+	}
+	os.Exit(cmd.run(os.Args[2:]))
+}
+
+func usage() {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "Usage: poolctl <command> [flags] [args]\n\nCommands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].help)
+	}
+}
+
+// Parse the flags of a subcommand, applying the POOLCTL_<OPTION> variables
+// (and <OPTION>_FILE secrets) to the ones not given
+func parseFlags(fs *flag.FlagSet, args []string) bool {
+	if err := fs.Parse(args); err != nil {
+		return false
+	}
+	loader, err := config.NewLoader(fs, "", ENV_PREFIX)
+	if err == nil {
+		err = loader.Load()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+	return true
+}
+
+func fail(err error) int {
+	fmt.Fprintln(os.Stderr, err)
+	return EXIT_ERROR
+}
+
+func sign(args []string) int {
+	fs := flag.NewFlagSet("sign", flag.ContinueOnError)
+	seed := fs.String("seed", "",
+		"Secret seed of the signer. Prefer " + ENV_PREFIX + "_SEED_FILE, " +
+		"so the seed doesn't show up in the process list")
+	out := fs.String("out", "", "File to write the signed snapshot (default: overwrite the input)")
+	if !parseFlags(fs, args) || fs.NArg() != 1 || *seed == "" {
+		fmt.Fprintln(os.Stderr, "Usage: poolctl sign -seed <S...> [-out <file>] <snapshot.json>")
+		return EXIT_USAGE
+	}
+
+	s, err := snapshot.ReadFile(fs.Arg(0))
+	if err != nil {
+		return fail(err)
+	}
+	if err = s.Sign(*seed); err != nil {
+		return fail(err)
+	}
+	if *out == "" {
+		*out = fs.Arg(0)
+	}
+	if err = snapshot.WriteFile(*out, s); err != nil {
+		return fail(err)
+	}
+	return EXIT_OK
+}
+
+func verify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	signers := fs.String("signers", "",
+		"Comma separated public keys that must have signed the snapshot")
+	var network config.Network
+	network.RegisterFlags(fs)
+	if !parseFlags(fs, args) || fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: poolctl verify [-signers <G...,G...>] " +
+			"[-network <name>] <snapshot.json>")
+		return EXIT_USAGE
+	}
+	passphrase, err := network.Passphrase()
+	if err != nil {
+		return fail(err)
+	}
+
+	s, err := snapshot.ReadFile(fs.Arg(0))
+	if err != nil {
+		return fail(err)
+	}
+	// A snapshot of another network can't be used to pay on this one
+	if s.Network != passphrase {
+		return fail(errors.New("ERROR: Snapshot of another network: " + s.Network))
+	}
+	var required []string
+	if *signers != "" {
+		required = strings.Split(*signers, ",")
+	}
+	if err = s.Verify(required...); err != nil {
+		return fail(err)
+	}
+
+	hash, err := s.HashHex()
+	if err != nil {
+		return fail(err)
+	}
+	root, err := s.MerkleRoot()
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Println("Hash:", hash)
+	fmt.Println("Merkle root:", hex.EncodeToString(root[:]))
+	fmt.Println("Ledger:", s.Ledger, "- Pool:", s.Pool, "- Network:", s.Network)
+	fmt.Println("Voters:", s.Totals.Voters, "- Votes:", snapshot.FormatAmount(s.Totals.Votes),
+		"- Credit:", snapshot.FormatAmount(s.Credit))
+	for _, sig := range s.Signatures {
+		fmt.Println("Signed by:", sig.Signer)
+	}
+	if len(s.Signatures) == 0 {
+		fmt.Println("Not signed (only the totals were checked)")
+	}
+	return EXIT_OK
+}
+
+func proof(args []string) int {
+	fs := flag.NewFlagSet("proof", flag.ContinueOnError)
+	root := fs.String("root", "",
+		"Merkle root the proof must lead to (for example, from the payout memo)")
+	if !parseFlags(fs, args) || fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: poolctl proof [-root <hex>] <proof.json>")
+		return EXIT_USAGE
+	}
+
+	b, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return fail(err)
+	}
+	var p snapshot.Proof
+	if err = json.Unmarshal(b, &p); err != nil {
+		return fail(err)
+	}
+	if *root != "" && !strings.EqualFold(*root, p.Root) {
+		return fail(fmt.Errorf("ERROR: Proof is for root %s, not %s", p.Root, *root))
+	}
+	if err = snapshot.VerifyProof(&p); err != nil {
+		return fail(err)
+	}
+
+	fmt.Println("Account", p.Entry.ID, "with balance", snapshot.FormatAmount(p.Entry.Bal),
+		"is in the snapshot of ledger", p.Ledger, "(root " + p.Root + ")")
+	return EXIT_OK
+}
+
+func diff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	format := fs.String("format", "table", "Output format (table, json or csv)")
+	live := fs.Bool("live", false,
+		"Compare the snapshot file with the voters in the core DB right now")
+	pool := fs.String("pool", "", "Pool address to get the live voters (default: the file's pool)")
+	key := fs.String("key", "lumenaut.net donation%",
+		"Format of key for a voter data pair to mark a donation (live voters)")
+	var dbConfig getvoters.ConnConfig
+	dbConfig.RegisterFlags(fs)
+	if !parseFlags(fs, args) || (*live && fs.NArg() != 1) || (!*live && fs.NArg() != 2) {
+		fmt.Fprintln(os.Stderr, "Usage: poolctl diff [-format table|json|csv] <old.json> <new.json>")
+		fmt.Fprintln(os.Stderr, "       poolctl diff -live [DB flags] <old.json>")
+		return EXIT_USAGE
+	}
+
+	older, err := snapshot.ReadFile(fs.Arg(0))
+	if err != nil {
+		return fail(err)
+	}
+	var newer *snapshot.Snapshot
+	if *live {
+		if *pool == "" {
+			*pool = older.Pool
+		}
+		newer, err = liveSnapshot(&dbConfig, older.Network, *pool, *key)
+	} else {
+		newer, err = snapshot.ReadFile(fs.Arg(1))
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	d := snapshot.Compare(older, newer)
+	switch *format {
+	case "table":
+		err = d.WriteTable(os.Stdout)
+	case "csv":
+		err = d.WriteCSV(os.Stdout)
+	case "json":
+		js := json.NewEncoder(os.Stdout)
+		js.SetIndent("", "  ")
+		err = js.Encode(d)
+	default:
+		fmt.Fprintln(os.Stderr, "Unknown format:", *format)
+		return EXIT_USAGE
+	}
+	if err != nil {
+		return fail(err)
+	}
+	return EXIT_OK
+}
+
+// Snapshot of the voters in the core DB right now (no ledger or credit, see
+// IsLive), on the network the core DB is taken to be of
+func liveSnapshot(cfg *getvoters.ConnConfig, network, pool, key string) (*snapshot.Snapshot, error) {
+	conn, err := getvoters.NewDBconnConfig(cfg, pool, key)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	data, err := conn.GetVoters()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := data.Entries()
+	if err != nil {
+		return nil, err
+	}
+	return snapshot.New(network, pool, 0, 0, entries), nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"flag"
+	"errors"
+	"io/ioutil"
+	"encoding/json"
+	"github.com/matheusb-comp/go/pool/config"
+	"github.com/matheusb-comp/go/pool/payout"
+	"github.com/matheusb-comp/go/pool/history"
+	"github.com/matheusb-comp/go/pool/getvoters"
+)
+
+// Check the payments of the pool on-chain against a payout plan. Exits with
+// an error status if anything doesn't match, so it can run from cron
+func reconcile(args []string) int {
+	fs := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	format := fs.String("format", "table", "Output format (table or json)")
+	historyConn := fs.String("history", "",
+		"PostgreSQL connection string of the history DB, to take the plan of a recorded run")
+	pool := fs.String("pool", "", "Pool address of the run (with -history)")
+	ledger := fs.Int("ledger", 0, "Inflation ledger of the run (with -history)")
+	from := fs.Int("from", 0, "First ledger to look at (default: the inflation ledger)")
+	to := fs.Int("to", 0,
+		"Last ledger to look at (default: the last one with a transaction of the run)")
+	horizonURL := fs.String("horizon", "",
+		"Horizon server to get the payments (default: the one of the network)")
+	var network config.Network
+	network.RegisterFlags(fs)
+	if !parseFlags(fs, args) {
+		return EXIT_USAGE
+	}
+	fromHistory := *historyConn != ""
+	if (fromHistory && (*pool == "" || *ledger == 0 || fs.NArg() != 0)) ||
+		(!fromHistory && fs.NArg() != 1) {
+		fmt.Fprintln(os.Stderr, "Usage: poolctl reconcile [-from <ledger>] [-to <ledger>] <plan.json>")
+		fmt.Fprintln(os.Stderr, "       poolctl reconcile -history <conn> -pool <G...> -ledger <n>")
+		return EXIT_USAGE
+	}
+
+	// The plan and the hashes of its transactions: recorded with the run,
+	// or saved by simulate -format json
+	var plan *payout.Plan
+	var hashes map[string]bool
+	var err error
+	if fromHistory {
+		plan, hashes, err = planFromHistory(*historyConn, *pool, int32(*ledger))
+	} else {
+		plan, hashes, err = readPlan(fs.Arg(0))
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if *from == 0 {
+		*from = int(plan.Ledger)
+	}
+
+	client, err := network.Client(*horizonURL)
+	if err != nil {
+		return fail(err)
+	}
+	activity := &payout.HorizonActivity{Client: client, Pool: plan.Pool,
+		From: int32(*from), To: int32(*to), Hashes: hashes}
+	if len(hashes) == 0 {
+		fmt.Fprintln(os.Stderr, "No transactions known for the plan: matching all the " +
+			"payments of the pool in the range")
+	} else if *to == 0 {
+		if err = activity.ToLastLedger(); err != nil {
+			return fail(err)
+		}
+	}
+	paid, err := activity.Payments()
+	if err != nil {
+		return fail(err)
+	}
+	credited, debited, err := activity.Balance()
+	if err != nil {
+		return fail(err)
+	}
+	fees, err := activity.Fees()
+	if err != nil {
+		return fail(err)
+	}
+	r := payout.Reconcile(plan, paid, credited, debited, fees)
+	r.From, r.To = activity.From, activity.To
+
+	switch *format {
+	case "table":
+		err = r.WriteTable(os.Stdout)
+	case "json":
+		js := json.NewEncoder(os.Stdout)
+		js.SetIndent("", "  ")
+		err = js.Encode(r)
+	default:
+		fmt.Fprintln(os.Stderr, "Unknown format:", *format)
+		return EXIT_USAGE
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if !r.OK() {
+		return fail(errors.New("ERROR: The payments on-chain don't reconcile with the plan"))
+	}
+	return EXIT_OK
+}
+
+// Plan recorded with a run in the history DB, and the transactions
+// journaled for it
+func planFromHistory(conn, pool string, ledger int32) (*payout.Plan, map[string]bool, error) {
+	store, err := history.Open(&getvoters.ConnConfig{Conn: conn})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer store.Close()
+	run, err := store.GetRun(pool, ledger)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(run.Plan) == 0 {
+		return nil, nil, errors.New("ERROR: No payout plan recorded for the run")
+	}
+	var plan payout.Plan
+	if err = json.Unmarshal(run.Plan, &plan); err != nil {
+		return nil, nil, errors.New("ERROR decoding the payout plan: " + err.Error())
+	}
+	hashes := map[string]bool{}
+	for _, t := range run.Transactions {
+		hashes[t.Hash] = true
+	}
+	return &plan, hashes, nil
+}
+
+// Plan in a file, as printed by simulate -format json (with the
+// transactions built), or on its own
+func readPlan(name string) (*payout.Plan, map[string]bool, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	var sim simulation
+	if err = json.Unmarshal(b, &sim); err == nil && sim.Plan != nil {
+		hashes := map[string]bool{}
+		for _, t := range sim.Transactions {
+			hashes[t.Hash] = true
+		}
+		return sim.Plan, hashes, nil
+	}
+	var plan payout.Plan
+	if err = json.Unmarshal(b, &plan); err != nil {
+		return nil, nil, errors.New("ERROR decoding the payout plan: " + err.Error())
+	}
+	return &plan, nil, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"flag"
+	"github.com/matheusb-comp/go/pool/config"
+	"github.com/matheusb-comp/go/pool/report"
+	"github.com/matheusb-comp/go/pool/payout"
+	"github.com/matheusb-comp/go/pool/history"
+	"github.com/matheusb-comp/go/pool/getvoters"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+// Write the audit report of a run, from the history DB (with the recorded
+// plan and transactions) or from a snapshot file
+func auditReport(args []string) int {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	format := fs.String("format", "md", "Output format (md or html)")
+	historyConn := fs.String("history", "",
+		"PostgreSQL connection string of the history DB, to report a recorded run")
+	pool := fs.String("pool", "", "Pool address of the run (with -history)")
+	ledger := fs.Int("ledger", 0, "Inflation ledger of the run (with -history)")
+	txURL := fs.String("tx-url", "",
+		"Link of the transactions, with " + report.TX_URL_HASH + " in place of the hash " +
+		"(default: the transaction in horizon)")
+	horizonURL := fs.String("horizon", "", "Horizon server of the default links")
+	var rules payout.Rules
+	rules.RegisterFlags(fs)
+	var network config.Network
+	network.RegisterFlags(fs)
+	if !parseFlags(fs, args) {
+		return EXIT_USAGE
+	}
+	fromHistory := *historyConn != ""
+	if (fromHistory && (*pool == "" || *ledger == 0 || fs.NArg() != 0)) ||
+		(!fromHistory && fs.NArg() != 1) {
+		fmt.Fprintln(os.Stderr, "Usage: poolctl report [-format md|html] <snapshot.json>")
+		fmt.Fprintln(os.Stderr, "       poolctl report -history <conn> -pool <G...> -ledger <n>")
+		return EXIT_USAGE
+	}
+	if *txURL == "" {
+		client, err := network.Client(*horizonURL)
+		if err != nil {
+			return fail(err)
+		}
+		*txURL = client.URL + "/transactions/" + report.TX_URL_HASH
+	}
+
+	var r *report.Report
+	if fromHistory {
+		store, err := history.Open(&getvoters.ConnConfig{Conn: *historyConn})
+		if err != nil {
+			return fail(err)
+		}
+		defer store.Close()
+		if r, err = report.FromHistory(store, *pool, int32(*ledger), *txURL); err != nil {
+			return fail(err)
+		}
+	} else {
+		s, err := snapshot.ReadFile(fs.Arg(0))
+		if err != nil {
+			return fail(err)
+		}
+		plan, err := payout.Calculate(s, &rules)
+		if err != nil {
+			return fail(err)
+		}
+		if r, err = report.New(s, plan, nil, *txURL); err != nil {
+			return fail(err)
+		}
+	}
+
+	var err error
+	switch *format {
+	case "md":
+		err = r.WriteMarkdown(os.Stdout)
+	case "html":
+		err = r.WriteHTML(os.Stdout)
+	default:
+		fmt.Fprintln(os.Stderr, "Unknown format:", *format)
+		return EXIT_USAGE
+	}
+	if err != nil {
+		return fail(err)
+	}
+	return EXIT_OK
+}
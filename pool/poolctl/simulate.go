@@ -0,0 +1,261 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"flag"
+	"errors"
+	"encoding/json"
+	"github.com/matheusb-comp/go/pool/config"
+	"github.com/matheusb-comp/go/pool/payout"
+	"github.com/matheusb-comp/go/pool/history"
+	"github.com/matheusb-comp/go/pool/getvoters"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+// Result of a simulation, as printed with -format json
+type simulation struct {
+	Plan *payout.Plan `json:"plan"`
+	Transactions []payout.Tx `json:"transactions"`
+}
+
+// Run the payout calculation and build the transactions of an inflation,
+// without signing or submitting anything
+func simulate(args []string) int {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	format := fs.String("format", "table", "Output format (table or json)")
+	credit := fs.String("credit", "",
+		"Hypothetical inflation credit of the pool, in XLM (default: the snapshot's)")
+	live := fs.Bool("live", false, "Take the snapshot from the core DB right now")
+	pool := fs.String("pool", "", "Pool address to get the live voters")
+	sequence := fs.Int64("sequence", -1,
+		"Current sequence number of the pool account (default: ask Horizon)")
+	horizonURL := fs.String("horizon", "",
+		"Horizon server to get the sequence number and the account ages " +
+		"(default: the one of the network)")
+	showXDR := fs.Bool("xdr", false, "Print the unsigned transaction envelopes")
+	check := fs.String("check", "",
+		"Check the payment destinations right before building (core or horizon). " +
+		"Missing ones are created or carried over. With -asset, the trustlines " +
+		"are checked in the same place (default: horizon)")
+	historyConn := fs.String("history", "",
+		"Optional PostgreSQL connection string of the history DB, to add the " +
+		"amounts carried over from previous runs (nothing is recorded)")
+	var rules payout.Rules
+	rules.RegisterFlags(fs)
+	var strategy payout.FeeStrategy
+	strategy.RegisterFlags(fs)
+	var assetRules payout.AssetRules
+	assetRules.RegisterFlags(fs)
+	var network config.Network
+	network.RegisterFlags(fs)
+	var dbConfig getvoters.ConnConfig
+	dbConfig.RegisterFlags(fs)
+	if !parseFlags(fs, args) || (*live && (*pool == "" || fs.NArg() != 0)) ||
+		(!*live && fs.NArg() != 1) {
+		fmt.Fprintln(os.Stderr, "Usage: poolctl simulate [-credit <XLM>] [-format table|json] <snapshot.json>")
+		fmt.Fprintln(os.Stderr, "       poolctl simulate -live -pool <G...> -credit <XLM> [DB flags]")
+		return EXIT_USAGE
+	}
+	passphrase, err := network.Passphrase()
+	if err != nil {
+		return fail(err)
+	}
+
+	// The snapshot to distribute the credit to
+	var s *snapshot.Snapshot
+	if *live {
+		s, err = liveSnapshot(&dbConfig, passphrase, *pool, rules.DonationKey)
+	} else {
+		s, err = snapshot.ReadFile(fs.Arg(0))
+		if err == nil && s.Network != passphrase {
+			err = errors.New("ERROR: Snapshot of another network: " + s.Network)
+		}
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if *credit != "" {
+		if s.Credit, err = snapshot.ParseAmount(*credit); err != nil {
+			return fail(err)
+		}
+	}
+	if s.Credit == 0 {
+		return fail(errors.New("ERROR: No credit to distribute (set -credit)"))
+	}
+
+	if *historyConn != "" {
+		if err = owedFromHistory(*historyConn, s.Pool, &rules); err != nil {
+			return fail(err)
+		}
+	}
+	client, err := network.Client(*horizonURL)
+	if err != nil {
+		return fail(err)
+	}
+	if rules.MinAge > 0 {
+		rules.Created = payout.HorizonCreated(client)
+	}
+
+	// The fee per operation, estimated from the recent ledgers
+	done, err := feeSource(&strategy, client, &dbConfig, s.Pool, rules.DonationKey)
+	if err != nil {
+		return fail(err)
+	}
+	stats, err := strategy.Apply(&rules)
+	done()
+	if err != nil {
+		return fail(err)
+	}
+	assetRules.Reserve(&rules)
+	plan, err := payout.Calculate(s, &rules)
+	if err != nil {
+		return fail(err)
+	}
+	plan.FeeStats = stats
+
+	// Check the destinations, as the payout would right before building
+	var checker payout.Checker
+	var trustlines payout.Trustlines = &payout.HorizonTrustlines{Client: client}
+	switch *check {
+	case "":
+	case "horizon":
+		checker = &payout.HorizonChecker{Client: client}
+	case "core":
+		conn, err := getvoters.NewDBconnConfig(&dbConfig, s.Pool, rules.DonationKey)
+		if err != nil {
+			return fail(err)
+		}
+		defer conn.Close()
+		checker = conn
+		trustlines = coreTrustlines{conn}
+	default:
+		fmt.Fprintln(os.Stderr, "Unknown destination check:", *check)
+		return EXIT_USAGE
+	}
+	if checker != nil {
+		decisions, err := plan.CheckDestinations(checker, &rules)
+		if err != nil {
+			return fail(err)
+		}
+		logDecisions(decisions)
+	}
+
+	// Pay the voters with trustlines in the asset, the others in XLM
+	if !assetRules.Asset.Native() {
+		if assetRules.Rate == 0 && assetRules.Mode == payout.ASSET_PATH {
+			if assetRules.Rate, err = payout.HorizonRate(client, assetRules.Asset); err != nil {
+				return fail(err)
+			}
+		}
+		decisions, err := plan.PayInAsset(trustlines, &assetRules, &rules)
+		if err != nil {
+			return fail(err)
+		}
+		logFallbacks(decisions)
+	}
+
+	// Build the transactions as they would be submitted
+	if *sequence < 0 {
+		seq, err := client.SequenceForAccount(s.Pool)
+		if err != nil {
+			return fail(errors.New("ERROR getting the pool sequence number: " + err.Error()))
+		}
+		*sequence = int64(seq)
+	}
+	root, err := s.MerkleRoot()
+	if err != nil {
+		return fail(err)
+	}
+	txs, err := plan.Build(&rules, uint64(*sequence), root)
+	if err != nil {
+		return fail(err)
+	}
+
+	switch *format {
+	case "table":
+		err = plan.WriteTable(os.Stdout)
+		for _, t := range txs {
+			if err != nil {
+				break
+			}
+			_, err = fmt.Printf("\nTransaction %s (sequence %d): %d operations, %s XLM, fee %s\n",
+				t.Hash, t.Sequence, t.Operations, snapshot.FormatAmount(t.Amount),
+				snapshot.FormatAmount(t.Fee))
+			if *showXDR {
+				_, err = fmt.Println(t.Envelope)
+			}
+		}
+	case "json":
+		if !*showXDR {
+			for i := range txs {
+				txs[i].Envelope = ""
+			}
+		}
+		js := json.NewEncoder(os.Stdout)
+		js.SetIndent("", "  ")
+		err = js.Encode(&simulation{plan, txs})
+	default:
+		fmt.Fprintln(os.Stderr, "Unknown format:", *format)
+		return EXIT_USAGE
+	}
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Fprintln(os.Stderr, "\nSimulation only: nothing was signed or submitted")
+	return EXIT_OK
+}
+
+// Amounts owed to the voters of the pool (and the donation targets among
+// them), from the carry-over ledger
+func owedFromHistory(conn, pool string, r *payout.Rules) error {
+	store, err := history.Open(&getvoters.ConnConfig{Conn: conn})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if r.Owed, err = store.Owed(pool); err != nil {
+		return err
+	}
+	r.OwedDonations, err = store.OwedDonations(pool)
+	return err
+}
+
+// Log every destination that isn't paid as usual (and how many are)
+func logDecisions(decisions []payout.Decision) {
+	paid := 0
+	for _, d := range decisions {
+		if d.Action == payout.ACTION_PAY {
+			paid++
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Destination %s missing: %s (%s XLM %s from %s)\n",
+			d.Payment.Destination, d.Action, snapshot.FormatAmount(d.Payment.Amount),
+			d.Payment.Kind, d.Payment.Account)
+	}
+	fmt.Fprintf(os.Stderr, "Destinations checked: %d payments to existing accounts, %d others\n",
+		paid, len(decisions) - paid)
+}
+
+// Log the voters paid in XLM for lack of a trustline (and how many aren't)
+func logFallbacks(decisions []payout.Decision) {
+	fallbacks := 0
+	for _, d := range decisions {
+		if d.Action == payout.ACTION_FALLBACK {
+			fallbacks++
+			fmt.Fprintf(os.Stderr, "No trustline with room for the asset in %s: paid %s XLM instead\n",
+				d.Payment.Destination, snapshot.FormatAmount(d.Payment.Amount))
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Trustlines checked: %d payments in the asset, %d in XLM\n",
+		len(decisions) - fallbacks, fallbacks)
+}
+
+// The trustlines of the core DB, for the asset payouts
+type coreTrustlines struct {
+	conn *getvoters.DBconn
+}
+
+func (c coreTrustlines) Trustlines(asset payout.Asset, accounts []string) (map[string]uint64, error) {
+	return c.conn.Trustlines(asset.Code, asset.Issuer, accounts)
+}
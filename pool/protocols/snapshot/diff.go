@@ -0,0 +1,179 @@
+package snapshot
+
+import (
+	"io"
+	"fmt"
+	"strconv"
+	"encoding/csv"
+	"text/tabwriter"
+)
+
+// Kinds of change of a voter between two snapshots
+const (
+	CHANGE_ADDED = "added"
+	CHANGE_REMOVED = "removed"
+	CHANGE_BALANCE = "balance"
+	CHANGE_DATA = "data"
+	CHANGE_BOTH = "balance+data"
+)
+
+// Difference of one voter between two snapshots
+type Change struct {
+	Account string `json:"account"`
+	Kind string `json:"kind"`
+	OldBalance uint64 `json:"old_balance"`
+	NewBalance uint64 `json:"new_balance"`
+	// NewBalance - OldBalance, in stroops
+	Delta int64 `json:"delta"`
+	// Data pairs (donation settings), only when they changed
+	OldData []Data `json:"old_data,omitempty"`
+	NewData []Data `json:"new_data,omitempty"`
+}
+
+// Difference between two snapshots (changes sorted by account)
+type Diff struct {
+	OldLedger int32 `json:"old_ledger"`
+	NewLedger int32 `json:"new_ledger"`
+	OldTotals Totals `json:"old_totals"`
+	NewTotals Totals `json:"new_totals"`
+	VotersDelta int64 `json:"voters_delta"`
+	VotesDelta int64 `json:"votes_delta"`
+	// Left out when one of the snapshots is live (see IsLive)
+	CreditDelta *int64 `json:"credit_delta,omitempty"`
+	Changes []Change `json:"changes"`
+}
+
+// Compare two snapshots (usually of the same pool, one inflation apart)
+func Compare(older, newer *Snapshot) *Diff {
+	older.Canonicalize()
+	newer.Canonicalize()
+
+	d := &Diff{
+		OldLedger: older.Ledger,
+		NewLedger: newer.Ledger,
+		OldTotals: older.Totals,
+		NewTotals: newer.Totals,
+		VotersDelta: delta(older.Totals.Voters, newer.Totals.Voters),
+		VotesDelta: delta(older.Totals.Votes, newer.Totals.Votes),
+	}
+	if !older.IsLive() && !newer.IsLive() {
+		credit := delta(older.Credit, newer.Credit)
+		d.CreditDelta = &credit
+	}
+
+	// Walk both sorted lists at the same time
+	i, j := 0, 0
+	for i < len(older.Entries) || j < len(newer.Entries) {
+		switch {
+		case j >= len(newer.Entries) || (i < len(older.Entries) && older.Entries[i].ID < newer.Entries[j].ID):
+			o := older.Entries[i]
+			d.Changes = append(d.Changes, Change{Account: o.ID, Kind: CHANGE_REMOVED,
+				OldBalance: o.Bal, Delta: delta(o.Bal, 0), OldData: o.Data})
+			i++
+		case i >= len(older.Entries) || newer.Entries[j].ID < older.Entries[i].ID:
+			n := newer.Entries[j]
+			d.Changes = append(d.Changes, Change{Account: n.ID, Kind: CHANGE_ADDED,
+				NewBalance: n.Bal, Delta: delta(0, n.Bal), NewData: n.Data})
+			j++
+		default:
+			o, n := older.Entries[i], newer.Entries[j]
+			c := Change{Account: o.ID, OldBalance: o.Bal, NewBalance: n.Bal,
+				Delta: delta(o.Bal, n.Bal)}
+			balance, data := o.Bal != n.Bal, !sameData(o.Data, n.Data)
+			if data {
+				c.OldData, c.NewData = o.Data, n.Data
+			}
+			switch {
+			case balance && data:
+				c.Kind = CHANGE_BOTH
+			case balance:
+				c.Kind = CHANGE_BALANCE
+			case data:
+				c.Kind = CHANGE_DATA
+			}
+			if c.Kind != "" {
+				d.Changes = append(d.Changes, c)
+			}
+			i++
+			j++
+		}
+	}
+	return d
+}
+
+// Human readable table, with the totals at the end
+func (d *Diff) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "ACCOUNT\tCHANGE\tOLD BALANCE\tNEW BALANCE\tDELTA\tDATA\n")
+	for _, c := range d.Changes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", c.Account, c.Kind,
+			FormatAmount(c.OldBalance), FormatAmount(c.NewBalance),
+			formatDelta(c.Delta), formatDataChange(c))
+	}
+	fmt.Fprintf(tw, "\nLEDGERS\t%d -> %d\n", d.OldLedger, d.NewLedger)
+	fmt.Fprintf(tw, "VOTERS\t%d -> %d\t(%+d)\n", d.OldTotals.Voters, d.NewTotals.Voters, d.VotersDelta)
+	fmt.Fprintf(tw, "VOTES\t%s -> %s\t(%s)\n", FormatAmount(d.OldTotals.Votes),
+		FormatAmount(d.NewTotals.Votes), formatDelta(d.VotesDelta))
+	if d.CreditDelta != nil {
+		fmt.Fprintf(tw, "CREDIT DELTA\t%s\n", formatDelta(*d.CreditDelta))
+	}
+	return tw.Flush()
+}
+
+// One CSV row per change (amounts in stroops, data as name=value;...)
+func (d *Diff) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"account", "kind", "old_balance", "new_balance", "delta",
+		"old_data", "new_data"})
+	for _, c := range d.Changes {
+		cw.Write([]string{c.Account, c.Kind,
+			strconv.FormatUint(c.OldBalance, 10), strconv.FormatUint(c.NewBalance, 10),
+			strconv.FormatInt(c.Delta, 10), joinData(c.OldData), joinData(c.NewData)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func delta(from, to uint64) int64 {
+	if to >= from {
+		return int64(to - from)
+	}
+	return -int64(from - to)
+}
+
+func sameData(a, b []Data) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func joinData(data []Data) string {
+	s := ""
+	for i, d := range data {
+		if i > 0 {
+			s += ";"
+		}
+		s += d.Name + "=" + d.Value
+	}
+	return s
+}
+
+func formatDataChange(c Change) string {
+	if c.OldData == nil && c.NewData == nil {
+		return ""
+	}
+	return "[" + joinData(c.OldData) + "] -> [" + joinData(c.NewData) + "]"
+}
+
+func formatDelta(d int64) string {
+	if d < 0 {
+		return "-" + FormatAmount(uint64(-d))
+	}
+	return "+" + FormatAmount(uint64(d))
+}
@@ -0,0 +1,98 @@
+package snapshot_test
+
+import (
+	"testing"
+	"github.com/stellar/go/network"
+	"github.com/matheusb-comp/go/pool/fixtures"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+func TestDiff(t *testing.T) {
+	older := &fixtures.Core{Accounts: []fixtures.Account{
+		{ID: fixtures.Address(1), Balance: 100, InflationDest: pool},
+		{ID: fixtures.Address(2), Balance: 200, InflationDest: pool},
+		{ID: fixtures.Address(3), Balance: 300, InflationDest: pool},
+		{ID: fixtures.Address(4), Balance: 400, InflationDest: pool},
+		{ID: fixtures.Address(5), Balance: 500, InflationDest: pool},
+	}}
+	newer := &fixtures.Core{Accounts: []fixtures.Account{
+		// Same balance and data
+		{ID: fixtures.Address(1), Balance: 100, InflationDest: pool},
+		// Balance up
+		{ID: fixtures.Address(2), Balance: 250, InflationDest: pool},
+		// Donation set
+		{ID: fixtures.Address(3), Balance: 300, InflationDest: pool,
+			Data: map[string]string{"lumenaut.net donation": "5%" + fixtures.Address(9)}},
+		// Balance down and donation set
+		{ID: fixtures.Address(4), Balance: 350, InflationDest: pool,
+			Data: map[string]string{"lumenaut.net donation": "5%" + fixtures.Address(9)}},
+		// 5 voted for another pool
+		{ID: fixtures.Address(5), Balance: 500, InflationDest: fixtures.Address(98)},
+		{ID: fixtures.Address(6), Balance: 600, InflationDest: pool},
+	}}
+	a, err := older.Snapshot(network.TestNetworkPassphrase, pool, DONATION_KEY, 100, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := newer.Snapshot(network.TestNetworkPassphrase, pool, DONATION_KEY, 200, 1500)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := snapshot.Compare(a, b)
+	want := map[string]struct {
+		kind string
+		delta int64
+	}{
+		fixtures.Address(2): {snapshot.CHANGE_BALANCE, 50},
+		fixtures.Address(3): {snapshot.CHANGE_DATA, 0},
+		fixtures.Address(4): {snapshot.CHANGE_BOTH, -50},
+		fixtures.Address(5): {snapshot.CHANGE_REMOVED, -500},
+		fixtures.Address(6): {snapshot.CHANGE_ADDED, 600},
+	}
+	if len(d.Changes) != len(want) {
+		t.Errorf("%d changes, want %d: %+v", len(d.Changes), len(want), d.Changes)
+	}
+	for i, c := range d.Changes {
+		if i > 0 && d.Changes[i-1].Account >= c.Account {
+			t.Errorf("changes not sorted by account at %d", i)
+		}
+		w, ok := want[c.Account]
+		if !ok {
+			t.Errorf("unexpected change of %s: %+v", c.Account, c)
+			continue
+		}
+		if c.Kind != w.kind || c.Delta != w.delta {
+			t.Errorf("%s: %s %+d, want %s %+d", c.Account, c.Kind, c.Delta, w.kind, w.delta)
+		}
+		if (c.Kind == snapshot.CHANGE_DATA || c.Kind == snapshot.CHANGE_BOTH) && len(c.NewData) != 1 {
+			t.Errorf("%s: data change without the new data", c.Account)
+		}
+	}
+
+	totals := []struct {
+		name string
+		got, want int64
+	}{
+		{"voters", d.VotersDelta, 0},
+		{"votes", d.VotesDelta, 100},
+		{"credit", *d.CreditDelta, 500},
+	}
+	for _, tt := range totals {
+		if tt.got != tt.want {
+			t.Errorf("%s delta %d, want %d", tt.name, tt.got, tt.want)
+		}
+	}
+
+	// A live snapshot has no credit to compare
+	live, err := newer.Snapshot(network.TestNetworkPassphrase, pool, DONATION_KEY, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d = snapshot.Compare(a, live); d.CreditDelta != nil {
+		t.Errorf("credit delta %d with a live snapshot", *d.CreditDelta)
+	}
+	if d = snapshot.Compare(b, b); len(d.Changes) != 0 {
+		t.Errorf("changes comparing a snapshot with itself: %+v", d.Changes)
+	}
+}
@@ -0,0 +1,272 @@
+package snapshot
+
+import (
+	"math"
+	"sort"
+	"errors"
+	"strconv"
+	"strings"
+	"io/ioutil"
+	"encoding/json"
+	"github.com/stellar/go/network"
+)
+
+// Version of the snapshot file format written by this package.
+//
+// Version 1 (all amounts in stroops, entries sorted by account, data pairs
+// sorted by name, encoded as compact JSON with the fields in this order):
+//
+//   {
+//     "version": 1,
+//     "network_passphrase": "Public Global Stellar Network ; September 2015",
+//     "pool": "G...",
+//     "ledger": 18306000,
+//     "credit": 123450000000,
+//     "totals": {"voters": 2, "votes": 30000000},
+//     "entries": [
+//       {"account": "GA...", "balance": 10000000, "data": null},
+//       {"account": "GB...", "balance": 20000000, "data": [
+//         {"dataname": "lumenaut.net donation", "datavalue": "..."}]}
+//     ],
+//     "signatures": [{"signer": "G...", "signature": "<base64>"}]
+//   }
+//
+// The signatures are optional, and sign the content hash (SHA-256 of the
+// canonical encoding without the signatures).
+const SCHEMA_VERSION = 1
+
+// Stroops in one lumen (amounts have at most 7 decimal places)
+const STROOPS_PER_UNIT = 10000000
+
+// Snapshot of the pool voters at the moment of an inflation
+type Snapshot struct {
+	Version int `json:"version"`
+	// Network the ledger belongs to
+	Network string `json:"network_passphrase"`
+	// Pool address (inflationdest of the voters)
+	Pool string `json:"pool"`
+	// Ledger where the inflation happened
+	Ledger int32 `json:"ledger"`
+	// Amount credited to the pool by the inflation, in stroops
+	Credit uint64 `json:"credit"`
+	Totals Totals `json:"totals"`
+	Entries []Entry `json:"entries"`
+	// Signatures of the content hash (see Sign)
+	Signatures []Signature `json:"signatures,omitempty"`
+}
+
+// Number of voters and sum of their balances (in stroops)
+type Totals struct {
+	Voters uint64 `json:"voters"`
+	Votes uint64 `json:"votes"`
+}
+
+// Layout of the voters.json file written by the watcher before versioning
+type legacyFile struct {
+	Ledger int32
+	Address string
+	Credit string
+	Snapshot *struct {
+		NumVoters string
+		NumVotes string
+		Voters map[string]*struct {
+			Balance string
+			Data map[string]string
+		}
+	}
+}
+
+// Create a snapshot (in canonical order) with the totals of the entries
+func New(network, pool string, ledger int32, credit uint64, entries []Entry) *Snapshot {
+	s := &Snapshot{
+		Version: SCHEMA_VERSION,
+		Network: network,
+		Pool: pool,
+		Ledger: ledger,
+		Credit: credit,
+		Entries: entries,
+	}
+	s.Totals = s.Sum()
+	s.Canonicalize()
+	return s
+}
+
+// Taken from the voters right now, not at an inflation (no ledger or credit)
+func (s *Snapshot) IsLive() bool {
+	return s.Ledger == 0
+}
+
+// Sort the entries by account and the data pairs by name
+func (s *Snapshot) Canonicalize() {
+	SortEntries(s.Entries)
+	for _, e := range s.Entries {
+		sort.Slice(e.Data, func(i, j int) bool {
+			return e.Data[i].Name < e.Data[j].Name
+		})
+	}
+}
+
+// Totals computed from the entries
+func (s *Snapshot) Sum() Totals {
+	var t Totals
+	for _, e := range s.Entries {
+		t.Voters++
+		t.Votes += e.Bal
+	}
+	return t
+}
+
+// Check the version, the pool address, and that the entries are canonical
+func (s *Snapshot) Validate() error {
+	if s.Version != SCHEMA_VERSION {
+		return errors.New("ERROR: Unsupported snapshot version " + strconv.Itoa(s.Version))
+	}
+	if len(s.Pool) != 56 || s.Pool[0] != 'G' {
+		return errors.New("ERROR: Invalid pool address in snapshot: " + s.Pool)
+	}
+	for i, e := range s.Entries {
+		if i > 0 && s.Entries[i-1].ID >= e.ID {
+			return errors.New("ERROR: Snapshot entries not sorted or repeated: " + e.ID)
+		}
+		for j := 1; j < len(e.Data); j++ {
+			if e.Data[j-1].Name >= e.Data[j].Name {
+				return errors.New("ERROR: Snapshot data not sorted or repeated: " + e.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// Sort the entries by account
+func SortEntries(entries []Entry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+}
+
+// Canonical encoding: compact JSON of the canonicalized snapshot
+func Encode(s *Snapshot) ([]byte, error) {
+	s.Canonicalize()
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(s)
+}
+
+// Decode a snapshot file, migrating the unversioned voters.json layout
+func Decode(b []byte) (*Snapshot, error) {
+	var probe struct {
+		Version *int `json:"version"`
+	}
+	if err := json.Unmarshal(b, &probe); err != nil {
+		return nil, errors.New("ERROR decoding snapshot: " + err.Error())
+	}
+	if probe.Version == nil {
+		return migrateLegacy(b)
+	}
+
+	s := new(Snapshot)
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, errors.New("ERROR decoding snapshot: " + err.Error())
+	}
+	s.Canonicalize()
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Write the snapshot to a file, in the canonical encoding
+func WriteFile(name string, s *Snapshot) error {
+	b, err := Encode(s)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, b, 0666)
+}
+
+// Read a snapshot file (in any known layout)
+func ReadFile(name string) (*Snapshot, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(b)
+}
+
+// Convert an amount ("12.3456789", as Horizon shows it) to stroops
+func ParseAmount(a string) (uint64, error) {
+	units, frac := a, ""
+	if i := strings.Index(a, "."); i >= 0 {
+		units, frac = a[:i], a[i+1:]
+	}
+	if len(frac) > 7 {
+		return 0, errors.New("ERROR: Amount with more than 7 decimal places: " + a)
+	}
+	frac += strings.Repeat("0", 7 - len(frac))
+
+	// An empty amount (or ".5") is a mistake, not zero
+	u, err := strconv.ParseUint(units, 10, 64)
+	if err != nil {
+		return 0, errors.New("ERROR parsing amount: " + a)
+	}
+	f, err := strconv.ParseUint(frac, 10, 64)
+	if err != nil {
+		return 0, errors.New("ERROR parsing amount: " + a)
+	}
+	if u > (math.MaxUint64 - f) / STROOPS_PER_UNIT {
+		return 0, errors.New("ERROR: Amount too large: " + a)
+	}
+	return u * STROOPS_PER_UNIT + f, nil
+}
+
+// Show an amount in stroops the way Horizon does ("12.3456789")
+func FormatAmount(stroops uint64) string {
+	f := strconv.FormatUint(stroops % STROOPS_PER_UNIT, 10)
+	return strconv.FormatUint(stroops / STROOPS_PER_UNIT, 10) + "." +
+		strings.Repeat("0", 7 - len(f)) + f
+}
+
+// The watcher used to write {Ledger, Address, Credit, Snapshot} with the
+// balances as strings, and only on the public network
+func migrateLegacy(b []byte) (*Snapshot, error) {
+	var old legacyFile
+	if err := json.Unmarshal(b, &old); err != nil {
+		return nil, errors.New("ERROR decoding legacy snapshot: " + err.Error())
+	}
+	if old.Snapshot == nil {
+		return nil, errors.New("ERROR: Unknown snapshot layout (no version or Snapshot)")
+	}
+
+	// The credit was saved without the decimal point (already in stroops)
+	var credit uint64
+	if old.Credit != "" {
+		var err error
+		credit, err = strconv.ParseUint(old.Credit, 10, 64)
+		if err != nil {
+			return nil, errors.New("ERROR parsing legacy credit: " + err.Error())
+		}
+	}
+
+	var entries []Entry
+	for id, v := range old.Snapshot.Voters {
+		if v == nil {
+			continue
+		}
+		bal, err := strconv.ParseUint(v.Balance, 10, 64)
+		if err != nil {
+			return nil, errors.New("ERROR parsing legacy balance of " + id + ": " + err.Error())
+		}
+		entry := Entry{ID: id, Bal: bal}
+		for name, value := range v.Data {
+			entry.Data = append(entry.Data, Data{Name: name, Value: value})
+		}
+		entries = append(entries, entry)
+	}
+
+	s := New(network.PublicNetworkPassphrase, old.Address, old.Ledger, credit, entries)
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,120 @@
+package snapshot_test
+
+import (
+	"testing"
+	"github.com/stellar/go/network"
+	"github.com/matheusb-comp/go/pool/fixtures"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+const DONATION_KEY = "lumenaut.net donation%"
+
+var pool = fixtures.Address(0)
+
+// Snapshot of the voters 1..n with the balances, taken from the core
+// stand-in (with an account voting for another pool, left out)
+func voters(t *testing.T, ledger int32, balances ...int64) *snapshot.Snapshot {
+	core := &fixtures.Core{}
+	for i, bal := range balances {
+		core.AddAccount(fixtures.Account{ID: fixtures.Address(i + 1), Balance: bal,
+			InflationDest: pool})
+	}
+	core.AddAccount(fixtures.Account{ID: fixtures.Address(99), Balance: 1000,
+		InflationDest: fixtures.Address(98)})
+	s, err := core.Snapshot(network.TestNetworkPassphrase, pool, DONATION_KEY, ledger, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		amount string
+		want uint64
+		ok bool
+	}{
+		{"12.3456789", 123456789, true},
+		{"100", 1000000000, true},
+		{"0.5", 5000000, true},
+		{"0.0000001", 1, true},
+		{"1844674407370.9551615", 18446744073709551615, true},
+		{"", 0, false},
+		{".", 0, false},
+		{".5", 0, false},
+		{"1.23456789", 0, false},
+		{"-1.5", 0, false},
+		{"1.-5", 0, false},
+		{"1e3", 0, false},
+		{"1844674407370.9551616", 0, false},
+		{"18446744073709551615", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.amount, func(t *testing.T) {
+			got, err := snapshot.ParseAmount(tt.amount)
+			if (err == nil) != tt.ok {
+				t.Fatalf("ParseAmount: %v, want ok %v", err, tt.ok)
+			}
+			if got != tt.want {
+				t.Errorf("ParseAmount = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	s := voters(t, 100, 30, 10, 20)
+	b, err := snapshot.Encode(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := `{"Ledger": 100, "Address": "` + pool + `", "Credit": "1000",
+		"Snapshot": {"NumVoters": "2", "NumVotes": "30", "Voters": {
+			"` + fixtures.Address(2) + `": {"Balance": "20", "Data": {"b": "2", "a": "1"}},
+			"` + fixtures.Address(1) + `": {"Balance": "10", "Data": null}}}}`
+
+	tests := []struct {
+		name string
+		file string
+		voters uint64
+		votes uint64
+		network string
+		ok bool
+	}{
+		{"canonical", string(b), 3, 60, network.TestNetworkPassphrase, true},
+		{"legacy voters.json", legacy, 2, 30, network.PublicNetworkPassphrase, true},
+		{"unknown version", `{"version": 2, "pool": "` + pool + `"}`, 0, 0, "", false},
+		{"no version or snapshot", `{"Ledger": 100}`, 0, 0, "", false},
+		{"invalid pool", `{"version": 1, "pool": "GABC"}`, 0, 0, "", false},
+		{"not JSON", `voters`, 0, 0, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := snapshot.Decode([]byte(tt.file))
+			if (err == nil) != tt.ok {
+				t.Fatalf("Decode: %v, want ok %v", err, tt.ok)
+			}
+			if !tt.ok {
+				return
+			}
+			if d.Totals.Voters != tt.voters || d.Totals.Votes != tt.votes || d.Totals != d.Sum() {
+				t.Errorf("totals %+v (sum %+v), want %d voters and %d votes",
+					d.Totals, d.Sum(), tt.voters, tt.votes)
+			}
+			if d.Network != tt.network || d.Ledger != 100 || d.Credit != 1000 {
+				t.Errorf("network %q, ledger %d and credit %d", d.Network, d.Ledger, d.Credit)
+			}
+			// Canonical either way, so encoding again gives the same bytes
+			again, err := snapshot.Encode(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.name == "canonical" && string(again) != tt.file {
+				t.Errorf("encoded again differently:\n%s\n%s", again, tt.file)
+			}
+			if err = d.Validate(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
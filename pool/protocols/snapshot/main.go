@@ -1,5 +1,7 @@
 package snapshot
 
+import "time"
+
 // Data structure for the main page JSON
 type Digest struct {
 	Pool string 	`json:"address"`
@@ -22,6 +24,30 @@ type VoterList struct {
 	Entries	[]Entry	`json:"entries"`
 }
 
+// Data structures for mapping the payouts of a voter JSON (amounts in stroops)
+type Payout struct {
+	Ledger int32					`json:"ledger"`
+	Date time.Time				`json:"date"`
+	Account string				`json:"account"`
+	// Balance at the snapshot, and the share of the credit it earned
+	Bal uint64						`json:"balance"`
+	Gross uint64					`json:"gross"`
+	Fee uint64						`json:"fee"`
+	Donations uint64			`json:"donations"`
+	Net uint64						`json:"net"`
+	// Owed from previous runs and added to this payout, and what was
+	// carried over to the next run instead of paid
+	Owed uint64						`json:"owed"`
+	Carried uint64				`json:"carried"`
+	// Hash of the transaction that paid it (empty if not paid yet)
+	Transaction string		`json:"transaction"`
+}
+type PayoutList struct {
+	ID string						`json:"account"`
+	Des string					`json:"inflationdest"`
+	Entries []Payout		`json:"entries"`
+}
+
 // [DEPRECATED] Function to substitute the HAL templated URI (RFC 6570)
 // Using now a more general library github.com/jtacoma/uritemplates
 // func convert(s string, cur string, lim int, asc bool) string {
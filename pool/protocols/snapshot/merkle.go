@@ -0,0 +1,191 @@
+package snapshot
+
+import (
+	"sort"
+	"bytes"
+	"errors"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Prefixes that keep a leaf from being taken for an inner node
+const MERKLE_LEAF_PREFIX = 0x00
+const MERKLE_NODE_PREFIX = 0x01
+
+// Returned when the account is not one of the snapshot entries
+var ErrNotFound = errors.New("ERROR: Account not found in snapshot")
+
+// Proof that an entry is in the snapshot with the given Merkle root.
+//
+// The leaves are SHA-256(0x00 || canonical JSON of the entry), in the order
+// of the entries (sorted by account). Each inner node is
+// SHA-256(0x01 || left || right), and a node without a sibling is moved up
+// to the next level unchanged.
+type Proof struct {
+	// Hex encoded Merkle root of the snapshot
+	Root string `json:"root"`
+	Ledger int32 `json:"ledger"`
+	Pool string `json:"pool"`
+	// The voter entry being proved (data pairs sorted by name)
+	Entry Entry `json:"entry"`
+	// Position of the entry and number of entries in the snapshot
+	Index int `json:"index"`
+	Leaves int `json:"leaves"`
+	// Siblings from the leaf up to the root
+	Path []ProofStep `json:"path"`
+}
+
+// Sibling hash in a proof, and the side it goes on
+type ProofStep struct {
+	Hash string `json:"hash"`
+	Left bool `json:"left"`
+}
+
+// Hash of one entry, as a leaf of the Merkle tree
+func LeafHash(e Entry) ([32]byte, error) {
+	b, err := json.Marshal(&e)
+	if err != nil {
+		return [32]byte{}, errors.New("ERROR encoding entry: " + err.Error())
+	}
+	return sha256.Sum256(append([]byte{MERKLE_LEAF_PREFIX}, b...)), nil
+}
+
+func nodeHash(left, right [32]byte) [32]byte {
+	b := make([]byte, 0, 65)
+	b = append(b, MERKLE_NODE_PREFIX)
+	b = append(b, left[:]...)
+	b = append(b, right[:]...)
+	return sha256.Sum256(b)
+}
+
+// Merkle tree of a snapshot, with every level from the leaves up to the
+// root. It's built once and only read after that, so a server can keep one
+// and build the proofs of concurrent requests from it
+type Tree struct {
+	snap *Snapshot
+	levels [][][32]byte
+}
+
+// Build the Merkle tree of the entries. The snapshot isn't changed, so it
+// must already be canonical (as New, Decode and Encode leave it)
+func (s *Snapshot) Tree() (*Tree, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	level := make([][32]byte, len(s.Entries))
+	for i, e := range s.Entries {
+		h, err := LeafHash(e)
+		if err != nil {
+			return nil, err
+		}
+		level[i] = h
+	}
+
+	levels := [][][32]byte{level}
+	for len(level) > 1 {
+		var next [][32]byte
+		for i := 0; i < len(level); i += 2 {
+			if i + 1 < len(level) {
+				next = append(next, nodeHash(level[i], level[i+1]))
+			} else {
+				next = append(next, level[i])
+			}
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return &Tree{snap: s, levels: levels}, nil
+}
+
+// Merkle root of the entries (all zeros for a snapshot without voters)
+func (t *Tree) Root() [32]byte {
+	top := t.levels[len(t.levels)-1]
+	if len(top) == 0 {
+		return [32]byte{}
+	}
+	return top[0]
+}
+
+// Merkle root of the entries, after putting them in canonical order
+func (s *Snapshot) MerkleRoot() ([32]byte, error) {
+	s.Canonicalize()
+	t, err := s.Tree()
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return t.Root(), nil
+}
+
+// Inclusion proof for the entry of an account (the snapshot must be
+// canonical, see Tree)
+func (s *Snapshot) Proof(account string) (*Proof, error) {
+	t, err := s.Tree()
+	if err != nil {
+		return nil, err
+	}
+	return t.Proof(account)
+}
+
+// Inclusion proof for the entry of an account
+func (t *Tree) Proof(account string) (*Proof, error) {
+	s := t.snap
+	index := sort.Search(len(s.Entries), func(i int) bool {
+		return s.Entries[i].ID >= account
+	})
+	if index == len(s.Entries) || s.Entries[index].ID != account {
+		return nil, ErrNotFound
+	}
+
+	p := &Proof{
+		Ledger: s.Ledger,
+		Pool: s.Pool,
+		Entry: s.Entries[index],
+		Index: index,
+		Leaves: len(s.Entries),
+	}
+	i := index
+	for _, level := range t.levels[:len(t.levels)-1] {
+		if i % 2 == 1 {
+			p.Path = append(p.Path, ProofStep{hex.EncodeToString(level[i-1][:]), true})
+		} else if i + 1 < len(level) {
+			p.Path = append(p.Path, ProofStep{hex.EncodeToString(level[i+1][:]), false})
+		}
+		i /= 2
+	}
+	root := t.Root()
+	p.Root = hex.EncodeToString(root[:])
+	return p, nil
+}
+
+// Check that the proof leads from the entry to the root it claims
+func VerifyProof(p *Proof) error {
+	root, err := hex.DecodeString(p.Root)
+	if err != nil || len(root) != 32 {
+		return errors.New("ERROR: Invalid Merkle root in proof")
+	}
+
+	h, err := LeafHash(p.Entry)
+	if err != nil {
+		return err
+	}
+	for _, step := range p.Path {
+		b, err := hex.DecodeString(step.Hash)
+		if err != nil || len(b) != 32 {
+			return errors.New("ERROR: Invalid hash in proof path")
+		}
+		var sibling [32]byte
+		copy(sibling[:], b)
+		if step.Left {
+			h = nodeHash(sibling, h)
+		} else {
+			h = nodeHash(h, sibling)
+		}
+	}
+
+	if !bytes.Equal(h[:], root) {
+		return errors.New("ERROR: Proof doesn't lead to the Merkle root")
+	}
+	return nil
+}
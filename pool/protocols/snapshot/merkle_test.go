@@ -0,0 +1,83 @@
+package snapshot_test
+
+import (
+	"testing"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/matheusb-comp/go/pool/fixtures"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+func TestMerkle(t *testing.T) {
+	tests := []struct {
+		name string
+		balances []int64
+	}{
+		{"one voter", []int64{10}},
+		{"two voters", []int64{10, 20}},
+		{"odd number", []int64{10, 20, 30}},
+		{"node moved up twice", []int64{10, 20, 30, 40, 50}},
+		{"full tree", []int64{1, 2, 3, 4, 5, 6, 7, 8}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := voters(t, 100, tt.balances...)
+			root, err := s.MerkleRoot()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if root == ([32]byte{}) {
+				t.Fatal("zero root")
+			}
+			for _, e := range s.Entries {
+				p, err := s.Proof(e.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if p.Root != hex.EncodeToString(root[:]) {
+					t.Errorf("%s: proof root %s, want %x", e.ID, p.Root, root)
+				}
+				if err = snapshot.VerifyProof(p); err != nil {
+					t.Errorf("%s: %v", e.ID, err)
+				}
+				// Another balance doesn't lead to the root
+				p.Entry.Bal++
+				if snapshot.VerifyProof(p) == nil {
+					t.Errorf("%s: proof of a changed entry verified", e.ID)
+				}
+			}
+
+			// Any change of a balance changes the root
+			s.Entries[len(s.Entries)-1].Bal++
+			changed, err := s.MerkleRoot()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed == root {
+				t.Error("root didn't change with a balance")
+			}
+		})
+	}
+}
+
+func TestMerkleNodes(t *testing.T) {
+	s := voters(t, 100, 10, 20)
+	root, err := s.MerkleRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	left, _ := snapshot.LeafHash(s.Entries[0])
+	right, _ := snapshot.LeafHash(s.Entries[1])
+	want := sha256.Sum256(append(append([]byte{snapshot.MERKLE_NODE_PREFIX}, left[:]...), right[:]...))
+	if root != want {
+		t.Errorf("root %x, want %x", root, want)
+	}
+
+	if _, err = s.Proof(fixtures.Address(99)); err != snapshot.ErrNotFound {
+		t.Errorf("proof of a non voter: %v, want ErrNotFound", err)
+	}
+	empty := voters(t, 100)
+	if root, err = empty.MerkleRoot(); err != nil || root != ([32]byte{}) {
+		t.Errorf("empty snapshot root %x (%v), want zeros", root, err)
+	}
+}
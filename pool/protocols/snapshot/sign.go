@@ -0,0 +1,105 @@
+package snapshot
+
+import (
+	"errors"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/base64"
+	"github.com/stellar/go/keypair"
+)
+
+// Ed25519 signature of the snapshot content hash
+type Signature struct {
+	// Public key of the signer (the pool or an auditor)
+	Signer string `json:"signer"`
+	// Base64 encoded signature
+	Signature string `json:"signature"`
+}
+
+// SHA-256 of the canonical encoding, leaving the signatures out
+func (s *Snapshot) Hash() ([32]byte, error) {
+	content := *s
+	content.Signatures = nil
+	content.Canonicalize()
+	if err := content.Validate(); err != nil {
+		return [32]byte{}, err
+	}
+
+	b, err := json.Marshal(&content)
+	if err != nil {
+		return [32]byte{}, errors.New("ERROR encoding snapshot: " + err.Error())
+	}
+	return sha256.Sum256(b), nil
+}
+
+// Content hash as a hexadecimal string
+func (s *Snapshot) HashHex() (string, error) {
+	h, err := s.Hash()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h[:]), nil
+}
+
+// Sign the content hash with a secret seed, replacing an older signature
+// from the same signer
+func (s *Snapshot) Sign(seed string) error {
+	kp, err := keypair.Parse(seed)
+	if err != nil {
+		return errors.New("ERROR parsing the signing seed: " + err.Error())
+	}
+	h, err := s.Hash()
+	if err != nil {
+		return err
+	}
+	sig, err := kp.Sign(h[:])
+	if err != nil {
+		return errors.New("ERROR signing snapshot: " + err.Error())
+	}
+
+	signature := Signature{kp.Address(), base64.StdEncoding.EncodeToString(sig)}
+	for i := range s.Signatures {
+		if s.Signatures[i].Signer == signature.Signer {
+			s.Signatures[i] = signature
+			return nil
+		}
+	}
+	s.Signatures = append(s.Signatures, signature)
+	return nil
+}
+
+// Check every signature and that the totals add up. If signers are given,
+// each of them must have signed the snapshot
+func (s *Snapshot) Verify(signers ...string) error {
+	if sum := s.Sum(); sum != s.Totals {
+		return errors.New("ERROR: Snapshot totals don't match the entries")
+	}
+	h, err := s.Hash()
+	if err != nil {
+		return err
+	}
+
+	signed := make(map[string]bool)
+	for _, sig := range s.Signatures {
+		kp, err := keypair.Parse(sig.Signer)
+		if err != nil {
+			return errors.New("ERROR parsing signer " + sig.Signer + ": " + err.Error())
+		}
+		b, err := base64.StdEncoding.DecodeString(sig.Signature)
+		if err != nil {
+			return errors.New("ERROR decoding signature of " + sig.Signer + ": " + err.Error())
+		}
+		if err = kp.Verify(h[:], b); err != nil {
+			return errors.New("ERROR: Invalid signature of " + sig.Signer)
+		}
+		signed[sig.Signer] = true
+	}
+
+	for _, signer := range signers {
+		if !signed[signer] {
+			return errors.New("ERROR: Snapshot not signed by " + signer)
+		}
+	}
+	return nil
+}
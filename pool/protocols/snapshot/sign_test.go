@@ -0,0 +1,66 @@
+package snapshot_test
+
+import (
+	"testing"
+	"github.com/matheusb-comp/go/pool/fixtures"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		name string
+		// Keypairs that sign, in order
+		signers []int
+		// Change made after signing
+		change func(s *snapshot.Snapshot)
+		// Signers Verify asks for
+		verify []int
+		signatures int
+		ok bool
+	}{
+		{"pool", []int{0}, nil, []int{0}, 1, true},
+		{"pool and auditor", []int{0, 50}, nil, []int{0, 50}, 2, true},
+		{"signed again", []int{0, 0}, nil, []int{0}, 1, true},
+		{"unsigned", nil, nil, nil, 0, true},
+		{"missing signer", []int{0}, nil, []int{50}, 1, false},
+		{"changed balance", []int{0}, func(s *snapshot.Snapshot) {
+			s.Entries[0].Bal++
+			s.Totals.Votes++
+		}, nil, 1, false},
+		{"changed data", []int{0}, func(s *snapshot.Snapshot) {
+			s.Entries[0].Data = []snapshot.Data{{Name: "lumenaut.net donation", Value: "1%" + pool}}
+		}, nil, 1, false},
+		{"changed credit", []int{0}, func(s *snapshot.Snapshot) { s.Credit++ }, nil, 1, false},
+		{"wrong totals", []int{0}, func(s *snapshot.Snapshot) { s.Totals.Voters++ }, nil, 1, false},
+		{"forged signer", []int{0}, func(s *snapshot.Snapshot) {
+			s.Signatures[0].Signer = fixtures.Address(50)
+		}, []int{50}, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := voters(t, 100, 10, 20, 30)
+			for _, n := range tt.signers {
+				if err := s.Sign(fixtures.Keypair(n).Seed()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(s.Signatures) != tt.signatures {
+				t.Errorf("%d signatures, want %d", len(s.Signatures), tt.signatures)
+			}
+			if tt.change != nil {
+				tt.change(s)
+			}
+			var signers []string
+			for _, n := range tt.verify {
+				signers = append(signers, fixtures.Address(n))
+			}
+			if err := s.Verify(signers...); (err == nil) != tt.ok {
+				t.Errorf("Verify: %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+
+	if err := voters(t, 100, 10).Sign(fixtures.Address(1)); err == nil {
+		t.Error("signed with a public key")
+	}
+}
@@ -0,0 +1,8 @@
+// Package voters has the protobuf messages and the gRPC service of the
+// voters API. The code in voters.pb.go is generated from voters.proto, run
+// this after changing it (needs protoc and protoc-gen-go in the PATH):
+//
+//	go generate github.com/matheusb-comp/go/pool/protocols/voters
+package voters
+
+//go:generate protoc --go_out=plugins=grpc:. --go_opt=paths=source_relative voters.proto
@@ -0,0 +1,719 @@
+// gRPC API of getvoters. The messages mirror the JSON types in
+// pool/protocols/snapshot (amounts in stroops)
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        v3.6.1
+// source: voters.proto
+
+package voters
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// An empty pool means the default one of the server
+type PoolRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pool          string                 `protobuf:"bytes,1,opt,name=pool,proto3" json:"pool,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PoolRequest) Reset() {
+	*x = PoolRequest{}
+	mi := &file_voters_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PoolRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PoolRequest) ProtoMessage() {}
+
+func (x *PoolRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_voters_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PoolRequest.ProtoReflect.Descriptor instead.
+func (*PoolRequest) Descriptor() ([]byte, []int) {
+	return file_voters_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *PoolRequest) GetPool() string {
+	if x != nil {
+		return x.Pool
+	}
+	return ""
+}
+
+type VoterRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pool          string                 `protobuf:"bytes,1,opt,name=pool,proto3" json:"pool,omitempty"`
+	Account       string                 `protobuf:"bytes,2,opt,name=account,proto3" json:"account,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VoterRequest) Reset() {
+	*x = VoterRequest{}
+	mi := &file_voters_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VoterRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VoterRequest) ProtoMessage() {}
+
+func (x *VoterRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_voters_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VoterRequest.ProtoReflect.Descriptor instead.
+func (*VoterRequest) Descriptor() ([]byte, []int) {
+	return file_voters_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *VoterRequest) GetPool() string {
+	if x != nil {
+		return x.Pool
+	}
+	return ""
+}
+
+func (x *VoterRequest) GetAccount() string {
+	if x != nil {
+		return x.Account
+	}
+	return ""
+}
+
+// snapshot.Digest
+type Digest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pool          string                 `protobuf:"bytes,1,opt,name=pool,proto3" json:"pool,omitempty"`
+	Voters        uint64                 `protobuf:"varint,2,opt,name=voters,proto3" json:"voters,omitempty"`
+	Votes         uint64                 `protobuf:"varint,3,opt,name=votes,proto3" json:"votes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Digest) Reset() {
+	*x = Digest{}
+	mi := &file_voters_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Digest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Digest) ProtoMessage() {}
+
+func (x *Digest) ProtoReflect() protoreflect.Message {
+	mi := &file_voters_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Digest.ProtoReflect.Descriptor instead.
+func (*Digest) Descriptor() ([]byte, []int) {
+	return file_voters_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Digest) GetPool() string {
+	if x != nil {
+		return x.Pool
+	}
+	return ""
+}
+
+func (x *Digest) GetVoters() uint64 {
+	if x != nil {
+		return x.Voters
+	}
+	return 0
+}
+
+func (x *Digest) GetVotes() uint64 {
+	if x != nil {
+		return x.Votes
+	}
+	return 0
+}
+
+// snapshot.Data
+type Data struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Value         string                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Data) Reset() {
+	*x = Data{}
+	mi := &file_voters_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Data) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Data) ProtoMessage() {}
+
+func (x *Data) ProtoReflect() protoreflect.Message {
+	mi := &file_voters_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Data.ProtoReflect.Descriptor instead.
+func (*Data) Descriptor() ([]byte, []int) {
+	return file_voters_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Data) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Data) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+// snapshot.Entry
+type Entry struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Account       string                 `protobuf:"bytes,1,opt,name=account,proto3" json:"account,omitempty"`
+	Balance       uint64                 `protobuf:"varint,2,opt,name=balance,proto3" json:"balance,omitempty"`
+	Data          []*Data                `protobuf:"bytes,3,rep,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Entry) Reset() {
+	*x = Entry{}
+	mi := &file_voters_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Entry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Entry) ProtoMessage() {}
+
+func (x *Entry) ProtoReflect() protoreflect.Message {
+	mi := &file_voters_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Entry.ProtoReflect.Descriptor instead.
+func (*Entry) Descriptor() ([]byte, []int) {
+	return file_voters_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Entry) GetAccount() string {
+	if x != nil {
+		return x.Account
+	}
+	return ""
+}
+
+func (x *Entry) GetBalance() uint64 {
+	if x != nil {
+		return x.Balance
+	}
+	return 0
+}
+
+func (x *Entry) GetData() []*Data {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+// events.Event of type INFLATION, with the pool totals at that ledger
+type InflationEvent struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Ledger int32                  `protobuf:"varint,1,opt,name=ledger,proto3" json:"ledger,omitempty"`
+	// Unix time, in seconds
+	ClosedAt      int64   `protobuf:"varint,2,opt,name=closed_at,json=closedAt,proto3" json:"closed_at,omitempty"`
+	TotalCoins    string  `protobuf:"bytes,3,opt,name=total_coins,json=totalCoins,proto3" json:"total_coins,omitempty"`
+	Totals        *Digest `protobuf:"bytes,4,opt,name=totals,proto3" json:"totals,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InflationEvent) Reset() {
+	*x = InflationEvent{}
+	mi := &file_voters_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InflationEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InflationEvent) ProtoMessage() {}
+
+func (x *InflationEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_voters_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InflationEvent.ProtoReflect.Descriptor instead.
+func (*InflationEvent) Descriptor() ([]byte, []int) {
+	return file_voters_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *InflationEvent) GetLedger() int32 {
+	if x != nil {
+		return x.Ledger
+	}
+	return 0
+}
+
+func (x *InflationEvent) GetClosedAt() int64 {
+	if x != nil {
+		return x.ClosedAt
+	}
+	return 0
+}
+
+func (x *InflationEvent) GetTotalCoins() string {
+	if x != nil {
+		return x.TotalCoins
+	}
+	return ""
+}
+
+func (x *InflationEvent) GetTotals() *Digest {
+	if x != nil {
+		return x.Totals
+	}
+	return nil
+}
+
+var File_voters_proto protoreflect.FileDescriptor
+
+const file_voters_proto_rawDesc = "" +
+	"\n" +
+	"\fvoters.proto\x12\x06voters\"!\n" +
+	"\vPoolRequest\x12\x12\n" +
+	"\x04pool\x18\x01 \x01(\tR\x04pool\"<\n" +
+	"\fVoterRequest\x12\x12\n" +
+	"\x04pool\x18\x01 \x01(\tR\x04pool\x12\x18\n" +
+	"\aaccount\x18\x02 \x01(\tR\aaccount\"J\n" +
+	"\x06Digest\x12\x12\n" +
+	"\x04pool\x18\x01 \x01(\tR\x04pool\x12\x16\n" +
+	"\x06voters\x18\x02 \x01(\x04R\x06voters\x12\x14\n" +
+	"\x05votes\x18\x03 \x01(\x04R\x05votes\"0\n" +
+	"\x04Data\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value\"]\n" +
+	"\x05Entry\x12\x18\n" +
+	"\aaccount\x18\x01 \x01(\tR\aaccount\x12\x18\n" +
+	"\abalance\x18\x02 \x01(\x04R\abalance\x12 \n" +
+	"\x04data\x18\x03 \x03(\v2\f.voters.DataR\x04data\"\x8e\x01\n" +
+	"\x0eInflationEvent\x12\x16\n" +
+	"\x06ledger\x18\x01 \x01(\x05R\x06ledger\x12\x1b\n" +
+	"\tclosed_at\x18\x02 \x01(\x03R\bclosedAt\x12\x1f\n" +
+	"\vtotal_coins\x18\x03 \x01(\tR\n" +
+	"totalCoins\x12&\n" +
+	"\x06totals\x18\x04 \x01(\v2\x0e.voters.DigestR\x06totals2\xe0\x01\n" +
+	"\x06Voters\x120\n" +
+	"\tGetTotals\x12\x13.voters.PoolRequest\x1a\x0e.voters.Digest\x122\n" +
+	"\n" +
+	"ListVoters\x12\x13.voters.PoolRequest\x1a\r.voters.Entry0\x01\x12/\n" +
+	"\bGetVoter\x12\x14.voters.VoterRequest\x1a\r.voters.Entry\x12?\n" +
+	"\x0eWatchInflation\x12\x13.voters.PoolRequest\x1a\x16.voters.InflationEvent0\x01B3Z1github.com/matheusb-comp/go/pool/protocols/votersb\x06proto3"
+
+var (
+	file_voters_proto_rawDescOnce sync.Once
+	file_voters_proto_rawDescData []byte
+)
+
+func file_voters_proto_rawDescGZIP() []byte {
+	file_voters_proto_rawDescOnce.Do(func() {
+		file_voters_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_voters_proto_rawDesc), len(file_voters_proto_rawDesc)))
+	})
+	return file_voters_proto_rawDescData
+}
+
+var file_voters_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_voters_proto_goTypes = []any{
+	(*PoolRequest)(nil),    // 0: voters.PoolRequest
+	(*VoterRequest)(nil),   // 1: voters.VoterRequest
+	(*Digest)(nil),         // 2: voters.Digest
+	(*Data)(nil),           // 3: voters.Data
+	(*Entry)(nil),          // 4: voters.Entry
+	(*InflationEvent)(nil), // 5: voters.InflationEvent
+}
+var file_voters_proto_depIdxs = []int32{
+	3, // 0: voters.Entry.data:type_name -> voters.Data
+	2, // 1: voters.InflationEvent.totals:type_name -> voters.Digest
+	0, // 2: voters.Voters.GetTotals:input_type -> voters.PoolRequest
+	0, // 3: voters.Voters.ListVoters:input_type -> voters.PoolRequest
+	1, // 4: voters.Voters.GetVoter:input_type -> voters.VoterRequest
+	0, // 5: voters.Voters.WatchInflation:input_type -> voters.PoolRequest
+	2, // 6: voters.Voters.GetTotals:output_type -> voters.Digest
+	4, // 7: voters.Voters.ListVoters:output_type -> voters.Entry
+	4, // 8: voters.Voters.GetVoter:output_type -> voters.Entry
+	5, // 9: voters.Voters.WatchInflation:output_type -> voters.InflationEvent
+	6, // [6:10] is the sub-list for method output_type
+	2, // [2:6] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_voters_proto_init() }
+func file_voters_proto_init() {
+	if File_voters_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_voters_proto_rawDesc), len(file_voters_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_voters_proto_goTypes,
+		DependencyIndexes: file_voters_proto_depIdxs,
+		MessageInfos:      file_voters_proto_msgTypes,
+	}.Build()
+	File_voters_proto = out.File
+	file_voters_proto_goTypes = nil
+	file_voters_proto_depIdxs = nil
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConnInterface
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion6
+
+// VotersClient is the client API for Voters service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type VotersClient interface {
+	// Number of voters and sum of votes of a pool
+	GetTotals(ctx context.Context, in *PoolRequest, opts ...grpc.CallOption) (*Digest, error)
+	// Every voter of a pool, sorted by account
+	ListVoters(ctx context.Context, in *PoolRequest, opts ...grpc.CallOption) (Voters_ListVotersClient, error)
+	// One voter of a pool (NOT_FOUND if the account doesn't vote for it)
+	GetVoter(ctx context.Context, in *VoterRequest, opts ...grpc.CallOption) (*Entry, error)
+	// Inflation events, as they are detected in the ledger stream
+	WatchInflation(ctx context.Context, in *PoolRequest, opts ...grpc.CallOption) (Voters_WatchInflationClient, error)
+}
+
+type votersClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewVotersClient(cc grpc.ClientConnInterface) VotersClient {
+	return &votersClient{cc}
+}
+
+func (c *votersClient) GetTotals(ctx context.Context, in *PoolRequest, opts ...grpc.CallOption) (*Digest, error) {
+	out := new(Digest)
+	err := c.cc.Invoke(ctx, "/voters.Voters/GetTotals", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *votersClient) ListVoters(ctx context.Context, in *PoolRequest, opts ...grpc.CallOption) (Voters_ListVotersClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Voters_serviceDesc.Streams[0], "/voters.Voters/ListVoters", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &votersListVotersClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Voters_ListVotersClient interface {
+	Recv() (*Entry, error)
+	grpc.ClientStream
+}
+
+type votersListVotersClient struct {
+	grpc.ClientStream
+}
+
+func (x *votersListVotersClient) Recv() (*Entry, error) {
+	m := new(Entry)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *votersClient) GetVoter(ctx context.Context, in *VoterRequest, opts ...grpc.CallOption) (*Entry, error) {
+	out := new(Entry)
+	err := c.cc.Invoke(ctx, "/voters.Voters/GetVoter", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *votersClient) WatchInflation(ctx context.Context, in *PoolRequest, opts ...grpc.CallOption) (Voters_WatchInflationClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Voters_serviceDesc.Streams[1], "/voters.Voters/WatchInflation", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &votersWatchInflationClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Voters_WatchInflationClient interface {
+	Recv() (*InflationEvent, error)
+	grpc.ClientStream
+}
+
+type votersWatchInflationClient struct {
+	grpc.ClientStream
+}
+
+func (x *votersWatchInflationClient) Recv() (*InflationEvent, error) {
+	m := new(InflationEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// VotersServer is the server API for Voters service.
+type VotersServer interface {
+	// Number of voters and sum of votes of a pool
+	GetTotals(context.Context, *PoolRequest) (*Digest, error)
+	// Every voter of a pool, sorted by account
+	ListVoters(*PoolRequest, Voters_ListVotersServer) error
+	// One voter of a pool (NOT_FOUND if the account doesn't vote for it)
+	GetVoter(context.Context, *VoterRequest) (*Entry, error)
+	// Inflation events, as they are detected in the ledger stream
+	WatchInflation(*PoolRequest, Voters_WatchInflationServer) error
+}
+
+// UnimplementedVotersServer can be embedded to have forward compatible implementations.
+type UnimplementedVotersServer struct {
+}
+
+func (*UnimplementedVotersServer) GetTotals(context.Context, *PoolRequest) (*Digest, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTotals not implemented")
+}
+func (*UnimplementedVotersServer) ListVoters(*PoolRequest, Voters_ListVotersServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListVoters not implemented")
+}
+func (*UnimplementedVotersServer) GetVoter(context.Context, *VoterRequest) (*Entry, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetVoter not implemented")
+}
+func (*UnimplementedVotersServer) WatchInflation(*PoolRequest, Voters_WatchInflationServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchInflation not implemented")
+}
+
+func RegisterVotersServer(s *grpc.Server, srv VotersServer) {
+	s.RegisterService(&_Voters_serviceDesc, srv)
+}
+
+func _Voters_GetTotals_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PoolRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VotersServer).GetTotals(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/voters.Voters/GetTotals",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VotersServer).GetTotals(ctx, req.(*PoolRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Voters_ListVoters_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(PoolRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(VotersServer).ListVoters(m, &votersListVotersServer{stream})
+}
+
+type Voters_ListVotersServer interface {
+	Send(*Entry) error
+	grpc.ServerStream
+}
+
+type votersListVotersServer struct {
+	grpc.ServerStream
+}
+
+func (x *votersListVotersServer) Send(m *Entry) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Voters_GetVoter_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VoterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VotersServer).GetVoter(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/voters.Voters/GetVoter",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VotersServer).GetVoter(ctx, req.(*VoterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Voters_WatchInflation_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(PoolRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(VotersServer).WatchInflation(m, &votersWatchInflationServer{stream})
+}
+
+type Voters_WatchInflationServer interface {
+	Send(*InflationEvent) error
+	grpc.ServerStream
+}
+
+type votersWatchInflationServer struct {
+	grpc.ServerStream
+}
+
+func (x *votersWatchInflationServer) Send(m *InflationEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _Voters_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "voters.Voters",
+	HandlerType: (*VotersServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetTotals",
+			Handler:    _Voters_GetTotals_Handler,
+		},
+		{
+			MethodName: "GetVoter",
+			Handler:    _Voters_GetVoter_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListVoters",
+			Handler:       _Voters_ListVoters_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchInflation",
+			Handler:       _Voters_WatchInflation_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "voters.proto",
+}
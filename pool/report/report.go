@@ -0,0 +1,208 @@
+package report
+
+import (
+	"io"
+	"os"
+	"time"
+	"errors"
+	"strconv"
+	"strings"
+	"encoding/hex"
+	"path/filepath"
+	"encoding/json"
+	"text/template"
+	htmltemplate "html/template"
+	"github.com/matheusb-comp/go/pool/payout"
+	"github.com/matheusb-comp/go/pool/history"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+// Placeholder of the transaction hash in the link template
+const TX_URL_HASH = "{hash}"
+
+// Audit report of one inflation run, from the snapshot, the payout plan
+// recorded with it and the journal of the submitted transactions. The
+// snapshot can be checked with poolctl verify, and the payments with
+// poolctl reconcile. The plan depends on Horizon when it was made (fees,
+// destinations, trustlines and rates), so poolctl simulate only gives the
+// same figures if those didn't change
+type Report struct {
+	Pool string
+	Network string
+	Ledger int32
+	Credit uint64
+	Totals snapshot.Totals
+	Hash string
+	MerkleRoot string
+	Signers []string
+	Plan *payout.Plan
+	// Submitted payout transactions (the journal of the history DB)
+	Transactions []history.Transaction
+	// What the run left in the carry-over, from the history DB (nil
+	// without it)
+	CarryOver []history.CarryEntry
+	// Link of a transaction, with TX_URL_HASH in place of the hash
+	TxURL string
+	Generated time.Time
+}
+
+// Report of a snapshot and its payout plan
+func New(s *snapshot.Snapshot, plan *payout.Plan, txs []history.Transaction, txURL string) (*Report, error) {
+	hash, err := s.HashHex()
+	if err != nil {
+		return nil, err
+	}
+	root, err := s.MerkleRoot()
+	if err != nil {
+		return nil, err
+	}
+	r := &Report{Pool: s.Pool, Network: s.Network, Ledger: s.Ledger, Credit: s.Credit,
+		Totals: s.Totals, Hash: hash, MerkleRoot: hex.EncodeToString(root[:]),
+		Plan: plan, Transactions: txs, TxURL: txURL, Generated: time.Now().UTC()}
+	for _, sig := range s.Signatures {
+		r.Signers = append(r.Signers, sig.Signer)
+	}
+	return r, nil
+}
+
+// Report of a run recorded in the history DB, with the plan saved with the
+// run and the journal of its transactions
+func FromHistory(store *history.Store, pool string, ledger int32, txURL string) (*Report, error) {
+	run, err := store.GetRun(pool, ledger)
+	if err != nil {
+		return nil, err
+	}
+	if len(run.Plan) == 0 {
+		return nil, errors.New("ERROR: No payout plan recorded for the run")
+	}
+	plan := new(payout.Plan)
+	if err = json.Unmarshal(run.Plan, plan); err != nil {
+		return nil, errors.New("ERROR decoding the payout plan: " + err.Error())
+	}
+	s, err := store.GetSnapshot(pool, ledger)
+	if err != nil {
+		return nil, err
+	}
+	r, err := New(s, plan, run.Transactions, txURL)
+	if err != nil {
+		return nil, err
+	}
+	if r.CarryOver, err = store.RunCarryOver(pool, ledger); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Sum of the donations forwarded: the donation payments of the journaled
+// transactions that succeeded
+func (r *Report) Donations() uint64 {
+	var total uint64
+	for _, t := range r.Transactions {
+		if t.Status != history.TX_SUCCESS {
+			continue
+		}
+		for _, pay := range t.Payments {
+			if pay.Kind == payout.PAYMENT_DONATION {
+				total += pay.Amount
+			}
+		}
+	}
+	return total
+}
+
+// Sum of the donations in the plan
+func (r *Report) PlannedDonations() uint64 {
+	var total uint64
+	for _, pay := range r.Plan.Payments {
+		if pay.Kind == payout.PAYMENT_DONATION {
+			total += pay.Amount
+		}
+	}
+	return total
+}
+
+// Transactions that failed
+func (r *Report) Failed() []history.Transaction {
+	var list []history.Transaction
+	for _, t := range r.Transactions {
+		if t.Status == history.TX_FAILED {
+			list = append(list, t)
+		}
+	}
+	return list
+}
+
+// An amount carried over to the next run
+type Carry struct {
+	Account string
+	Reason string
+	Amount uint64
+}
+
+// Amounts carried over to the next run, by account: the entries of the run
+// in the carry-over (with the failed payments, once reconciled), or what
+// the plan carries without the history DB
+func (r *Report) Carried() []Carry {
+	var list []Carry
+	if r.CarryOver != nil {
+		for _, e := range r.CarryOver {
+			if e.Amount > 0 {
+				list = append(list, Carry{e.Account, e.Reason, uint64(e.Amount)})
+			}
+		}
+		return list
+	}
+	for _, ex := range r.Plan.Exclusions {
+		if ex.Carried > 0 {
+			list = append(list, Carry{ex.Account, ex.Reason, ex.Carried})
+		}
+	}
+	return list
+}
+
+func (r *Report) Link(hash string) string {
+	return strings.Replace(r.TxURL, TX_URL_HASH, hash, -1)
+}
+
+var funcs = map[string]interface{}{
+	"amount": snapshot.FormatAmount,
+	"date": func(t time.Time) string { return t.Format(time.RFC3339) },
+}
+
+var markdown = template.Must(template.New("md").Funcs(funcs).Parse(MARKDOWN_TEMPLATE))
+var html = htmltemplate.Must(htmltemplate.New("html").Funcs(funcs).Parse(HTML_TEMPLATE))
+
+func (r *Report) WriteMarkdown(w io.Writer) error {
+	return markdown.Execute(w, r)
+}
+
+// Self-contained page (the style is inline, nothing is loaded)
+func (r *Report) WriteHTML(w io.Writer) error {
+	return html.Execute(w, r)
+}
+
+// Write report-<ledger>.md and report-<ledger>.html in the directory, and
+// return their paths
+func (r *Report) WriteFiles(dir string) ([]string, error) {
+	name := filepath.Join(dir, "report-" + strconv.Itoa(int(r.Ledger)))
+	var paths []string
+	for _, ext := range []string{".md", ".html"} {
+		write := r.WriteMarkdown
+		if ext == ".html" {
+			write = r.WriteHTML
+		}
+		f, err := os.Create(name + ext)
+		if err != nil {
+			return nil, errors.New("ERROR creating report: " + err.Error())
+		}
+		err = write(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, errors.New("ERROR writing report: " + err.Error())
+		}
+		paths = append(paths, name + ext)
+	}
+	return paths, nil
+}
@@ -0,0 +1,148 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"github.com/stellar/go/network"
+	"github.com/matheusb-comp/go/pool/payout"
+	"github.com/matheusb-comp/go/pool/history"
+	"github.com/matheusb-comp/go/pool/fixtures"
+)
+
+var (
+	pool = fixtures.Address(0)
+	voterA = fixtures.Address(1)
+	voterB = fixtures.Address(2)
+	voterC = fixtures.Address(3)
+	charity = fixtures.Address(10)
+	other = fixtures.Address(11)
+)
+
+// Report of a run of two voters donating to two targets, and one paid
+// below the minimum, with the transactions journaled and the carry-over
+// recorded
+func run(t *testing.T, txs []history.Transaction, carry []history.CarryEntry) *Report {
+	core := &fixtures.Core{}
+	core.AddAccount(fixtures.Account{ID: voterA, Balance: 100, InflationDest: pool,
+		Data: map[string]string{"lumenaut.net donation": "10%" + charity}})
+	core.AddAccount(fixtures.Account{ID: voterB, Balance: 300, InflationDest: pool,
+		Data: map[string]string{"lumenaut.net donation": "10%" + other}})
+	core.AddAccount(fixtures.Account{ID: voterC, Balance: 1, InflationDest: pool})
+	s, err := core.Snapshot(network.TestNetworkPassphrase, pool, "lumenaut.net donation%", 100, 4501)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := payout.Calculate(s, &payout.Rules{DonationKey: "lumenaut.net donation%",
+		MinPayout: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(s, plan, txs, "https://horizon/tx/" + TX_URL_HASH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.CarryOver = carry
+	return r
+}
+
+func TestReport(t *testing.T) {
+	// Fees of 5 operations (500) leave 4001: voterA gets 997 (99 to
+	// charity, 898 paid), voterB 2993 (299 to the other target, 2694 paid)
+	// and voterC 9, carried
+	donation := func(destination string, amount uint64) history.TxPayment {
+		return history.TxPayment{Kind: payout.PAYMENT_DONATION, Destination: destination, Amount: amount}
+	}
+	paidA := history.TxPayment{Kind: payout.PAYMENT_VOTER, Destination: voterA, Amount: 898}
+
+	minPayout := history.CarryEntry{Account: voterC, Reason: history.CARRY_MIN_PAYOUT, Amount: 9}
+
+	tests := []struct {
+		name string
+		txs []history.Transaction
+		carry []history.CarryEntry
+		donations uint64
+		failed int
+		// Amount carried to each account
+		carried map[string]uint64
+	}{
+		{
+			name: "not submitted, without the history DB",
+			carried: map[string]uint64{voterC: 9},
+		},
+		{
+			name: "all succeeded",
+			txs: []history.Transaction{{Hash: "aa", Status: history.TX_SUCCESS,
+				Payments: []history.TxPayment{donation(charity, 99), paidA, donation(other, 299)}}},
+			carry: []history.CarryEntry{minPayout},
+			donations: 398,
+			carried: map[string]uint64{voterC: 9},
+		},
+		{
+			name: "one failed",
+			txs: []history.Transaction{
+				{Hash: "aa", Status: history.TX_SUCCESS,
+					Payments: []history.TxPayment{donation(charity, 99), paidA}},
+				{Hash: "bb", Status: history.TX_FAILED,
+					Payments: []history.TxPayment{donation(other, 299)}},
+			},
+			carry: []history.CarryEntry{minPayout,
+				{Account: other, Reason: history.CARRY_FAILED, Amount: 299},
+				{Account: voterA, Reason: history.CARRY_TAKEN, Amount: -50},
+			},
+			donations: 99,
+			failed: 1,
+			carried: map[string]uint64{voterC: 9, other: 299},
+		},
+		{
+			name: "result unknown",
+			txs: []history.Transaction{{Hash: "aa", Status: history.TX_SUBMITTED,
+				Payments: []history.TxPayment{donation(charity, 99), donation(other, 299)}}},
+			carry: []history.CarryEntry{minPayout},
+			carried: map[string]uint64{voterC: 9},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := run(t, tt.txs, tt.carry)
+			if r.PlannedDonations() != 398 {
+				t.Errorf("planned donations %d, want 398", r.PlannedDonations())
+			}
+			if r.Donations() != tt.donations {
+				t.Errorf("donations forwarded %d, want %d", r.Donations(), tt.donations)
+			}
+			if len(r.Failed()) != tt.failed {
+				t.Errorf("%d failed, want %d", len(r.Failed()), tt.failed)
+			}
+			carried := map[string]uint64{}
+			for _, c := range r.Carried() {
+				carried[c.Account] += c.Amount
+			}
+			if len(carried) != len(tt.carried) {
+				t.Errorf("carried %v, want %v", carried, tt.carried)
+			}
+			for account, amount := range tt.carried {
+				if carried[account] != amount {
+					t.Errorf("%s: carried %d, want %d", account, carried[account], amount)
+				}
+			}
+
+			var md, html bytes.Buffer
+			if err := r.WriteMarkdown(&md); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.WriteHTML(&html); err != nil {
+				t.Fatal(err)
+			}
+			for _, tx := range tt.txs {
+				link := "https://horizon/tx/" + tx.Hash
+				if !strings.Contains(md.String(), link) || !strings.Contains(html.String(), link) {
+					t.Errorf("no link to %s", tx.Hash)
+				}
+			}
+			if !strings.Contains(md.String(), r.Hash) || !strings.Contains(html.String(), r.MerkleRoot) {
+				t.Error("snapshot hash or Merkle root missing")
+			}
+		})
+	}
+}
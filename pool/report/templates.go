@@ -0,0 +1,163 @@
+package report
+
+// Markdown report (amounts in XLM)
+const MARKDOWN_TEMPLATE = `# Inflation report: ledger {{.Ledger}}
+
+Pool ` + "`{{.Pool}}`" + ` on the network "{{.Network}}", generated {{date .Generated}}.
+
+## Summary
+
+| | |
+|---|---|
+| Inflation ledger | {{.Ledger}} |
+| Credit received | {{amount .Credit}} XLM |
+| Voters | {{.Totals.Voters}} |
+| Votes | {{amount .Totals.Votes}} XLM |
+| Distributed | {{amount .Plan.Distributed}} XLM |
+| Donations forwarded | {{amount .Donations}} XLM (of {{amount .PlannedDonations}} XLM planned) |
+| Pool fee | {{amount .Plan.PoolFee}} XLM{{with .Plan.FeeAccount}} (paid to ` + "`{{.}}`" + `){{end}} |
+| Network fees | {{amount .Plan.TxFee}} XLM ({{.Plan.Operations}} operations in {{.Plan.Transactions}} transactions) |
+| Retained by the pool | {{amount .Plan.Remainder}} XLM |
+| Carried in / out | {{amount .Plan.CarriedIn}} / {{amount .Plan.CarriedOut}} XLM |
+
+## Snapshot
+
+- Hash: ` + "`{{.Hash}}`" + `
+- Merkle root: ` + "`{{.MerkleRoot}}`" + ` (the memo of the payout transactions)
+{{- range .Signers}}
+- Signed by ` + "`{{.}}`" + `
+{{- end}}
+
+Check the snapshot with ` + "`poolctl verify`" + `, and the payments on-chain with ` + "`poolctl reconcile`" + `.
+
+## Transactions
+{{if .Transactions}}
+| Hash | Status | Submitted |
+|---|---|---|
+{{- range .Transactions}}
+| [{{.Hash}}]({{$.Link .Hash}}) | {{.Status}} | {{date .SubmittedAt}} |
+{{- end}}
+{{else}}
+No transactions submitted yet.
+{{end}}
+{{- with .Failed}}
+## Failures
+
+{{range .}}- [{{.Hash}}]({{$.Link .Hash}}) failed, its payouts are carried over
+{{end}}{{end}}
+{{- with .Carried}}
+## Carry-over
+
+| Account | Reason | Carried |
+|---|---|---|
+{{- range .}}
+| ` + "`{{.Account}}`" + ` | {{.Reason}} | {{amount .Amount}} |
+{{- end}}
+{{end}}
+{{- with .Plan.Exclusions}}
+## Not paid
+
+| Account | Balance | Reason | Owed (paid or carried) |
+|---|---|---|---|
+{{- range .}}
+| ` + "`{{.Account}}`" + ` | {{amount .Balance}} | {{.Reason}} | {{amount .Owed}} |
+{{- end}}
+{{end}}
+## Voters
+
+| Account | Balance | Gross | Fee | Donations | Net | Owed | Carried | Transaction |
+|---|---|---|---|---|---|---|---|---|
+{{- range .Plan.Payouts}}
+| ` + "`{{.Account}}`" + ` | {{amount .Bal}} | {{amount .Gross}} | {{amount .Fee}} | {{amount .Donations}} | {{amount .Net}} | {{amount .Owed}} | {{amount .Carried}} | {{if .Transaction}}[{{printf "%.8s" .Transaction}}]({{$.Link .Transaction}}){{end}} |
+{{- end}}
+`
+
+// HTML report, with the same content as the Markdown one
+const HTML_TEMPLATE = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Inflation report: ledger {{.Ledger}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 70em; color: #222; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: right; }
+th:first-child, td:first-child, code { text-align: left; font-family: monospace; }
+.failed { color: #b00; }
+</style>
+</head>
+<body>
+<h1>Inflation report: ledger {{.Ledger}}</h1>
+<p>Pool <code>{{.Pool}}</code> on the network "{{.Network}}", generated {{date .Generated}}.</p>
+
+<h2>Summary</h2>
+<table>
+<tr><td>Inflation ledger</td><td>{{.Ledger}}</td></tr>
+<tr><td>Credit received</td><td>{{amount .Credit}} XLM</td></tr>
+<tr><td>Voters</td><td>{{.Totals.Voters}}</td></tr>
+<tr><td>Votes</td><td>{{amount .Totals.Votes}} XLM</td></tr>
+<tr><td>Distributed</td><td>{{amount .Plan.Distributed}} XLM</td></tr>
+<tr><td>Donations forwarded</td><td>{{amount .Donations}} XLM (of {{amount .PlannedDonations}} XLM planned)</td></tr>
+<tr><td>Pool fee</td><td>{{amount .Plan.PoolFee}} XLM{{with .Plan.FeeAccount}} (paid to <code>{{.}}</code>){{end}}</td></tr>
+<tr><td>Network fees</td><td>{{amount .Plan.TxFee}} XLM ({{.Plan.Operations}} operations in {{.Plan.Transactions}} transactions)</td></tr>
+<tr><td>Retained by the pool</td><td>{{amount .Plan.Remainder}} XLM</td></tr>
+<tr><td>Carried in / out</td><td>{{amount .Plan.CarriedIn}} / {{amount .Plan.CarriedOut}} XLM</td></tr>
+</table>
+
+<h2>Snapshot</h2>
+<ul>
+<li>Hash: <code>{{.Hash}}</code></li>
+<li>Merkle root: <code>{{.MerkleRoot}}</code> (the memo of the payout transactions)</li>
+{{- range .Signers}}
+<li>Signed by <code>{{.}}</code></li>
+{{- end}}
+</ul>
+<p>Check the snapshot with <code>poolctl verify</code>, and the payments on-chain with <code>poolctl reconcile</code>.</p>
+
+<h2>Transactions</h2>
+{{if .Transactions}}
+<table>
+<tr><th>Hash</th><th>Status</th><th>Submitted</th></tr>
+{{- range .Transactions}}
+<tr{{if eq .Status "failed"}} class="failed"{{end}}><td><a href="{{$.Link .Hash}}">{{.Hash}}</a></td><td>{{.Status}}</td><td>{{date .SubmittedAt}}</td></tr>
+{{- end}}
+</table>
+{{else}}
+<p>No transactions submitted yet.</p>
+{{end}}
+{{- with .Failed}}
+<h2>Failures</h2>
+<ul>
+{{- range .}}
+<li class="failed"><a href="{{$.Link .Hash}}">{{.Hash}}</a> failed, its payouts are carried over</li>
+{{- end}}
+</ul>
+{{end}}
+{{- with .Carried}}
+<h2>Carry-over</h2>
+<table>
+<tr><th>Account</th><th>Reason</th><th>Carried</th></tr>
+{{- range .}}
+<tr><td>{{.Account}}</td><td>{{.Reason}}</td><td>{{amount .Amount}}</td></tr>
+{{- end}}
+</table>
+{{end}}
+{{- with .Plan.Exclusions}}
+<h2>Not paid</h2>
+<table>
+<tr><th>Account</th><th>Balance</th><th>Reason</th><th>Owed (paid or carried)</th></tr>
+{{- range .}}
+<tr><td>{{.Account}}</td><td>{{amount .Balance}}</td><td>{{.Reason}}</td><td>{{amount .Owed}}</td></tr>
+{{- end}}
+</table>
+{{end}}
+<h2>Voters</h2>
+<table>
+<tr><th>Account</th><th>Balance</th><th>Gross</th><th>Fee</th><th>Donations</th><th>Net</th><th>Owed</th><th>Carried</th><th>Transaction</th></tr>
+{{- range .Plan.Payouts}}
+<tr><td>{{.Account}}</td><td>{{amount .Bal}}</td><td>{{amount .Gross}}</td><td>{{amount .Fee}}</td><td>{{amount .Donations}}</td><td>{{amount .Net}}</td><td>{{amount .Owed}}</td><td>{{amount .Carried}}</td><td>{{if .Transaction}}<a href="{{$.Link .Transaction}}">{{printf "%.8s" .Transaction}}</a>{{end}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`
@@ -4,16 +4,30 @@ import (
   "io"
   "os"
   "fmt"
-  "log"
+  "errors"
   "flag"
-  "strings"
+  "time"
+  "syscall"
   "context"
+  "strings"
   "net/http"
+  "os/signal"
   "io/ioutil"
+  "sync/atomic"
+  "encoding/hex"
   "encoding/json"
   "github.com/jtacoma/uritemplates"
   "github.com/stellar/go/clients/horizon"
+  "github.com/matheusb-comp/go/pool/config"
+  "github.com/matheusb-comp/go/pool/notify"
+  "github.com/matheusb-comp/go/pool/report"
+  "github.com/matheusb-comp/go/pool/payout"
+  "github.com/matheusb-comp/go/pool/events"
+  "github.com/matheusb-comp/go/pool/history"
   "github.com/matheusb-comp/go/pool/getvoters"
+  "github.com/matheusb-comp/go/pool/logging"
+  "github.com/matheusb-comp/go/pool/protocols/snapshot"
+  "github.com/sirupsen/logrus"
 )
 
 // Needed because clients/horizon currently doesn't provide effects streaming
@@ -56,14 +70,6 @@ type State struct {
   Snapshot *getvoters.Data
 }
 
-// Used to create the final JSON file with all the inflation information
-type InflationData struct {
-  Ledger int32
-  Address string
-  Credit string
-  Snapshot *getvoters.Data
-}
-
 // Parameters to apply when parsing a templated Stellar URI
 const DEFAULT_TEMPLATE_CURSOR = ""
 const DEFAULT_TEMPLATE_ORDER = "asc"
@@ -74,51 +80,86 @@ var templateParams = map[string]interface{}{
   "limit": DEFAULT_TEMPLATE_LIMIT,
 }
 
+// Process exit status codes
+const EXIT_OK = 0
+const EXIT_ERROR = 1
+const EXIT_INTERRUPTED = 2
+
+// Prefix of the environment variables overriding the options
+const ENV_PREFIX = "WATCHER"
+
 // User-defined variables
-var dbUser, dbPass, dbName, dbHost, dbPort, dbConn string
-var horizonURL, defaultPool, donationKey string
+var dbConfig getvoters.ConnConfig
+var horizonURL, defaultPool string
 var errorFile, votersFile string
-// Object to get the voters snapshot from
-var conn *getvoters.DBconn
+var signSeed string
+var historyConn string
+var reportDir, reportTxURL string
+// Payout rules (donation key and pool fee included), how the fee per
+// operation is picked, and the asset the voters are paid with
+var rules payout.Rules
+var feeStrategy payout.FeeStrategy
+var assetRules payout.AssetRules
+// Secret seed to sign the payout transactions with (none: only the plan)
+var paySeed string
+var configFile string
+var logConfig logging.Config
+var networkConfig config.Network
+// Passphrase of the network, resolved when the options are loaded
+var passphrase string
+var notifyConfig notify.Config
+// Sends the lifecycle events to the configured channels (may have none)
+var notifier *notify.Dispatcher
+// Leveled logger, configured after the options are loaded
+var logger = logrus.New()
+// Applies the config file and environment over the flags
+var loader *config.Loader
+// Object to get the voters snapshot from (the core DB, or a stand-in)
+var conn getvoters.Source
+// History DB, opened once if configured (nil otherwise)
+var store *history.Store
+// Horizon client of the stream, also used to pay
+var horizonClient *horizon.Client
 // Context that will be passed to the StreamLedgers function
 var ctx context.Context
 // Cancel function to stop the stream
 var cancel context.CancelFunc
 // Current state, updated every ledger
 var curr State
+// Exit status, set when a fatal error happens
+var exitCode = EXIT_OK
+// Set to 1 when a signal asked the watcher to stop
+var interrupted int32
+// Set once the inflation snapshot is written, so a signal that came while
+// handling the inflation ledger doesn't save the state to take it again
+var inflationDone bool
+// Signals a configuration reload, checked before handling each ledger
+var reload = make(chan struct{}, 1)
 
 func init() {
 	// Database flags
-	flag.StringVar(&dbUser, "user", "stellar",
-		"PostgreSQL user name to connect as")
-
-	flag.StringVar(&dbPass, "pass", "",
-		"Password to be used if the server demands password authentication")
-
-	flag.StringVar(&dbName, "db", "core", "The database name")
+	dbConfig.RegisterFlags(flag.CommandLine)
 
-	flag.StringVar(&dbHost, "host", "localhost",
-		"Name of host to connect to. If a host name begins with a slash, " +
-		"it specifies Unix-domain communication rather than TCP/IP communication")
+	// Logging flags
+	logConfig.RegisterFlags(flag.CommandLine)
 
-	flag.StringVar(&dbPort, "port", "5432",
-		"Port number to connect to at the server host, " +
-		"or socket file name extension for Unix-domain connections")
-
-	flag.StringVar(&dbConn, "conn", "",
-		"Optional custom PostgreSQL connection string. If provided, " +
-		"it's used instead of the other flags")
+	// Notification flags (webhook, chat and email)
+	notifyConfig.RegisterFlags(flag.CommandLine)
 
 	// Stellar flags
-  flag.StringVar(&horizonURL, "horizon", "https://horizon.stellar.org",
-    "URL of a horizon server to stream ledgers")
+	networkConfig.RegisterFlags(flag.CommandLine)
+
+  flag.StringVar(&horizonURL, "horizon", "",
+    "URL of a horizon server to stream ledgers (default: the one of the network)")
 
 	flag.StringVar(&defaultPool, "pool",
 		"GCCD6AJOYZCUAQLX32ZJF2MKFFAUJ53PVCFQI3RHWKL3V47QYE2BNAUT",
 		"Default inflationdest address to use")
 
-	flag.StringVar(&donationKey, "key", "lumenaut.net donation%",
-		"Format of key for a voter data pair to mark a donation")
+	// Payout flags (donation key, eligibility, pool fee, fees and asset)
+	rules.RegisterFlags(flag.CommandLine)
+	feeStrategy.RegisterFlags(flag.CommandLine)
+	assetRules.RegisterFlags(flag.CommandLine)
 
   // Files to save the voters snapshot and the status in case of errors
   flag.StringVar(&errorFile, "error", "error.json",
@@ -126,30 +167,104 @@ func init() {
     "in case of a fatal error, to allow resuming the stream")
 
   flag.StringVar(&votersFile, "voters", "voters.json",
-    "JSON file to store the voters snapshot at the moment of inflation")
+    "JSON file to store the voters snapshot at the moment of inflation " +
+    "(in the versioned format of pool/protocols/snapshot)")
+
+  flag.StringVar(&signSeed, "seed", "",
+    "Optional secret seed to sign the snapshot with (the pool or an auditor key). " +
+    "Prefer seed_file in the config file or " + ENV_PREFIX + "_SEED_FILE, " +
+    "so the seed doesn't show up in the process list")
+
+  flag.StringVar(&paySeed, "pay-seed", "",
+    "Optional secret seed to sign and submit the payout transactions with " +
+    "(needs -history). Without it, the payout plan is only recorded. Prefer " +
+    "pay-seed_file in the config file or " + ENV_PREFIX + "_PAY_SEED_FILE")
+
+  flag.StringVar(&historyConn, "history", "",
+    "Optional PostgreSQL connection string (keyword/value or URL) of the " +
+    "history DB, to record every snapshot. Prefer history_file in the " +
+    "config file or " + ENV_PREFIX + "_HISTORY_FILE if it has a password")
+
+  flag.StringVar(&reportDir, "report-dir", "",
+    "Optional directory to write the audit report of each run " +
+    "(report-<ledger>.md and report-<ledger>.html)")
+
+  flag.StringVar(&reportTxURL, "report-tx-url", "",
+    "Link of the transactions in the report, with " + report.TX_URL_HASH +
+    " in place of the hash (default: the transaction in horizon)")
+
+  // Configuration file (options can also be set as WATCHER_<OPTION>)
+  flag.StringVar(&configFile, "config", "",
+    "YAML file with the options (keyed by flag name). Environment variables " +
+    ENV_PREFIX + "_<OPTION> override it, and flags override both. " +
+    "Run with the arguments 'config validate' to only check the configuration")
 }
 
 func main() {
-  var err error
   flag.Parse()
+  os.Exit(run())
+}
+
+// Stream ledgers until inflation, a fatal error or a signal, and return the exit status
+func run() int {
+  var err error
+
+  // Apply the config file and the environment (flags take priority)
+  loader, err = config.NewLoader(flag.CommandLine, configFile, ENV_PREFIX)
+  if err == nil {
+    err = loader.Load()
+  }
+  if err == nil {
+    err = logConfig.Apply(logger)
+  }
+  if checkFatal("Load config", err, nil) {
+    return exitCode
+  }
+
+  // Only check the configuration
+  if flag.Arg(0) == "config" && flag.Arg(1) == "validate" {
+    return validateConfig()
+  }
+
+  // Setup the notifications, so the errors below are sent too
+  notifier, err = openNotifier()
+  if checkFatal("Notifications config", err, nil) {
+    return exitCode
+  }
+
+  // Set the network passphrase and the horizon client
+  passphrase, err = networkConfig.Passphrase()
+  if checkFatal("Network config", err, nil) {
+    return exitCode
+  }
+  client, err := networkConfig.Client(horizonURL)
+  if checkFatal("Horizon client", err, nil) {
+    return exitCode
+  }
 
-  // Set the horizon network client and URL
-	client := horizon.DefaultPublicNetClient
-	client.URL = horizonURL
-
-  // Create a connection string only if one was not supplied
-	dbString := dbConn
-	if len(dbString) < 1 {
-		dbString = "dbname=" + dbName +
-		" user=" + dbUser +
-		" password=" + dbPass +
-		" host=" + dbHost +
-		" port=" + dbPort
-	}
   // Setup the database connection to get the voters
-  conn, err = getvoters.NewDBconn(dbString, defaultPool, donationKey)
-  checkFatal("Create new DBconn", err, nil)
-  defer conn.Close()
+  conn, err = openConn()
+  if checkFatal("Create new DBconn", err, nil) {
+    return exitCode
+  }
+  defer closeConn()
+
+  // The history DB records the runs and the notification deliveries
+  if historyConn != "" {
+    store, err = history.Open(&getvoters.ConnConfig{Conn: historyConn})
+    if checkFatal("Open history DB", err, nil) {
+      return exitCode
+    }
+    defer store.Close()
+  }
+
+  return stream(client)
+}
+
+// Stream ledgers from the client, taking the snapshot from conn when
+// inflation happens. Fixtures can drive the whole cycle offline through here
+func stream(client *horizon.Client) int {
+  var err error
 
   // Get the current state from the file, or stream from 'now'
   err = readFileJSON(errorFile, &curr)
@@ -160,28 +275,188 @@ func main() {
 
   // Prepare the context and cancel function
   ctx, cancel = context.WithCancel(context.Background())
+  defer cancel()
+
+  // Handle the signals while streaming
+  sigs := make(chan os.Signal, 1)
+  signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+  defer signal.Stop(sigs)
+  go handleSignals(sigs)
 
   // -- STREAM START --
+  horizonClient = client
   c := horizon.Cursor(curr.Cursor)
   err = client.StreamLedgers(ctx, &c, handleLedger)
-  checkFatal("Stream Ledgers", err, &curr)
+  // Errors caused by canceling the stream are expected
+  if ctx.Err() != nil {
+    err = nil
+  }
+  if checkFatal("Stream Ledgers", err, &curr) {
+    return exitCode
+  }
+
+  // Stopped before inflation, save the state to resume from this ledger
+  if exitCode == EXIT_OK && !inflationDone && atomic.LoadInt32(&interrupted) == 1 {
+    curr.Error = "Interrupted by signal"
+    if err = writeFileJSON(errorFile, &curr); err != nil {
+      logger.WithError(err).Error("ERROR saving " + errorFile)
+      return EXIT_ERROR
+    }
+    logger.WithField("cursor", curr.Cursor).Info("State saved in " + errorFile)
+    return EXIT_INTERRUPTED
+  }
+  return exitCode
 }
 
-// TODO: Remove - Simulate a change in TotalCoins
-var counter int
-func handleLedger(l horizon.Ledger) {
-  // TODO: Remove - Simulate a change in TotalCoins
-  counter++
-  if counter > 3 {
-    fmt.Println("RANDOM! Changing curr.totalCoins to 1")
-    curr.TotalCoins = "1"
+// SIGINT and SIGTERM stop the stream after the ledger being handled, SIGHUP reloads
+func handleSignals(sigs <-chan os.Signal) {
+  for sig := range sigs {
+    l := logger.WithField("signal", sig.String())
+    if sig == syscall.SIGHUP {
+      l.Info("Reloading configuration")
+      select {
+      case reload <- struct{}{}:
+      default:
+      }
+      continue
+    }
+    l.Info("Stopping the stream")
+    atomic.StoreInt32(&interrupted, 1)
+    cancel()
+  }
+}
+
+// Check the options that can be validated before streaming
+func validateConfig() int {
+  checks := map[string]error{
+    "network": config.ValidateNetwork(&networkConfig, horizonURL),
+    "pool": config.ValidateAccount(defaultPool),
+    "pool-fee-account": rules.FeeModel.Validate(),
+    "asset": assetRules.Validate(),
+    "pay-seed": validatePaySeed(),
+  }
+  if len(dbConfig.Conn) > 0 {
+    checks["conn"] = config.ValidateConn(dbConfig.Conn)
+  }
+  if len(dbConfig.SSLMode) > 0 {
+    checks["sslmode"] = config.ValidateSSLMode(dbConfig.SSLMode)
+  }
+  for name, err := range notifyConfig.Check() {
+    checks[name] = err
+  }
+
+  errs := config.Validate(checks)
+  for _, err := range errs {
+    logger.Error("ERROR - " + err.Error())
+  }
+  if len(errs) > 0 {
+    return EXIT_ERROR
+  }
+  logger.Info("Configuration OK")
+  return EXIT_OK
+}
+
+// Re-read the configuration and the DB connection (the network, the
+// horizon URL and the history DB only change after a restart)
+func reloadConfig() {
+  err := loader.Load()
+  if err == nil {
+    err = logConfig.Apply(logger)
+  }
+  if err != nil {
+    logger.WithError(err).Error("ERROR reloading configuration")
+    return
+  }
+  reloadNotifier()
+  reloadConn()
+}
+
+// Create the notification dispatcher, recording every delivery
+func openNotifier() (*notify.Dispatcher, error) {
+  d, err := notifyConfig.New()
+  if err != nil {
+    return nil, err
+  }
+  d.Record = recordDelivery
+  return d, nil
+}
+
+// Replace the dispatcher, keeping the old one if the new config is invalid
+func reloadNotifier() {
+  d, err := openNotifier()
+  if err != nil {
+    logger.WithError(err).Error("ERROR reloading notifications")
+    return
   }
-  // END-Remove
+  notifier = d
+}
+
+// Log the delivery, and save it in the history DB if configured
+func recordDelivery(d notify.Delivery) {
+  log := logger.WithFields(logrus.Fields{
+    logging.FIELD_LEDGER: d.Ledger,
+    logging.FIELD_POOL: d.Pool,
+    "event": d.Event,
+    "channel": d.Channel,
+    "attempts": d.Attempts,
+  })
+  if d.Status == notify.DELIVERY_SENT {
+    log.Info("Notification sent")
+  } else {
+    log.WithField("error", d.Error).Error("ERROR sending notification")
+  }
+
+  if store == nil {
+    return
+  }
+  if err := store.SaveDelivery(d); err != nil {
+    log.WithError(err).Error("ERROR recording notification delivery")
+  }
+}
+
+// Open the voters DB connection using the current configuration
+func openConn() (getvoters.Source, error) {
+  c, err := getvoters.NewDBconnConfig(&dbConfig, defaultPool, rules.DonationKey)
+  if err != nil {
+    return nil, err
+  }
+  return c, nil
+}
+
+// Replace the DB connection, keeping the old one if the new one fails
+func reloadConn() {
+  c, err := openConn()
+  if err != nil {
+    logger.WithError(err).Error("ERROR reloading DB connection")
+    return
+  }
+  closeConn()
+  conn = c
+}
+
+func closeConn() {
+  if err := conn.Close(); err != nil {
+    logger.Error(err)
+  }
+}
+
+func handleLedger(l horizon.Ledger) {
   var err error
 
+  // Apply a pending configuration reload between ledgers
+  select {
+  case <-reload:
+    reloadConfig()
+  default:
+  }
+
   // When inflation happens, the Ledger.TotalCoins changes
-  fmt.Println("Checking ledger", l.Sequence)
-  if l.TotalCoins == curr.TotalCoins || curr.TotalCoins == "" {
+  log := logger.WithFields(logrus.Fields{
+    logging.FIELD_LEDGER: l.Sequence,
+    logging.FIELD_POOL: defaultPool,
+  })
+  log.Debug("Checking ledger")
+  if !events.IsInflation(curr.TotalCoins, l.TotalCoins) {
     // Update the current state (cursor and totalCoins)
     curr.Cursor = l.PT
     curr.TotalCoins = l.TotalCoins
@@ -189,23 +464,42 @@ func handleLedger(l horizon.Ledger) {
     return
   }
   // We got inflation! -- STREAM END --
-  fmt.Println("Inflation!")
+  start := time.Now()
+  log.WithField("total_coins", l.TotalCoins).Info("Inflation!")
   if cancel != nil {
     cancel()
   }
 
-  // Get the voters snapshot, or save the cursor in case of error
+  // Get the voters snapshot right away (the balances keep changing), or
+  // save the cursor in case of error
   curr.Snapshot, err = conn.GetVoters()
-  checkFatal("GetVoters", err, &curr)
-  fmt.Println("Voters:", curr.Snapshot.NumVoters, "- Votes:", curr.Snapshot.NumVotes)
+  if checkFatal("GetVoters", err, &curr) {
+    return
+  }
+  log.WithFields(logrus.Fields{
+    "voters": curr.Snapshot.NumVoters,
+    "votes": curr.Snapshot.NumVotes,
+  }).Info("Voters snapshot taken")
+  // Delivering can take a while (retries), so only once the snapshot is taken
+  notifier.Notify(notify.Notification{
+    Event: notify.EVENT_INFLATION,
+    Pool: defaultPool,
+    Ledger: l.Sequence,
+    Message: "Inflation detected",
+    Fields: map[string]string{"total_coins": l.TotalCoins},
+  })
 
   // Extract the effects URL for this ledger (with the params applied)
   effectsURL := l.Links.Effects.Href
   if l.Links.Effects.Templated {
     template, err := uritemplates.Parse(effectsURL)
-    checkFatal("Template parse", err, &curr)
+    if checkFatal("Template parse", err, &curr) {
+      return
+    }
     effectsURL, err = template.Expand(templateParams)
-    checkFatal("Template expand", err, &curr)
+    if checkFatal("Template expand", err, &curr) {
+      return
+    }
   }
 
   // Loop the pages until Records is an empty slice
@@ -214,16 +508,19 @@ func handleLedger(l horizon.Ledger) {
     for {
       var page EffectsPage
       err = getJSON(effectsURL, &page)
-      checkFatal("GET " + effectsURL, err, &curr)
+      if checkFatal("GET " + effectsURL, err, &curr) {
+        return
+      }
 
       // Stop (get out of for) if the page has no effects
       if len(page.Embedded.Records) <= 0 {
         break LoopPages
       }
 
-      // Check the page for typeI 2 (account_credited)
+      // Check the page for typeI 2 (account_credited), in XLM (a pool paying
+      // in its own asset can be credited with it in the same ledger)
       for _, effect := range page.Embedded.Records {
-        if effect.TypeI == 2 && effect.Account == defaultPool {
+        if effect.TypeI == 2 && effect.Account == defaultPool && effect.AssetType == "native" {
           credit = effect.Amount
           // TODO: Decide - We break after finding the first credit?
           break LoopPages
@@ -233,34 +530,148 @@ func handleLedger(l horizon.Ledger) {
       // Get the next page
       effectsURL = page.Links.Next.Href
     }
-  fmt.Println("Stroops received:", credit)
-
-  // TODO: Print the final file in a better way
-  err = writeFileJSON(votersFile, InflationData{
-    l.Sequence,
-    defaultPool,
-    strings.Replace(credit, ".", "", 1),
-    curr.Snapshot})
-  checkFatal("Write " + votersFile, err, &curr)
-  // Everything went ok, we have a functional snapshot!
-  fmt.Println("Inflation snapshot successfully saved in", votersFile)
-}
+  // Without the credit there is nothing to distribute
+  if credit == "" {
+    err = errors.New("ERROR: No XLM credit of the pool in the inflation effects")
+  }
+  if checkFatal("Inflation credit", err, &curr) {
+    return
+  }
+  log.WithField("credit", credit).Info("Inflation credit found")
 
-// Log the fatal error, save all the data in files, and exit (OS.Exit(1))
-func checkFatal(msg string, err error, state *State) {
+  // Save the snapshot in the versioned format (amounts in stroops)
+  stroops, err := snapshot.ParseAmount(credit)
+  if checkFatal("Parse credit " + credit, err, &curr) {
+    return
+  }
+  entries, err := curr.Snapshot.Entries()
+  if checkFatal("Snapshot entries", err, &curr) {
+    return
+  }
+  snap := snapshot.New(passphrase, defaultPool,
+    l.Sequence, stroops, entries)
+  if signSeed != "" {
+    err = snap.Sign(signSeed)
+    if checkFatal("Sign snapshot", err, &curr) {
+      return
+    }
+  }
+  err = snapshot.WriteFile(votersFile, snap)
+  if checkFatal("Write " + votersFile, err, &curr) {
+    return
+  }
+  inflationDone = true
+  // Keep the snapshot of every run (the file is overwritten each time)
+  if store != nil {
+    if err = store.SaveSnapshot(snap); err != nil {
+      log.WithError(err).Error("ERROR saving snapshot in history")
+      exitCode = EXIT_ERROR
+    }
+  }
+
+  // We have a functional snapshot!
+  hash, _ := snap.HashHex()
+  root, _ := snap.MerkleRoot()
+  log.WithFields(logrus.Fields{
+    "file": votersFile,
+    "hash": hash,
+    "merkle_root": hex.EncodeToString(root[:]),
+    logging.FIELD_DURATION: time.Since(start).String(),
+  }).Info("Inflation snapshot successfully saved")
+  notifier.Notify(notify.Notification{
+    Event: notify.EVENT_SNAPSHOT,
+    Pool: defaultPool,
+    Ledger: l.Sequence,
+    Message: "Inflation snapshot saved",
+    Fields: map[string]string{
+      "file": votersFile,
+      "hash": hash,
+      "merkle_root": hex.EncodeToString(root[:]),
+      "credit": snapshot.FormatAmount(snap.Credit),
+      "voters": fmt.Sprint(snap.Totals.Voters),
+      "votes": snapshot.FormatAmount(snap.Totals.Votes),
+    },
+  })
+
+  // Plan the payouts, and submit them with the pay seed
+  plan, err := payRun(horizonClient, snap, log)
   if err != nil {
-    if state != nil {
-      state.Error = msg + ": " + err.Error()
-      err = writeFileJSON(errorFile, state)
-      if err != nil {
-        log.Println("### ERROR SAVING " + errorFile + " ###")
-        log.Println(state)
-        log.Println("######")
-      }
+    log.WithError(err).Error("ERROR paying the inflation")
+    exitCode = EXIT_ERROR
+  }
+
+  // The report is only informative, it doesn't fail the run
+  if reportDir != "" {
+    if err = writeReport(snap, plan); err != nil {
+      log.WithError(err).Error("ERROR writing the audit report")
+    }
+  }
+}
+
+// Write the audit report of the run, once the payouts were submitted. With
+// the history DB, the plan and the transactions recorded for the run are
+// used, otherwise the plan made in this run
+func writeReport(snap *snapshot.Snapshot, plan *payout.Plan) error {
+  txURL := reportTxURL
+  if txURL == "" {
+    server := horizonURL
+    if server == "" {
+      server = networkConfig.DefaultHorizon()
     }
-    // Print the error message received and exit with status 1
-    log.Fatalln("ERROR - " + msg + ":", err)
+    txURL = strings.TrimSuffix(server, "/") + "/transactions/" + report.TX_URL_HASH
+  }
+
+  var r *report.Report
+  var err error
+  if store != nil {
+    if r, err = report.FromHistory(store, snap.Pool, snap.Ledger, txURL); err != nil {
+      return err
+    }
+  } else if plan == nil {
+    return errors.New("ERROR: No payout plan to report")
+  } else if r, err = report.New(snap, plan, nil, txURL); err != nil {
+    return err
+  }
+
+  paths, err := r.WriteFiles(reportDir)
+  if err == nil {
+    logger.WithField("files", strings.Join(paths, ",")).Info("Audit report written")
+  }
+  return err
+}
+
+// Log the fatal error, save all the data in files, and stop the stream.
+// Returns true if there was an error, so the caller can return right away
+func checkFatal(msg string, err error, state *State) bool {
+  if err == nil {
+    return false
+  }
+  if state != nil {
+    state.Error = msg + ": " + err.Error()
+    if werr := writeFileJSON(errorFile, state); werr != nil {
+      // Log the whole state, so it can still be recovered
+      logger.WithError(werr).WithField("state", fmt.Sprintf("%+v", *state)).Error(
+        "### ERROR SAVING " + errorFile + " ###")
+    }
+  }
+  // Log the error message received and exit with status 1 (after cleanup)
+  logger.WithError(err).Error("ERROR - " + msg)
+  fields := map[string]string{"error": err.Error()}
+  if state != nil {
+    fields["state_file"] = errorFile
+    fields["cursor"] = state.Cursor
+  }
+  notifier.Notify(notify.Notification{
+    Event: notify.EVENT_FATAL,
+    Pool: defaultPool,
+    Message: msg + " failed",
+    Fields: fields,
+  })
+  exitCode = EXIT_ERROR
+  if cancel != nil {
+    cancel()
   }
+  return true
 }
 
 // Helper functions to write JSON to a file
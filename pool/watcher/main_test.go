@@ -0,0 +1,145 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+	"github.com/stellar/go/network"
+	"github.com/matheusb-comp/go/pool/fixtures"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+)
+
+// The whole cycle offline: the fake Horizon streams the ledgers and the
+// effects, the core stand-in gives the voters, and the watcher writes the
+// snapshot (or the state to resume from) and the report
+func TestStream(t *testing.T) {
+	pool := fixtures.Address(0)
+	voterA, voterB, charity := fixtures.Address(1), fixtures.Address(2), fixtures.Address(10)
+	const XLM = snapshot.STROOPS_PER_UNIT
+
+	tests := []struct {
+		name string
+		// The core DB fails
+		broken bool
+		// The pool gets its inflation credit
+		credit bool
+		// State saved by a previous run (resumes after ledger 11)
+		resume bool
+		exit int
+		// Error saved in the state file (none if empty)
+		stateError string
+	}{
+		{name: "inflation", credit: true, exit: EXIT_OK},
+		{name: "resumed from the saved state", credit: true, resume: true, exit: EXIT_OK},
+		{name: "no credit", exit: EXIT_ERROR, stateError: "Inflation credit"},
+		{name: "voters DB down", broken: true, credit: true, exit: EXIT_ERROR,
+			stateError: "GetVoters"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "watcher")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			h := fixtures.NewHorizon()
+			defer h.Close()
+			h.AddLedger(10, "100000000000.0000000")
+			before := h.AddLedger(11, "100000000000.0000000")
+			h.AddLedger(12, "100000019000.0000000")
+			// Paid in the pool's own asset in the same ledger, before the credit
+			h.AddEffects(12, fixtures.Effect{Account: pool, Type: "account_credited",
+				TypeI: fixtures.EFFECT_ACCOUNT_CREDITED, AssetType: "credit_alphanum4",
+				AssetCode: "USD", Issuer: pool, Amount: "5.0000000"})
+			if tt.credit {
+				h.Credit(12, pool, "1000.0000000")
+			}
+			for _, account := range []string{voterA, voterB, charity} {
+				err = h.AddPage("/accounts/" + account, map[string]interface{}{"id": account})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			core := &fixtures.Core{}
+			core.AddAccount(fixtures.Account{ID: voterA, Balance: 100 * XLM, InflationDest: pool,
+				Data: map[string]string{"lumenaut.net donation": "10%" + charity}})
+			core.AddAccount(fixtures.Account{ID: voterB, Balance: 300 * XLM, InflationDest: pool})
+			core.AddAccount(fixtures.Account{ID: fixtures.Address(3), Balance: 500 * XLM,
+				InflationDest: fixtures.Address(99)})
+			if tt.broken {
+				core.Err = os.ErrClosed
+			}
+
+			// The options and the state of the watcher
+			defaultPool, passphrase = pool, network.TestNetworkPassphrase
+			errorFile = filepath.Join(dir, "error.json")
+			votersFile = filepath.Join(dir, "voters.json")
+			reportDir = dir
+			conn = core.Source(pool, rules.DonationKey)
+			logger.Out = ioutil.Discard
+			curr, exitCode, inflationDone, interrupted = State{}, EXIT_OK, false, 0
+			if tt.resume {
+				err = writeFileJSON(errorFile, &State{Cursor: before.PT, TotalCoins: before.TotalCoins})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if exit := stream(h.Client()); exit != tt.exit {
+				t.Fatalf("exit status %d, want %d", exit, tt.exit)
+			}
+			var state State
+			err = readFileJSON(errorFile, &state)
+			if tt.stateError == "" {
+				if err == nil && !tt.resume {
+					t.Errorf("state saved: %+v", state)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.HasPrefix(state.Error, tt.stateError) {
+					t.Errorf("state error %q, want %s", state.Error, tt.stateError)
+				}
+				// Resumes from the ledger before inflation
+				if state.Cursor != before.PT || state.TotalCoins != before.TotalCoins {
+					t.Errorf("state cursor %s (%s), want %s", state.Cursor, state.TotalCoins, before.PT)
+				}
+			}
+			s, err := snapshot.ReadFile(votersFile)
+			if tt.exit != EXIT_OK {
+				if err == nil {
+					t.Error("snapshot written after an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if s.Ledger != 12 || s.Credit != 1000 * XLM || s.Pool != pool ||
+				s.Network != network.TestNetworkPassphrase {
+				t.Errorf("snapshot of ledger %d, credit %d, pool %s", s.Ledger, s.Credit, s.Pool)
+			}
+			if s.Totals.Voters != 2 || s.Totals.Votes != 400 * XLM {
+				t.Errorf("totals %+v, want 2 voters and 400 XLM", s.Totals)
+			}
+			if len(s.Entries) != 2 || len(s.Entries[0].Data) != 1 || s.Entries[0].ID != voterA {
+				t.Errorf("entries %+v", s.Entries)
+			}
+			report, err := ioutil.ReadFile(filepath.Join(dir, "report-12.md"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			hash, _ := s.HashHex()
+			// voterA gives 10% of its share (a quarter of the credit, less the fees)
+			if !strings.Contains(string(report), hash) || !strings.Contains(string(report), "24.9999992 XLM planned") {
+				t.Error("report without the snapshot hash or the planned donation")
+			}
+		})
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"net/http"
+	"github.com/stellar/go/strkey"
+	"github.com/stellar/go/clients/horizon"
+	"github.com/matheusb-comp/go/pool/notify"
+	"github.com/matheusb-comp/go/pool/payout"
+	"github.com/matheusb-comp/go/pool/history"
+	"github.com/matheusb-comp/go/pool/protocols/snapshot"
+	"github.com/sirupsen/logrus"
+)
+
+// Plan the payouts of the inflation snapshot and record the plan in the
+// history DB. With the pay seed, the transactions are also signed and
+// submitted, the result of each one is journaled, and the donations they
+// delivered are recorded (served in /donations)
+func payRun(client *horizon.Client, snap *snapshot.Snapshot, log *logrus.Entry) (*payout.Plan, error) {
+	plan, r, err := planPayouts(client, snap, store, log)
+	if err != nil {
+		return nil, err
+	}
+	if store != nil {
+		if err = store.SavePlan(snap.Pool, snap.Ledger, plan); err != nil {
+			return nil, err
+		}
+		if err = store.SavePayouts(snap.Pool, snap.Ledger, plan.Payouts); err != nil {
+			return nil, err
+		}
+		if err = store.SaveCarryOver(snap.Pool, snap.Ledger, carryEntries(plan)); err != nil {
+			return nil, err
+		}
+	}
+	log.WithFields(logrus.Fields{
+		"payments": len(plan.Payments),
+		"transactions": plan.Transactions,
+		"distributed": snapshot.FormatAmount(plan.Distributed),
+	}).Info("Payout plan ready")
+	notifier.Notify(notify.Notification{
+		Event: notify.EVENT_PLAN,
+		Pool: snap.Pool,
+		Ledger: snap.Ledger,
+		Message: "Payout plan ready",
+		Fields: map[string]string{
+			"payments": strconv.Itoa(len(plan.Payments)),
+			"transactions": strconv.Itoa(plan.Transactions),
+			"distributed": snapshot.FormatAmount(plan.Distributed),
+			"tx_fee": snapshot.FormatAmount(plan.TxFee),
+			"carried_out": snapshot.FormatAmount(plan.CarriedOut),
+			"submit": strconv.FormatBool(paySeed != ""),
+		},
+	})
+
+	if paySeed == "" {
+		return plan, nil
+	}
+	if store == nil {
+		return plan, errors.New("ERROR: Paying needs the history DB (-history)")
+	}
+	delivered, err := submit(client, snap, plan, r, store, log)
+	if len(delivered) > 0 {
+		if derr := saveDonations(store, snap, delivered); derr != nil && err == nil {
+			err = derr
+		}
+	}
+
+	// What the failed transactions didn't deliver is owed to the destinations
+	carried, cerr := store.ReconcileCarryOver(snap.Pool, snap.Ledger)
+	if cerr != nil {
+		if err == nil {
+			err = cerr
+		}
+		return plan, err
+	}
+	if carried > 0 {
+		log.WithField("destinations", carried).Warn("Failed payments carried over")
+	}
+	notifySubmitted(snap, plan, delivered, carried, err)
+	return plan, err
+}
+
+// Tell how the submission went (also when some transactions failed)
+func notifySubmitted(snap *snapshot.Snapshot, plan *payout.Plan, delivered []payout.Payment,
+	carried int, err error) {
+	var amount uint64
+	for _, pay := range delivered {
+		amount += pay.Amount
+	}
+	n := notify.Notification{
+		Event: notify.EVENT_SUBMITTED,
+		Pool: snap.Pool,
+		Ledger: snap.Ledger,
+		Message: "Payouts submitted",
+		Fields: map[string]string{
+			"payments": strconv.Itoa(len(delivered)) + "/" + strconv.Itoa(len(plan.Payments)),
+			"delivered": snapshot.FormatAmount(amount),
+			"carried_destinations": strconv.Itoa(carried),
+		},
+	}
+	if err != nil {
+		n.Message = "Payouts submitted with errors"
+		n.Fields["error"] = err.Error()
+	}
+	notifier.Notify(n)
+}
+
+// Calculate the payouts with the configured rules, what is owed from
+// previous runs, the fee picked by the strategy, the destinations checked
+// and the asset payments. Returns the rules the plan was made with
+func planPayouts(client *horizon.Client, snap *snapshot.Snapshot, store *history.Store,
+	log *logrus.Entry) (*payout.Plan, *payout.Rules, error) {
+	var err error
+	r := rules
+	r.Owed, r.OwedDonations, r.Created = nil, nil, nil
+	if store != nil {
+		if r.Owed, err = store.Owed(snap.Pool); err != nil {
+			return nil, nil, err
+		}
+		if r.OwedDonations, err = store.OwedDonations(snap.Pool); err != nil {
+			return nil, nil, err
+		}
+	}
+	if r.MinAge > 0 {
+		r.Created = payout.HorizonCreated(client)
+	}
+
+	// The fee per operation, estimated from the recent ledgers
+	strategy := feeStrategy
+	switch strategy.Name {
+	case payout.FEE_FIXED:
+	case payout.FEE_HORIZON:
+		strategy.Source = &payout.HorizonFees{Client: client}
+	case payout.FEE_CORE:
+		fees, ok := conn.(interface {
+			RecentFees(ledgers int) ([]uint64, error)
+		})
+		if !ok {
+			return nil, nil, errors.New("ERROR: The voters source has no fees (-fee-source core)")
+		}
+		strategy.Source = &payout.CoreFees{Conn: fees, Ledgers: strategy.Ledgers}
+	default:
+		return nil, nil, errors.New("ERROR: Unknown fee source: " + strategy.Name)
+	}
+	stats, err := strategy.Apply(&r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	asset := assetRules
+	asset.Reserve(&r)
+	plan, err := payout.Calculate(snap, &r)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan.FeeStats = stats
+
+	// A missing destination fails the whole transaction
+	decisions, err := plan.CheckDestinations(&payout.HorizonChecker{Client: client}, &r)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, d := range decisions {
+		if d.Action != payout.ACTION_PAY {
+			log.WithFields(logrus.Fields{
+				"destination": d.Payment.Destination,
+				"amount": snapshot.FormatAmount(d.Payment.Amount),
+				"action": d.Action,
+			}).Warn("Destination missing")
+		}
+	}
+
+	// Pay the voters with trustlines in the asset, the others in XLM
+	if !asset.Asset.Native() {
+		if asset.Rate == 0 && asset.Mode == payout.ASSET_PATH {
+			if asset.Rate, err = payout.HorizonRate(client, asset.Asset); err != nil {
+				return nil, nil, err
+			}
+		}
+		trustlines := &payout.HorizonTrustlines{Client: client}
+		if decisions, err = plan.PayInAsset(trustlines, &asset, &r); err != nil {
+			return nil, nil, err
+		}
+		for _, d := range decisions {
+			if d.Action == payout.ACTION_FALLBACK {
+				log.WithField("account", d.Payment.Destination).Info("Paid in XLM (no trustline)")
+			}
+		}
+	}
+	return plan, &r, nil
+}
+
+// Sign and submit the transactions of the plan, in order. Each one is
+// journaled as submitted before it's sent, then as success or failed.
+// After a failure the rest aren't sent (their sequence numbers could be
+// wrong), and they're journaled as failed. If the result isn't known (the
+// request failed, or Horizon timed out), the transaction stays submitted,
+// to be checked with poolctl reconcile. Returns the payments of the
+// transactions that succeeded
+func submit(client *horizon.Client, snap *snapshot.Snapshot, plan *payout.Plan,
+	r *payout.Rules, store *history.Store, log *logrus.Entry) ([]payout.Payment, error) {
+	seq, err := client.SequenceForAccount(snap.Pool)
+	if err != nil {
+		return nil, errors.New("ERROR getting the pool sequence number: " + err.Error())
+	}
+	root, err := snap.MerkleRoot()
+	if err != nil {
+		return nil, err
+	}
+	txs, err := plan.Build(r, uint64(seq), root)
+	if err != nil {
+		return nil, err
+	}
+
+	var delivered []payout.Payment
+
+	var stopped error
+	for _, t := range txs {
+		l := log.WithFields(logrus.Fields{"hash": t.Hash, "operations": t.Operations})
+		if stopped != nil {
+			err = store.SaveTransactionPayments(snap.Pool, snap.Ledger, t.Hash, history.TX_FAILED,
+				txPayments(t.Payments))
+			if err != nil {
+				return delivered, err
+			}
+			l.Warn("Transaction not submitted after a failure")
+			continue
+		}
+		env, err := t.Sign(paySeed, snap.Network)
+		if err != nil {
+			return delivered, err
+		}
+		err = store.SaveTransactionPayments(snap.Pool, snap.Ledger, t.Hash, history.TX_SUBMITTED,
+			txPayments(t.Payments))
+		if err != nil {
+			return delivered, err
+		}
+		if err = store.SetPayoutTransaction(snap.Pool, snap.Ledger, voters(t.Payments), t.Hash); err != nil {
+			return delivered, err
+		}
+
+		status := history.TX_SUCCESS
+		if _, err = client.SubmitTransaction(env); err != nil {
+			stopped, status = err, history.TX_SUBMITTED
+			if herr, ok := err.(*horizon.Error); ok && herr.Problem.Status == http.StatusBadRequest {
+				status = history.TX_FAILED
+				if codes, cerr := herr.ResultCodes(); cerr == nil {
+					l = l.WithFields(logrus.Fields{"result": codes.TransactionCode,
+						"operation_results": codes.OperationCodes})
+				}
+			}
+			l.WithError(err).Error("ERROR submitting payout transaction")
+		} else {
+			l.Info("Payout transaction submitted")
+			delivered = append(delivered, t.Payments...)
+		}
+		if status != history.TX_SUBMITTED {
+			if err = store.SaveTransaction(snap.Pool, snap.Ledger, t.Hash, status); err != nil {
+				return delivered, err
+			}
+		}
+	}
+	if stopped != nil {
+		return delivered, errors.New("ERROR: Payout transactions failed: " + stopped.Error())
+	}
+	return delivered, nil
+}
+
+// Record the donations delivered to each destination
+func saveDonations(store *history.Store, snap *snapshot.Snapshot, delivered []payout.Payment) error {
+	paid := &payout.Plan{Payments: delivered}
+	var donations []history.Donation
+	for _, d := range paid.DonationTotals() {
+		donations = append(donations, history.Donation{Destination: d.Destination,
+			Amount: d.Amount, Donors: d.Donors})
+	}
+	return store.SaveDonations(snap.Pool, snap.Ledger, donations)
+}
+
+// Movements of the carry-over made by the plan: what it took in, and what
+// it carried over (below the minimum payout, or for a missing destination)
+func carryEntries(plan *payout.Plan) []history.CarryEntry {
+	var entries []history.CarryEntry
+	for account, owed := range plan.Owed {
+		entries = append(entries, history.CarryEntry{Account: account,
+			Reason: history.CARRY_TAKEN, Amount: -int64(owed)})
+	}
+	for _, ex := range plan.Exclusions {
+		if ex.Carried == 0 {
+			continue
+		}
+		reason := history.CARRY_MIN_PAYOUT
+		if ex.Reason == payout.REASON_NO_DESTINATION {
+			reason = history.CARRY_NO_DESTINATION
+			if ex.Kind == payout.PAYMENT_DONATION {
+				reason = history.CARRY_NO_TARGET
+			}
+		}
+		entries = append(entries, history.CarryEntry{Account: ex.Account,
+			Reason: reason, Amount: int64(ex.Carried)})
+	}
+	return entries
+}
+
+// Payments of a transaction, as journaled
+func txPayments(payments []payout.Payment) []history.TxPayment {
+	var list []history.TxPayment
+	for _, pay := range payments {
+		list = append(list, history.TxPayment{Kind: pay.Kind,
+			Destination: pay.Destination, Amount: pay.Amount})
+	}
+	return list
+}
+
+// Voters paid by the payments of a transaction
+func voters(payments []payout.Payment) []string {
+	var list []string
+	for _, pay := range payments {
+		if pay.Kind == payout.PAYMENT_VOTER {
+			list = append(list, pay.Account)
+		}
+	}
+	return list
+}
+
+// The pay seed must be a secret seed, and the journal needs the history DB
+func validatePaySeed() error {
+	if paySeed == "" {
+		return nil
+	}
+	if _, err := strkey.Decode(strkey.VersionByteSeed, paySeed); err != nil {
+		return errors.New("ERROR: Invalid pay seed (not a secret seed)")
+	}
+	if historyConn == "" {
+		return errors.New("ERROR: Paying needs the history DB (-history)")
+	}
+	return nil
+}